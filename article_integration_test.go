@@ -17,7 +17,7 @@ func TestWebIntegration(t *testing.T) {
 		defer cleanTempFile()
 		articles := MakeBothTypesOfArticle(20)
 
-		store, closeDB := NewFileSystemStore(tmpFile, articles, []User{})
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
 		defer closeDB()
 		sessStore := StubSessionStore{}
 		server := NewServer(store, &sessStore)
@@ -45,7 +45,7 @@ func TestWebIntegration(t *testing.T) {
 
 		articles := append(progWant, otherWant...)
 
-		store, closeDB := NewFileSystemStore(tmpFile, articles, []User{})
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
 		defer closeDB()
 		sessStore := StubSessionStore{}
 		server := NewServer(store, &sessStore)
@@ -53,21 +53,25 @@ func TestWebIntegration(t *testing.T) {
 		progWant, otherWant = reverseArticles(progWant), reverseArticles(otherWant)
 
 		cases := []struct {
-			path string
-			want []Article
+			path   string
+			status int
+			want   []Article
 		}{
-			{"/", progWant[:perPage]},
-			{"/page/1", progWant[:perPage]},
-			{"/page/2", progWant[perPage : perPage*2]},
-			{"/page/-5", progWant[:perPage]},
-			{"/page/9999", progWant[len(progWant)-perPage : len(progWant)]},
-			{"/page/abc", progWant[:perPage]},
-			{"/other", otherWant[:perPage]},
-			{"/other/page/1", otherWant[:perPage]},
-			{"/other/page/2", otherWant[perPage : perPage*2]},
-			{"/other/page/-5", otherWant[:perPage]},
-			{"/other/page/9999", otherWant[len(otherWant)-perPage : len(otherWant)]},
-			{"/other/page/abc", otherWant[:perPage]},
+			{"/", 200, progWant[:defaultPerPage]},
+			{"/page/1", 200, progWant[:defaultPerPage]},
+			{"/page/2", 200, progWant[defaultPerPage : defaultPerPage*2]},
+			{"/page/5", 200, progWant[defaultPerPage*4:]},
+			{"/page/-5", 404, nil},
+			{"/page/0", 404, nil},
+			{"/page/6", 404, nil},
+			{"/page/9999", 404, nil},
+			{"/page/abc", 404, nil},
+			{"/other", 200, otherWant[:defaultPerPage]},
+			{"/other/page/1", 200, otherWant[:defaultPerPage]},
+			{"/other/page/2", 200, otherWant[defaultPerPage : defaultPerPage*2]},
+			{"/other/page/-5", 404, nil},
+			{"/other/page/9999", 404, nil},
+			{"/other/page/abc", 404, nil},
 		}
 
 		for _, c := range cases {
@@ -76,6 +80,7 @@ func TestWebIntegration(t *testing.T) {
 
 			server.ServeHTTP(resp, req)
 
+			assertStatus(t, resp.Code, c.status)
 			for _, a := range c.want {
 				assertContains(t, resp.Body.String(), a.Title)
 			}
@@ -92,12 +97,75 @@ func TestWebIntegration(t *testing.T) {
 		assertContains(t, resp.Body.String(), `<nav class="pagination" role="navigation" aria-label="pagination">`)
 	})
 
+	t.Run("pagination with a partly full last page", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+		articles := MakeArticlesOfCategory(25, time.Now().UTC(), progCat)
+
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+		defer closeDB()
+		sessStore := StubSessionStore{}
+		server := NewServer(store, &sessStore)
+
+		newestFirst := reverseArticles(articles)
+
+		t.Run("last 5 articles are on page 3", func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, "/page/3"))
+
+			assertStatus(t, resp.Code, 200)
+			for _, a := range newestFirst[20:] {
+				assertContains(t, resp.Body.String(), a.Title)
+			}
+			assertContains(t, resp.Body.String(), `href="/page/2" rel="prev"`)
+			assertNotContain(t, resp.Body.String(), `rel="next"`)
+			assertContains(t, resp.Header().Get("Link"), `</page/2>; rel="prev"`)
+			assertNotContain(t, resp.Header().Get("Link"), `rel="next"`)
+		})
+
+		t.Run("middle page links both ways", func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, "/page/2"))
+
+			assertStatus(t, resp.Code, 200)
+			assertContains(t, resp.Header().Get("Link"), `</page/1>; rel="prev"`)
+			assertContains(t, resp.Header().Get("Link"), `</page/3>; rel="next"`)
+		})
+
+		t.Run("per_page changes the page size and is kept in links", func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, "/page/5?per_page=5"))
+
+			assertStatus(t, resp.Code, 200)
+			for _, a := range newestFirst[20:] {
+				assertContains(t, resp.Body.String(), a.Title)
+			}
+			assertNotContain(t, resp.Body.String(), newestFirst[19].Title)
+			assertContains(t, resp.Body.String(), `href="/page/4?per_page=5" rel="prev"`)
+
+			resp = httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, "/page/6?per_page=5"))
+
+			assertStatus(t, resp.Code, 404)
+		})
+
+		t.Run("per_page is capped", func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, "/?per_page=1000"))
+
+			assertStatus(t, resp.Code, 200)
+			for _, a := range articles {
+				assertContains(t, resp.Body.String(), a.Title)
+			}
+		})
+	})
+
 	t.Run("index with only one page", func(t *testing.T) {
 		// No asserts, just see if it works. Caused errors because of out of bounds pagination.
-		articles := MakeArticlesOfCategory(perPage-1, time.Now(), progCat)
+		articles := MakeArticlesOfCategory(defaultPerPage-1, time.Now(), progCat)
 		tmpFile, cleanTempFile := makeTempFile()
 		defer cleanTempFile()
-		store, closeDB := NewFileSystemStore(tmpFile, articles, []User{})
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
 		defer closeDB()
 		sessStore := StubSessionStore{}
 		server := NewServer(store, &sessStore)
@@ -115,7 +183,7 @@ func TestWebIntegration(t *testing.T) {
 		defer cleanTempFile()
 		articles := MakeArticlesOfCategory(10, time.Now().UTC(), progCat)
 
-		store, closeDB := NewFileSystemStore(tmpFile, articles, []User{})
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
 		defer closeDB()
 		sessStore := StubSessionStore{}
 		server := NewServer(store, &sessStore)
@@ -141,10 +209,52 @@ func TestWebIntegration(t *testing.T) {
 		assertStatus(t, resp.Code, 404)
 	})
 
+	t.Run("article bodies containing template syntax", func(t *testing.T) {
+		bodies := []string{
+			`<p>Go templates use {{.Title}} and {{range .Items}}{{end}}.</p>`,
+			`<pre><code>{{define "body"}}oops{{end}}</code></pre>`,
+			`<p>An unclosed {{ if .LoggedIn in a sentence.</p>`,
+			`<p>Handlebars: {{#each people}}{{this}}{{/each}}</p>`,
+			`<p>{{template "main" .}}</p>`,
+		}
+
+		var articles []Article
+		for i, body := range bodies {
+			a := MakeArticleOfCategory(i, time.Now().UTC(), progCat)
+			a.Body = body
+			articles = append(articles, a)
+		}
+
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+		defer closeDB()
+		sessStore := StubSessionStore{}
+		server := NewServer(store, &sessStore)
+
+		for _, a := range articles {
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, "/"+a.Slug))
+
+			assertStatus(t, resp.Code, 200)
+			assertContains(t, resp.Body.String(), a.Title)
+			assertContains(t, resp.Body.String(), "<footer")
+		}
+
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/"+articles[0].Slug))
+		assertContains(t, resp.Body.String(), "{{.Title}} and {{range .Items}}{{end}}")
+
+		// The body must not be able to redefine or call other templates.
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/"+articles[4].Slug))
+		assertContains(t, resp.Body.String(), `{{template &#34;main&#34; .}}`)
+	})
+
 	t.Run("new article submission", func(t *testing.T) {
 		tmpFile, cleanTempFile := makeTempFile()
 		defer cleanTempFile()
-		store, closeDB := NewFileSystemStore(tmpFile, []Article{}, []User{admin})
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{admin})
 		defer closeDB()
 		sessStore := StubSessionStore{}
 		server := NewServer(store, &sessStore)
@@ -187,6 +297,7 @@ func TestWebIntegration(t *testing.T) {
 			resp := httptest.NewRecorder()
 			req, _ := http.NewRequest(http.MethodPost, "/new", strings.NewReader(data.Encode()))
 			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set(csrfHeader, testCSRFToken)
 			server.ServeHTTP(resp, req)
 
 			assertStatus(t, resp.Code, 400)
@@ -212,6 +323,7 @@ func TestWebIntegration(t *testing.T) {
 			resp = httptest.NewRecorder()
 			req, _ = http.NewRequest(http.MethodPost, "/new", strings.NewReader(data.Encode()))
 			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set(csrfHeader, testCSRFToken)
 			server.ServeHTTP(resp, req)
 
 			assertStatus(t, resp.Code, 400)
@@ -235,11 +347,13 @@ func TestWebIntegration(t *testing.T) {
 			resp := httptest.NewRecorder()
 			req, _ := http.NewRequest(http.MethodPost, "/new", strings.NewReader(data.Encode()))
 			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set(csrfHeader, testCSRFToken)
 			server.ServeHTTP(resp, req)
 
 			assertStatus(t, resp.Code, 303)
-			_, saved := store.getArticle(validArticle.Slug)
-			if saved != (Article{}) {
+			id, saved, err := store.getArticle(validArticle.Slug)
+			assertNoError(t, err)
+			if id > 0 {
 				assertArticleWithoutTime(t, saved, validArticle)
 			} else {
 				t.Error("valid article could not be found in store")
@@ -261,11 +375,11 @@ func TestWebIntegration(t *testing.T) {
 			validArticle.Published = myTimeToString(time.Now().UTC())
 			validArticle.Edited = myTimeToString(time.Now().UTC())
 
-			numOfArts := len(store.getAll())
+			numOfArts := countArticles(t, store)
 
 			// Save first article
-			store.newArticle(validArticle)
-			if len(store.getAll()) != (numOfArts + 1) {
+			assertNoError(t, store.newArticle(validArticle))
+			if countArticles(t, store) != (numOfArts + 1) {
 				t.Fatal("failed to save valid article, not finishing this test")
 			}
 
@@ -275,9 +389,10 @@ func TestWebIntegration(t *testing.T) {
 			resp := httptest.NewRecorder()
 			req, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(data.Encode()))
 			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set(csrfHeader, testCSRFToken)
 			server.ServeHTTP(resp, req)
 
-			if len(store.getAll()) != (numOfArts + 1) {
+			if countArticles(t, store) != (numOfArts + 1) {
 				t.Error("saved an article with a slug that is already in use")
 			}
 
@@ -292,9 +407,10 @@ func TestWebIntegration(t *testing.T) {
 			resp = httptest.NewRecorder()
 			req, _ = http.NewRequest(http.MethodPost, "/", strings.NewReader(data.Encode()))
 			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set(csrfHeader, testCSRFToken)
 			server.ServeHTTP(resp, req)
 
-			if len(store.getAll()) != (numOfArts + 1) {
+			if countArticles(t, store) != (numOfArts + 1) {
 				t.Error("saved an article with a slug that is already in use but with a different case")
 			}
 		})
@@ -310,7 +426,7 @@ func TestWebIntegration(t *testing.T) {
 
 			tmpFile, cleanTempFile := makeTempFile()
 			defer cleanTempFile()
-			store, closeDB := NewFileSystemStore(tmpFile, []Article{a}, []User{admin})
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{a}, []User{admin})
 			defer closeDB()
 			sessStore := StubSessionStore{}
 			server := NewServer(store, &sessStore)
@@ -349,7 +465,7 @@ func TestWebIntegration(t *testing.T) {
 
 		tmpFile, cleanTempFile := makeTempFile()
 		defer cleanTempFile()
-		store, closeDB := NewFileSystemStore(tmpFile, []Article{a}, []User{admin})
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{a}, []User{admin})
 		defer closeDB()
 		sessStore := StubSessionStore{}
 		server := NewServer(store, &sessStore)
@@ -430,11 +546,102 @@ func TestWebIntegration(t *testing.T) {
 		})
 	})
 
+	t.Run("markdown articles", func(t *testing.T) {
+		htmlArticle := newValidArticleWithTime()
+		htmlArticle.Slug = "html-article"
+
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{htmlArticle}, []User{admin})
+		defer closeDB()
+		sessStore := StubSessionStore{}
+		server := NewServer(store, &sessStore)
+		testLogin(t, server)
+
+		md := validArticleBase
+		md.Slug = "markdown-article"
+		md.Format = formatMarkdown
+		md.Body = ""
+		md.Source = "Intro with *emphasis*.\n\n| Language | Typed |\n| -------- | ----- |\n| Go       | yes   |\n\n- [x] write article"
+
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/new", setDataValues(md)))
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+
+		t.Run("source and rendered body are both stored", func(t *testing.T) {
+			_, saved, err := store.getArticle(md.Slug)
+			assertNoError(t, err)
+			if saved.Format != formatMarkdown || saved.Source != md.Source {
+				t.Errorf("got format %q and source %q, want %q and %q", saved.Format, saved.Source, formatMarkdown, md.Source)
+			}
+			assertContains(t, saved.Body, "<em>emphasis</em>")
+		})
+
+		t.Run("view shows rendered html", func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, "/"+md.Slug))
+
+			assertStatus(t, resp.Code, 200)
+			assertContains(t, resp.Body.String(), "<em>emphasis</em>")
+			assertContains(t, resp.Body.String(), "<td>Go</td>")
+			assertContains(t, resp.Body.String(), `type="checkbox"`)
+		})
+
+		t.Run("edit form shows the markdown source", func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, "/"+md.Slug+"/edit"))
+
+			assertStatus(t, resp.Code, 200)
+			assertContains(t, resp.Body.String(), template.HTMLEscapeString(md.Source)+"</textarea>")
+			assertContains(t, resp.Body.String(), `<option value="markdown" selected>`)
+		})
+
+		t.Run("editing re-renders the body", func(t *testing.T) {
+			edit := md
+			edit.Source = "Now with **bold**."
+
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newPostRequest(t, "/"+md.Slug+"/edit", setDataValues(edit)))
+			assertStatus(t, resp.Code, http.StatusSeeOther)
+
+			_, saved, err := store.getArticle(md.Slug)
+			assertNoError(t, err)
+			assertContains(t, saved.Body, "<strong>bold</strong>")
+			assertNotContain(t, saved.Body, "emphasis")
+		})
+
+		t.Run("html articles still work side by side", func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, "/"+htmlArticle.Slug))
+
+			assertStatus(t, resp.Code, 200)
+			assertContains(t, resp.Body.String(), htmlArticle.Body)
+
+			resp = httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, "/"+htmlArticle.Slug+"/edit"))
+
+			assertContains(t, resp.Body.String(), template.HTMLEscapeString(htmlArticle.Body)+"</textarea>")
+			assertContains(t, resp.Body.String(), `<option value="html" selected>`)
+		})
+
+		t.Run("unknown format is rejected", func(t *testing.T) {
+			bad := validArticleBase
+			bad.Slug = "bad-format"
+			bad.Format = "rst"
+
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newPostRequest(t, "/new", setDataValues(bad)))
+
+			assertStatus(t, resp.Code, http.StatusBadRequest)
+			assertContains(t, resp.Body.String(), errFormatInvalid)
+		})
+	})
+
 	t.Run("delete article", func(t *testing.T) {
 		articles := MakeArticlesOfCategory(10, time.Now(), progCat)
 		tmpFile, cleanTempFile := makeTempFile()
 		defer cleanTempFile()
-		store, closeDB := NewFileSystemStore(tmpFile, articles, []User{admin})
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{admin})
 		defer closeDB()
 		sessStore := StubSessionStore{}
 		server := NewServer(store, &sessStore)
@@ -458,7 +665,7 @@ func TestWebIntegration(t *testing.T) {
 
 			assertStatus(t, resp.Code, 404)
 
-			if len(store.getAll()) != len(articles) {
+			if countArticles(t, store) != len(articles) {
 				t.Error("article should not be deleted")
 			}
 		})
@@ -471,7 +678,7 @@ func TestWebIntegration(t *testing.T) {
 
 			assertStatus(t, resp.Code, 303)
 
-			if len(store.getAll()) != len(articles)-1 {
+			if countArticles(t, store) != len(articles)-1 {
 				t.Error("article not deleted")
 			}
 
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"html/template"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -21,7 +24,7 @@ func TestArticleRouting(t *testing.T) {
 		server.ServeHTTP(resp, req)
 
 		assertStatus(t, resp.Code, 200)
-		assertCalls(t, store.calls, []string{"getAll"})
+		assertCalls(t, store.calls, []string{"getAll", "getTagCounts"})
 	})
 
 	t.Run("get pages of articles, routing", func(t *testing.T) {
@@ -30,20 +33,22 @@ func TestArticleRouting(t *testing.T) {
 		server := NewServer(&store, &sessStore)
 
 		cases := []struct {
-			path string
+			path      string
+			status    int
+			callStore bool
 		}{
-			{"/"},
-			{"/page/1"},
-			{"/page/2"},
-			{"/page/-5"},
-			{"/page/9999"},
-			{"/page/abc"},
-			{"/other"},
-			{"/other/page/1"},
-			{"/other/page/2"},
-			{"/other/page/-5"},
-			{"/other/page/9999"},
-			{"/other/page/abc"},
+			{"/", 200, true},
+			{"/page/1", 200, true},
+			{"/page/2", 404, true},
+			{"/page/-5", 404, false},
+			{"/page/9999", 404, true},
+			{"/page/abc", 404, false},
+			{"/other", 200, true},
+			{"/other/page/1", 200, true},
+			{"/other/page/2", 404, true},
+			{"/other/page/-5", 404, false},
+			{"/other/page/9999", 404, true},
+			{"/other/page/abc", 404, false},
 		}
 
 		want := []string{}
@@ -54,8 +59,10 @@ func TestArticleRouting(t *testing.T) {
 
 			server.ServeHTTP(resp, req)
 
-			assertStatus(t, resp.Code, 200)
-			want = append(want, "getPage")
+			assertStatus(t, resp.Code, c.status)
+			if c.callStore {
+				want = append(want, "getPage")
+			}
 		}
 
 		assertCalls(t, store.calls, want)
@@ -85,7 +92,7 @@ func TestArticleRouting(t *testing.T) {
 
 		server.ServeHTTP(resp, req)
 
-		want = append(want, "getArticle")
+		want = append(want, "getArticle", "getRedirect")
 
 		assertStatus(t, resp.Code, 404)
 		assertCalls(t, store.calls, want)
@@ -173,6 +180,7 @@ func TestArticleRouting(t *testing.T) {
 		resp := httptest.NewRecorder()
 		req, _ := http.NewRequest(http.MethodPost, "/new", strings.NewReader(data.Encode()))
 		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(csrfHeader, testCSRFToken)
 
 		server.ServeHTTP(resp, req)
 
@@ -213,6 +221,7 @@ func TestArticleRouting(t *testing.T) {
 			resp := httptest.NewRecorder()
 			req, _ := http.NewRequest(http.MethodPost, "/new", strings.NewReader(data.Encode()))
 			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set(csrfHeader, testCSRFToken)
 
 			server.ServeHTTP(resp, req)
 
@@ -249,6 +258,7 @@ func TestArticleRouting(t *testing.T) {
 			resp := httptest.NewRecorder()
 			req, _ := http.NewRequest(http.MethodPost, "/new", strings.NewReader(data.Encode()))
 			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set(csrfHeader, testCSRFToken)
 
 			server.ServeHTTP(resp, req)
 
@@ -262,6 +272,74 @@ func TestArticleRouting(t *testing.T) {
 		}
 	})
 
+	t.Run("failed writes keep the submitted form", func(t *testing.T) {
+		existing := newValidArticleWithTime()
+		existing.Slug = "some-article"
+
+		cases := []struct {
+			name     string
+			path     string
+			writeErr error
+			status   int
+			message  string
+		}{
+			{"500 when saving a new article fails", "/new", fmt.Errorf("disk I/O error"), http.StatusInternalServerError, errSaveFailed},
+			{"409 when a new article's slug was taken", "/new", errSlugTaken, http.StatusConflict, errSlugAlreadyExists},
+			{"500 when saving an edit fails", "/some-article/edit", fmt.Errorf("disk I/O error"), http.StatusInternalServerError, errSaveFailed},
+			{"409 when an edit's slug is taken", "/some-article/edit", errSlugTaken, http.StatusConflict, errSlugAlreadyExists},
+		}
+
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				store := StubStore{articles: []Article{existing}, writeErr: c.writeErr}
+				sessStore := StubSessionStore{Sesh{Authenticated: true}}
+				server := NewServer(&store, &sessStore)
+
+				submitted := editedBase
+				resp := httptest.NewRecorder()
+				req := newPostRequest(t, c.path, setDataValues(submitted))
+				server.ServeHTTP(resp, req)
+
+				assertStatus(t, resp.Code, c.status)
+				assertContains(t, resp.Body.String(), c.message)
+				assertContains(t, resp.Body.String(), submitted.Title)
+				assertContains(t, resp.Body.String(), template.HTMLEscapeString(submitted.Body))
+				assertContains(t, resp.Body.String(), submitted.Slug)
+			})
+		}
+
+		t.Run("500 when deleting fails", func(t *testing.T) {
+			store := StubStore{articles: []Article{existing}, writeErr: fmt.Errorf("disk I/O error")}
+			sessStore := StubSessionStore{Sesh{Authenticated: true}}
+			server := NewServer(&store, &sessStore)
+
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newDeleteRequest(t, existing.Slug))
+
+			assertStatus(t, resp.Code, http.StatusInternalServerError)
+		})
+	})
+
+	t.Run("400 when a form can't be read", func(t *testing.T) {
+		existing := newValidArticleWithTime()
+		existing.Slug = "some-article"
+
+		for _, path := range []string{"/new", "/some-article/edit", "/admin/login", "/admin/categories", "/admin/categories/other/edit"} {
+			t.Run(path, func(t *testing.T) {
+				store := StubStore{articles: []Article{existing}}
+				sessStore := StubSessionStore{Sesh{Authenticated: true}}
+				server := NewServer(&store, &sessStore)
+
+				req := newPostRequest(t, path, nil)
+				req.Body = io.NopCloser(strings.NewReader("title=%zz"))
+				resp := httptest.NewRecorder()
+				server.ServeHTTP(resp, req)
+
+				assertStatus(t, resp.Code, http.StatusBadRequest)
+			})
+		}
+	})
+
 	t.Run("send POST request to /{slug}", func(t *testing.T) {
 		article := validArticleBase
 		article.Slug = "some-article"
@@ -279,6 +357,7 @@ func TestArticleRouting(t *testing.T) {
 			resp := httptest.NewRecorder()
 			req, _ := http.NewRequest(http.MethodPost, "/some-article/edit", strings.NewReader(data.Encode()))
 			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set(csrfHeader, testCSRFToken)
 			server.ServeHTTP(resp, req)
 
 			assertStatus(t, resp.Code, 303)
@@ -289,6 +368,7 @@ func TestArticleRouting(t *testing.T) {
 		t.Run("404 when trying to edit inexistent article", func(t *testing.T) {
 			resp := httptest.NewRecorder()
 			req, _ := http.NewRequest(http.MethodPost, "/does-not-exist/edit", nil)
+			req.Header.Set(csrfHeader, testCSRFToken)
 			server.ServeHTTP(resp, req)
 
 			assertStatus(t, resp.Code, http.StatusNotFound)
@@ -311,7 +391,7 @@ func TestArticleRouting(t *testing.T) {
 			server.ServeHTTP(resp, req)
 
 			assertStatus(t, resp.Code, 303)
-			assertCalls(t, store.calls, []string{"getArticle", "delete"})
+			assertCalls(t, store.calls, []string{"getArticle", "trash"})
 			store.calls = []string{}
 		})
 
@@ -10,7 +10,7 @@ func TestAuth(t *testing.T) {
 
 	tmpFile, cleanTempFile := makeTempFile()
 	defer cleanTempFile()
-	store, closeDB := NewFileSystemStore(tmpFile, []Article{}, []User{admin})
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{admin})
 	defer closeDB()
 	sessStore := StubSessionStore{}
 	server := NewServer(store, &sessStore)
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 1000
+	maxAvatarLength      = 255
+)
+
+const (
+	errProfileNameLong   = "Display name must be 64 characters or fewer"
+	errProfileBioLong    = "Bio must be 1000 characters or fewer"
+	errProfileAvatarBad  = "Avatar must be an http or https URL"
+	errProfileAvatarLong = "Avatar URL must be 255 characters or fewer"
+	profileSaved         = "Profile saved"
+)
+
+func authorPath(username string) string {
+	return "/author/" + url.PathEscape(username)
+}
+
+// An author's bio and their published articles, newest first.
+func (s *Server) AuthorPage(w http.ResponseWriter, r *http.Request) {
+	author, err := s.store.getUser(mux.Vars(r)["username"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if author.Id == 0 {
+		notFound(w)
+		return
+	}
+
+	page, ok := getPageNumber(r)
+	if !ok || page < 1 {
+		notFound(w)
+		return
+	}
+	perPage := getPerPage(r)
+
+	articles, total, err := s.store.getAuthorPage(author.Id, page, perPage)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	p := Pagination{Page: page, PerPage: perPage, Total: total}
+	if !p.InRange() {
+		notFound(w)
+		return
+	}
+
+	description := author.Bio
+	if description == "" {
+		description = "Articles by " + author.Name()
+	}
+
+	listed, err := articlesWithIsEdited(articles)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	setPaginationLinks(w, p, authorPath(author.Username))
+	v := s.viewer(w, r)
+	if DEV {
+		authorTemplate = setAuthorTemplate()
+	}
+	tmpl := authorTemplate
+	tmpl.Execute(w, struct {
+		Author   User
+		FeedPath string
+		Articles []ArticleWithIsEdited
+		PageInfo PageInfo
+		Viewer
+		Dev         bool
+		Description string
+	}{publicProfile(author), authorPath(author.Username), listed, makePageInfoObject(p, authorPath(author.Username)), v, DEV, description})
+}
+
+// Only the parts of a user that are shown to visitors.
+func publicProfile(u User) User {
+	return User{Username: u.Username, DisplayName: u.Name(), Bio: u.Bio, Avatar: u.Avatar}
+}
+
+func (s *Server) ProfileForm(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	profileForm(w, http.StatusOK, user, nil, "", s.viewer(w, r))
+}
+
+// Saves the display_name, bio and avatar fields to the logged in user's profile.
+func (s *Server) SaveProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	user.DisplayName = strings.TrimSpace(r.FormValue("display_name"))
+	user.Bio = strings.TrimSpace(r.FormValue("bio"))
+	user.Avatar = strings.TrimSpace(r.FormValue("avatar"))
+
+	if errors := validateProfile(user); len(errors) != 0 {
+		profileForm(w, http.StatusBadRequest, user, errors, "", s.viewer(w, r))
+		return
+	}
+	if err := s.store.setProfile(user.Username, user); err != nil {
+		serverError(w, err)
+		return
+	}
+	profileForm(w, http.StatusOK, user, nil, profileSaved, s.viewer(w, r))
+}
+
+func validateProfile(u User) []string {
+	var errors []string
+	if utf8.RuneCountInString(u.DisplayName) > maxDisplayNameLength {
+		errors = append(errors, errProfileNameLong)
+	}
+	if utf8.RuneCountInString(u.Bio) > maxBioLength {
+		errors = append(errors, errProfileBioLong)
+	}
+	if len(u.Avatar) > maxAvatarLength {
+		errors = append(errors, errProfileAvatarLong)
+	} else if u.Avatar != "" && !isWebURL(u.Avatar) {
+		errors = append(errors, errProfileAvatarBad)
+	}
+	return errors
+}
+
+// Only absolute http and https URLs, so an avatar can't be a javascript: or data: URL.
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func profileForm(w http.ResponseWriter, status int, user User, errors []string, message string, v Viewer) {
+	if DEV {
+		profileTemplate = setProfileTemplate()
+	}
+	w.WriteHeader(status)
+	tmpl := profileTemplate
+	tmpl.Execute(w, struct {
+		User    User
+		Errors  []string
+		Message string
+		Viewer
+		Dev         bool
+		Description string
+	}{user, errors, message, v, DEV, defaultDescription})
+}
+
+func setAuthorTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/pagination.html", "static/templates/articleSummary.html", "static/templates/author.html"))
+}
+
+func setProfileTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/profile.html"))
+}
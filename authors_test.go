@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var profileRoleRoutes = []roleRoute{
+	{"GET", "/admin/profile", allowAnyone, true},
+	{"POST", "/admin/profile", allowAnyone, false},
+}
+
+// Two articles by author, one by ed and a draft by author. Users are from makeRoleUsers.
+func makeAuthoredArticles() []Article {
+	articles := MakeArticlesOfCategory(4, time.Now().UTC(), progCat)
+	for i, id := range []int{3, 3, 2, 3} {
+		articles[i].AuthorID = id
+	}
+	articles[3].Status = statusDraft
+	return articles
+}
+
+func TestFileSystemStoreAuthors(t *testing.T) {
+	articles := makeAuthoredArticles()
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, makeRoleUsers())
+	defer closeDB()
+	assertNoError(t, store.setProfile("author", User{DisplayName: "Ann Author", Bio: "Writes about Go."}))
+
+	t.Run("articles have their author", func(t *testing.T) {
+		_, got, err := store.getArticle(articles[0].Slug)
+		assertNoError(t, err)
+		if got.Author != "author" || got.AuthorName != "Ann Author" {
+			t.Errorf("got author %q named %q, want author named Ann Author", got.Author, got.AuthorName)
+		}
+
+		_, got, err = store.getArticle(articles[2].Slug)
+		assertNoError(t, err)
+		if got.AuthorName != "ed" {
+			t.Errorf("got author name %q, want the username without a display name", got.AuthorName)
+		}
+
+		all, err := store.getAll()
+		assertNoError(t, err)
+		for _, a := range all {
+			if a.Author == "" {
+				t.Errorf("%s has no author in listings", a.Slug)
+			}
+		}
+	})
+
+	t.Run("profile", func(t *testing.T) {
+		u, err := store.getUser("author")
+		assertNoError(t, err)
+		if u.Name() != "Ann Author" || u.Bio != "Writes about Go." {
+			t.Errorf("got %q with bio %q", u.Name(), u.Bio)
+		}
+	})
+
+	t.Run("author pages only have their published articles", func(t *testing.T) {
+		got, total, err := store.getAuthorPage(3, 1, 1)
+		assertNoError(t, err)
+		assertInt(t, total, 2)
+		assertInt(t, len(got), 1)
+		assertContains(t, got[0].Slug, articles[1].Slug)
+
+		latest, err := store.getLatestByAuthor(3, feedLength)
+		assertNoError(t, err)
+		assertInt(t, len(latest), 2)
+	})
+
+	t.Run("deleted authors' articles have no author", func(t *testing.T) {
+		assertNoError(t, store.deleteUser("author"))
+		_, got, err := store.getArticle(articles[0].Slug)
+		assertNoError(t, err)
+		if got.Author != "" || got.AuthorName != "" {
+			t.Errorf("got author %q named %q, want none", got.Author, got.AuthorName)
+		}
+	})
+}
+
+func TestAuthorRoutes(t *testing.T) {
+	articles := makeAuthoredArticles()
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, makeRoleUsers())
+	defer closeDB()
+	assertNoError(t, store.setProfile("author", User{DisplayName: "Ann Author", Bio: "Writes about Go.", Avatar: "https://example.com/ann.png"}))
+	sessStore := StubSessionStore{}
+	server := NewServer(store, &sessStore)
+
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, path))
+		return resp
+	}
+
+	t.Run("author page", func(t *testing.T) {
+		resp := get(t, "/author/author")
+		assertStatus(t, resp.Code, http.StatusOK)
+		body := resp.Body.String()
+		assertContains(t, body, "Ann Author")
+		assertContains(t, body, "Writes about Go.")
+		assertContains(t, body, `src="https://example.com/ann.png"`)
+		assertContains(t, body, `href="/author/author/feed.xml"`)
+		assertContains(t, body, articles[0].Title)
+		assertNotContain(t, body, articles[2].Title)
+		assertNotContain(t, body, articles[3].Title)
+	})
+
+	t.Run("author pages are paginated", func(t *testing.T) {
+		resp := get(t, "/author/author/page/2?per_page=1")
+		assertStatus(t, resp.Code, http.StatusOK)
+		assertContains(t, resp.Body.String(), articles[0].Title)
+
+		assertStatus(t, get(t, "/author/author/page/3?per_page=1").Code, http.StatusNotFound)
+	})
+
+	t.Run("unknown authors", func(t *testing.T) {
+		assertStatus(t, get(t, "/author/nobody").Code, http.StatusNotFound)
+		assertStatus(t, get(t, "/author/nobody/feed.xml").Code, http.StatusNotFound)
+	})
+
+	t.Run("bylines", func(t *testing.T) {
+		body := get(t, "/"+articles[0].Slug).Body.String()
+		assertContains(t, body, `By <a href="/author/author" rel="author">Ann Author</a>`)
+
+		body = get(t, "/").Body.String()
+		assertContains(t, body, "By Ann Author")
+	})
+
+	t.Run("author feeds", func(t *testing.T) {
+		for _, feed := range []string{feedRSS, feedAtom, feedJSON} {
+			resp := get(t, "/author/author/"+feed)
+			assertStatus(t, resp.Code, http.StatusOK)
+			body := resp.Body.String()
+			assertContains(t, body, "Gorocode - Ann Author")
+			assertContains(t, body, articles[0].Slug)
+			assertNotContain(t, body, articles[2].Slug)
+		}
+	})
+
+	t.Run("profile needs a login", func(t *testing.T) {
+		assertStatus(t, get(t, "/admin/profile").Code, http.StatusSeeOther)
+	})
+
+	sessStore.sesh = Sesh{Name: "ed", Authenticated: true}
+
+	t.Run("edit profile", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			avatar string
+			bio    string
+			code   int
+			want   string
+		}{
+			{"javascript avatar", "javascript:alert(1)", "", http.StatusBadRequest, errProfileAvatarBad},
+			{"relative avatar", "/static/images/me.png", "", http.StatusBadRequest, errProfileAvatarBad},
+			{"long avatar", "https://example.com/" + strings.Repeat("a", maxAvatarLength), "", http.StatusBadRequest, errProfileAvatarLong},
+			{"long bio", "", strings.Repeat("a", maxBioLength+1), http.StatusBadRequest, errProfileBioLong},
+			{"valid", "https://example.com/ed.png", "Edits things.", http.StatusOK, profileSaved},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				data := url.Values{"display_name": {"Ed Editor"}, "avatar": {c.avatar}, "bio": {c.bio}}
+				resp := httptest.NewRecorder()
+				server.ServeHTTP(resp, newPostRequest(t, "/admin/profile", data))
+				assertStatus(t, resp.Code, c.code)
+				assertContains(t, resp.Body.String(), c.want)
+			})
+		}
+
+		assertContains(t, get(t, "/author/ed").Body.String(), "Edits things.")
+	})
+}
+
+func TestAuthorSlugReserved(t *testing.T) {
+	assertArticleSlugReserved(t, "author")
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const maxCategoryNameLength = 32
+
+const (
+	errCatNameEmpty     = "Category name cannot be empty"
+	errCatNameLong      = "Category name cannot be longer than 32 characters"
+	errCatSlugBad       = "Category slug must contain a letter or number"
+	errCatSlugReserved  = "Category slug is reserved, please choose another"
+	errCatSlugIsArticle = "Category slug is already being used by an article"
+	errCatTaken         = "Name or slug is already being used by another category"
+	errCatSortOrder     = "Sort order must be a whole number"
+	errCatNotEmpty      = "Category still has articles, choose a category to move them to"
+	errCatMoveInvalid   = "Choose a different category to move the articles to"
+	errCatLast          = "There must be at least one category"
+	errCatSaveFailed    = "Category could not be saved, please try again"
+)
+
+// Returned by Store category writes.
+var (
+	errCategoryTaken    = errors.New("category name or slug is already in use")
+	errCategoryNotEmpty = errors.New("category still has articles")
+	errLastCategory     = errors.New("can't delete the last category")
+)
+
+type Category struct {
+	Id          int
+	Name        string
+	Slug        string
+	Description string
+	SortOrder   int
+	// Number of articles in the category.
+	Count int
+	// The first category by SortOrder, shown on the home page.
+	Home bool
+}
+
+var categorySlugIllegal = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// "Web Dev" becomes "web-dev". Returns an empty string if nothing usable is left.
+func categorySlug(name string) string {
+	slug := strings.ToLower(strings.TrimSpace(name))
+	slug = categorySlugIllegal.ReplaceAllString(slug, "-")
+	return strings.Trim(slug, "-")
+}
+
+// The path of the first page of the category's index.
+func (c Category) Path() string {
+	if c.Home {
+		return "/"
+	}
+	return "/" + c.Slug
+}
+
+// Used by templates to list the categories, e.g. in the nav bar.
+func (s *Server) navCategories() []Category {
+	categories, err := s.store.getCategories()
+	if err != nil {
+		log.Print(err)
+	}
+	return categories
+}
+
+// Matches /{category} only when a category has the slug, so article slugs fall through to ArticleView.
+func (s *Server) isCategoryPath(r *http.Request, rm *mux.RouteMatch) bool {
+	c, err := s.store.getCategory(strings.Trim(r.URL.Path, "/"))
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	return c.Slug != ""
+}
+
+func (s *Server) HomePage(w http.ResponseWriter, r *http.Request) {
+	categories, err := s.store.getCategories()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if len(categories) == 0 {
+		notFound(w)
+		return
+	}
+	s.categoryIndexPage(w, r, categories[0])
+}
+
+func (s *Server) CategoryIndexPage(w http.ResponseWriter, r *http.Request) {
+	c, err := s.store.getCategory(mux.Vars(r)["category"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if c.Slug == "" {
+		notFound(w)
+		return
+	}
+	// The home category lives at / so it isn't listed under two urls.
+	if c.Home {
+		target := "/"
+		if page := mux.Vars(r)["page"]; page != "" {
+			target += "page/" + page
+		}
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+	s.categoryIndexPage(w, r, c)
+}
+
+func (s *Server) categoryIndexPage(w http.ResponseWriter, r *http.Request, c Category) {
+	page, ok := getPageNumber(r)
+	if !ok || page < 1 {
+		notFound(w)
+		return
+	}
+	perPage := getPerPage(r)
+
+	articles, total, err := s.store.getPage(c.Name, page, perPage)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	p := Pagination{Page: page, PerPage: perPage, Total: total}
+	if !p.InRange() {
+		notFound(w)
+		return
+	}
+
+	setPaginationLinks(w, p, c.Path())
+	indexPage(w, articles, c.Name, c.Name+" Articles", c.Description, c.Path(), p, s.viewer(w, r))
+}
+
+// Returns the validation errors to show the user. err is only set if the store couldn't be checked.
+func (s *Server) ValidateCategory(c Category) (errors []string, err error) {
+	if strings.TrimSpace(c.Name) == "" {
+		errors = append(errors, errCatNameEmpty)
+	}
+	if len([]rune(c.Name)) > maxCategoryNameLength {
+		errors = append(errors, errCatNameLong)
+	}
+	if c.Slug == "" {
+		errors = append(errors, errCatSlugBad)
+		return
+	}
+	if isReservedPath(c.Slug) {
+		errors = append(errors, errCatSlugReserved)
+	}
+	exists, err := s.store.doesSlugExist(c.Slug)
+	if err != nil {
+		return errors, err
+	}
+	if exists {
+		errors = append(errors, errCatSlugIsArticle)
+	}
+	return
+}
+
+// Reads the add and edit forms on the categories page. A blank slug is made from the name.
+// ok is false if the sort order isn't a number. err is set if the form couldn't be read,
+// which should be answered with a 400.
+func getCategoryFromForm(r *http.Request) (c Category, ok bool, err error) {
+	if err := r.ParseForm(); err != nil {
+		return Category{}, false, err
+	}
+
+	c.Name = strings.TrimSpace(r.FormValue("name"))
+	c.Slug = categorySlug(r.FormValue("slug"))
+	if c.Slug == "" {
+		c.Slug = categorySlug(c.Name)
+	}
+	c.Description = strings.TrimSpace(r.FormValue("description"))
+
+	sortOrder := r.FormValue("sort_order")
+	if sortOrder == "" {
+		return c, true, nil
+	}
+	c.SortOrder, err = strconv.Atoi(sortOrder)
+	return c, err == nil, nil
+}
+
+func (s *Server) AdminCategories(w http.ResponseWriter, r *http.Request) {
+	s.categoriesPage(w, r, http.StatusOK, Category{}, nil)
+}
+
+func (s *Server) NewCategory(w http.ResponseWriter, r *http.Request) {
+	c, ok, err := getCategoryFromForm(r)
+	if err != nil {
+		badRequest(w)
+		return
+	}
+	errors, err := s.ValidateCategory(c)
+	if err != nil {
+		s.categoryWriteFailed(w, r, err, c)
+		return
+	}
+	if !ok {
+		errors = append(errors, errCatSortOrder)
+	}
+	if len(errors) != 0 {
+		s.categoriesPage(w, r, http.StatusBadRequest, c, errors)
+		return
+	}
+	if err := s.store.newCategory(c); err != nil {
+		s.categoryWriteFailed(w, r, err, c)
+		return
+	}
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+func (s *Server) EditCategory(w http.ResponseWriter, r *http.Request) {
+	old, err := s.store.getCategory(mux.Vars(r)["category"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if old.Slug == "" {
+		notFound(w)
+		return
+	}
+
+	c, ok, err := getCategoryFromForm(r)
+	if err != nil {
+		badRequest(w)
+		return
+	}
+	c.Id = old.Id
+	errors, err := s.ValidateCategory(c)
+	if err != nil {
+		s.categoryWriteFailed(w, r, err, c)
+		return
+	}
+	if !ok {
+		errors = append(errors, errCatSortOrder)
+	}
+	if len(errors) != 0 {
+		s.categoriesPage(w, r, http.StatusBadRequest, c, errors)
+		return
+	}
+	if err := s.store.editCategory(old.Id, c); err != nil {
+		s.categoryWriteFailed(w, r, err, c)
+		return
+	}
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+// Deleting a category that still has articles needs a category to move them to, given by the move_to field.
+func (s *Server) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	c, err := s.store.getCategory(mux.Vars(r)["category"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if c.Slug == "" {
+		notFound(w)
+		return
+	}
+
+	moveTo := ""
+	if slug := r.FormValue("move_to"); slug != "" {
+		target, err := s.store.getCategory(slug)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if target.Slug == "" || target.Id == c.Id {
+			s.categoriesPage(w, r, http.StatusBadRequest, Category{}, []string{errCatMoveInvalid})
+			return
+		}
+		moveTo = target.Name
+	}
+
+	if err := s.store.deleteCategory(c.Id, moveTo); err != nil {
+		s.categoryWriteFailed(w, r, err, Category{})
+		return
+	}
+	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
+}
+
+// Shows the categories page again with the reason a write failed, keeping what was typed into the form.
+func (s *Server) categoryWriteFailed(w http.ResponseWriter, r *http.Request, err error, form Category) {
+	status, message := http.StatusConflict, ""
+	switch {
+	case errors.Is(err, errCategoryTaken):
+		message = errCatTaken
+	case errors.Is(err, errCategoryNotEmpty):
+		message = errCatNotEmpty
+	case errors.Is(err, errLastCategory):
+		message = errCatLast
+	default:
+		log.Print(err)
+		status, message = http.StatusInternalServerError, errCatSaveFailed
+	}
+	s.categoriesPage(w, r, status, form, []string{message})
+}
+
+// A category's row on the categories page.
+type categoryRow struct {
+	Category
+	// What the row's form shows, the saved category unless an edit of it has just failed.
+	Form Category
+}
+
+// form is what was typed into the add form, or into a category's row if its Id is set.
+func (s *Server) categoriesPage(w http.ResponseWriter, r *http.Request, status int, form Category, errors []string) {
+	categories, err := s.store.getCategories()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	rows := make([]categoryRow, len(categories))
+	for i, c := range categories {
+		rows[i] = categoryRow{c, c}
+		if form.Id != 0 && form.Id == c.Id {
+			rows[i].Form = form
+		}
+	}
+	if form.Id != 0 {
+		form = Category{}
+	}
+
+	v := s.viewer(w, r)
+	if DEV {
+		categoriesTemplate = setCategoriesTemplate()
+	}
+	w.WriteHeader(status)
+	tmpl := categoriesTemplate
+	tmpl.Execute(w, struct {
+		Categories []categoryRow
+		Form       Category
+		Errors     []string
+		Viewer
+		Dev         bool
+		Description string
+	}{rows, form, errors, v, DEV, defaultDescription})
+}
+
+func setCategoriesTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/adminCategories.html"))
+}
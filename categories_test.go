@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCategorySlug(t *testing.T) {
+	cases := map[string]string{
+		"Programming": "programming",
+		" Web Dev ":   "web-dev",
+		"C++ & Go":    "c-go",
+		"!!!":         "",
+	}
+	for name, want := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := categorySlug(name); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFileSystemStoreCategories(t *testing.T) {
+	articles := MakeBothTypesOfArticle(2)
+
+	newStore := func(t *testing.T) (*FileSystemStore, func()) {
+		t.Helper()
+		tmpFile, cleanTempFile := makeTempFile()
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+		return store, func() {
+			closeDB()
+			cleanTempFile()
+		}
+	}
+
+	t.Run("new databases start with the old categories", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		got, err := store.getCategories()
+		assertNoError(t, err)
+		want := []Category{
+			{Id: 1, Name: progCat, Slug: "programming", SortOrder: 0, Count: 2, Home: true},
+			{Id: 2, Name: otherCat, Slug: "other", SortOrder: 1, Count: 2},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("new category", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		assertNoError(t, store.newCategory(Category{Name: "Web Dev", Slug: "web-dev", Description: "Browsers.", SortOrder: -1}))
+
+		got, err := store.getCategory("web-dev")
+		assertNoError(t, err)
+		want := Category{Id: 3, Name: "Web Dev", Slug: "web-dev", Description: "Browsers.", SortOrder: -1, Home: true}
+		if got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		err = store.newCategory(Category{Name: "Web Dev", Slug: "web"})
+		if !errors.Is(err, errCategoryTaken) {
+			t.Errorf("got %v, want %v", err, errCategoryTaken)
+		}
+		err = store.newCategory(Category{Name: "Web", Slug: "other"})
+		if !errors.Is(err, errCategoryTaken) {
+			t.Errorf("got %v, want %v", err, errCategoryTaken)
+		}
+	})
+
+	t.Run("missing category", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		got, err := store.getCategory("missing")
+		assertNoError(t, err)
+		if got != (Category{}) {
+			t.Errorf("got %v, want no category", got)
+		}
+	})
+
+	t.Run("renaming a category moves its articles", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		old, err := store.getCategory("other")
+		assertNoError(t, err)
+		assertNoError(t, store.editCategory(old.Id, Category{Name: "Misc", Slug: "misc", SortOrder: 1}))
+
+		_, total, err := store.getPage("Misc", 1, defaultPerPage)
+		assertNoError(t, err)
+		assertInt(t, total, 2)
+
+		err = store.editCategory(old.Id, Category{Name: progCat, Slug: "misc"})
+		if !errors.Is(err, errCategoryTaken) {
+			t.Errorf("got %v, want %v", err, errCategoryTaken)
+		}
+	})
+
+	t.Run("deleting a category with articles needs somewhere to move them", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		other, err := store.getCategory("other")
+		assertNoError(t, err)
+
+		err = store.deleteCategory(other.Id, "")
+		if !errors.Is(err, errCategoryNotEmpty) {
+			t.Errorf("got %v, want %v", err, errCategoryNotEmpty)
+		}
+		assertInt(t, countArticles(t, store), len(articles))
+
+		assertNoError(t, store.deleteCategory(other.Id, progCat))
+		_, total, err := store.getPage(progCat, 1, defaultPerPage)
+		assertNoError(t, err)
+		assertInt(t, total, len(articles))
+
+		got, err := store.getCategory("other")
+		assertNoError(t, err)
+		if got != (Category{}) {
+			t.Errorf("got %v, want category deleted", got)
+		}
+	})
+
+	t.Run("deleting an empty category", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		assertNoError(t, store.newCategory(Category{Name: "Empty", Slug: "empty"}))
+		empty, err := store.getCategory("empty")
+		assertNoError(t, err)
+		assertNoError(t, store.deleteCategory(empty.Id, ""))
+	})
+
+	t.Run("the last category can't be deleted", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		other, _ := store.getCategory("other")
+		assertNoError(t, store.deleteCategory(other.Id, progCat))
+		prog, _ := store.getCategory("programming")
+		err := store.deleteCategory(prog.Id, "")
+		if !errors.Is(err, errLastCategory) {
+			t.Errorf("got %v, want %v", err, errLastCategory)
+		}
+	})
+
+	t.Run("migrating keeps categories articles were saved with", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		legacy := MakeArticlesOfCategory(1, time.Now().UTC(), "Web Dev")
+		makeV0Database(t, tmpFile.Name(), legacy)
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		defer closeDB()
+
+		got, err := store.getCategory("web-dev")
+		assertNoError(t, err)
+		assertInt(t, got.Count, 1)
+	})
+
+	t.Run("migrated slugs are made the way categorySlug makes them", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		var legacy []Article
+		for _, cat := range []string{"Go/Web", "C++", "c", "++"} {
+			legacy = append(legacy, MakeArticlesOfCategory(1, time.Now().UTC(), cat)...)
+			legacy[len(legacy)-1].Slug = categorySlug(cat) + "-article"
+		}
+		makeV0Database(t, tmpFile.Name(), legacy)
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		defer closeDB()
+
+		categories, err := store.getCategories()
+		assertNoError(t, err)
+		slugs := map[string]string{}
+		for _, c := range categories {
+			slugs[c.Name] = c.Slug
+		}
+		want := map[string]string{"Go/Web": "go-web", "c": "c", "++": "category"}
+		for name, slug := range want {
+			if slugs[name] != slug {
+				t.Errorf("%s got slug %q, want %q", name, slugs[name], slug)
+			}
+		}
+		// "c" was taken.
+		if !strings.HasPrefix(slugs["C++"], "c-") {
+			t.Errorf("C++ got slug %q, want c- and its id", slugs["C++"])
+		}
+	})
+}
+
+func TestCategoryRoutes(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+
+	progArticles, otherArticles := MakeSeparatedArticles(defaultPerPage + 1)
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, append(progArticles, otherArticles...), []User{admin})
+	defer closeDB()
+	sessStore := StubSessionStore{}
+	server := NewServer(store, &sessStore)
+
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, path))
+		return resp
+	}
+	post := func(t *testing.T, path string, data url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, path, data))
+		return resp
+	}
+
+	t.Run("home category redirects to /", func(t *testing.T) {
+		cases := map[string]string{
+			"/programming":                   "/",
+			"/programming/page/2":            "/page/2",
+			"/programming/page/2?per_page=5": "/page/2?per_page=5",
+		}
+		for path, want := range cases {
+			resp := get(t, path)
+			assertStatus(t, resp.Code, 301)
+			if got := resp.Header().Get("Location"); got != want {
+				t.Errorf("%s redirected to %s, want %s", path, got, want)
+			}
+		}
+	})
+
+	t.Run("unknown category is 404", func(t *testing.T) {
+		assertStatus(t, get(t, "/nope/page/1").Code, 404)
+		assertStatus(t, get(t, "/nope").Code, 404)
+	})
+
+	t.Run("nav lists the categories", func(t *testing.T) {
+		body := get(t, "/all").Body.String()
+		assertContains(t, body, `<a class="navbar-item" href="/other">`)
+		assertContains(t, body, `href="/other/feed.xml">Other</a>`)
+	})
+
+	t.Run("admin pages need a login", func(t *testing.T) {
+		assertStatus(t, get(t, "/admin/categories").Code, 303)
+		assertStatus(t, post(t, "/admin/categories", url.Values{"name": {"Go"}}).Code, 401)
+		assertStatus(t, post(t, "/admin/categories/other/edit", url.Values{"name": {"Go"}}).Code, 401)
+		assertStatus(t, post(t, "/admin/categories/other/delete", url.Values{}).Code, 401)
+	})
+
+	sessStore.sesh = Sesh{Name: admin.Username, Authenticated: true}
+	defer func() { sessStore.sesh.Authenticated = false }()
+
+	t.Run("add a category", func(t *testing.T) {
+		resp := post(t, "/admin/categories", url.Values{"name": {"Web Dev"}, "description": {"All about browsers."}, "sort_order": {"5"}})
+		assertStatus(t, resp.Code, 303)
+
+		resp = get(t, "/web-dev")
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Body.String(), "Web Dev Articles")
+		assertContains(t, resp.Body.String(), "All about browsers.")
+
+		resp = get(t, "/admin/categories")
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Body.String(), `action="/admin/categories/web-dev/edit"`)
+	})
+
+	t.Run("invalid categories keep the form", func(t *testing.T) {
+		cases := []struct {
+			name string
+			data url.Values
+			code int
+			want string
+		}{
+			{"no name", url.Values{"name": {""}}, 400, errCatNameEmpty},
+			{"reserved slug", url.Values{"name": {"Admin"}}, 400, errCatSlugReserved},
+			{"slug used by an article", url.Values{"name": {"Taken"}, "slug": {progArticles[0].Slug}}, 400, errCatSlugIsArticle},
+			{"bad sort order", url.Values{"name": {"Sorted"}, "sort_order": {"first"}}, 400, errCatSortOrder},
+			{"name taken", url.Values{"name": {otherCat}, "slug": {"other-2"}}, 409, errCatTaken},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				resp := post(t, "/admin/categories", c.data)
+				assertStatus(t, resp.Code, c.code)
+				assertContains(t, resp.Body.String(), c.want)
+				assertContains(t, resp.Body.String(), `name="name" value="`+c.data.Get("name")+`"`)
+			})
+		}
+	})
+
+	t.Run("edit a category", func(t *testing.T) {
+		resp := post(t, "/admin/categories/web-dev/edit", url.Values{"name": {"Web"}, "slug": {"web"}})
+		assertStatus(t, resp.Code, 303)
+		assertStatus(t, get(t, "/web").Code, 200)
+		assertStatus(t, get(t, "/web-dev").Code, 404)
+		assertStatus(t, post(t, "/admin/categories/web-dev/edit", url.Values{"name": {"Web"}}).Code, 404)
+	})
+
+	t.Run("invalid edits keep what was typed into the row", func(t *testing.T) {
+		resp := post(t, "/admin/categories/web/edit", url.Values{"name": {"Admin"}, "description": {"Typed in."}})
+		assertStatus(t, resp.Code, 400)
+		body := resp.Body.String()
+		assertContains(t, body, errCatSlugReserved)
+		assertContains(t, body, `action="/admin/categories/web/edit"`)
+		assertContains(t, body, `name="name" value="Admin"`)
+		assertContains(t, body, `name="description" value="Typed in."`)
+		// Not in the add form.
+		assertContains(t, body, `<input type="text" name="name" value="">`)
+	})
+
+	t.Run("delete a category", func(t *testing.T) {
+		resp := post(t, "/admin/categories/other/delete", url.Values{})
+		assertStatus(t, resp.Code, 409)
+		assertContains(t, resp.Body.String(), errCatNotEmpty)
+
+		resp = post(t, "/admin/categories/other/delete", url.Values{"move_to": {"other"}})
+		assertStatus(t, resp.Code, 400)
+		assertContains(t, resp.Body.String(), errCatMoveInvalid)
+
+		resp = post(t, "/admin/categories/other/delete", url.Values{"move_to": {"web"}})
+		assertStatus(t, resp.Code, 303)
+		assertStatus(t, get(t, "/other").Code, 404)
+		assertContains(t, get(t, "/web/page/2").Body.String(), otherArticles[0].Title)
+	})
+
+	t.Run("article slugs can't shadow a category", func(t *testing.T) {
+		a := validArticleBase
+		a.Slug = "web"
+		a.Category = progCat
+		errs, err := server.ValidateArticle(a, false)
+		assertNoError(t, err)
+		if !reflect.DeepEqual(errs, []string{errSlugIsCategory}) {
+			t.Errorf("got %v, want %v", errs, []string{errSlugIsCategory})
+		}
+	})
+}
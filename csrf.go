@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Name of the hidden form field, and the header scripts can send instead.
+const (
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// Pages that are posted to before logging in has finished, so still need a token.
+var loginPaths = map[string]bool{"/admin/login": true, "/admin/login/code": true}
+
+// Made once per session, when logging in or loading the login page. A variable so tests can make tokens they know.
+var newCSRFToken = func() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// What every page needs to know about whoever is viewing it.
+type Viewer struct {
+	LoggedIn bool
+	// For the csrf_token field of the page's forms. Empty for visitors who aren't logged in.
+	CSRFToken string
+}
+
+// Sessions from before CSRF tokens are given one, so has to be called before anything is written to w.
+func (s *Server) viewer(w http.ResponseWriter, r *http.Request) Viewer {
+	if !s.isAuth(r) {
+		return Viewer{}
+	}
+	token, err := s.csrfToken(w, r)
+	if err != nil {
+		log.Print(err)
+	}
+	return Viewer{LoggedIn: true, CSRFToken: token}
+}
+
+// The session's token, making one and saving it to the session if it hasn't got one yet.
+func (s *Server) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	// A cookie that can't be read gets a new session.
+	session, _ := s.sessionStore.Get(r, "user")
+	sesh := s.sessionStore.getSesh(session)
+	if sesh.CSRFToken != "" {
+		return sesh.CSRFToken, nil
+	}
+
+	var err error
+	sesh.CSRFToken, err = newCSRFToken()
+	if err != nil {
+		return "", err
+	}
+	s.sessionStore.Set(session, sesh)
+	if err := s.sessionStore.SaveSession(r, w, session); err != nil {
+		return "", err
+	}
+	return sesh.CSRFToken, nil
+}
+
+// Rejects POSTs that don't carry the session's token with a 403, so other sites can't
+// submit forms as a logged in user. Requests from visitors who aren't logged in can't
+// change anything, so they're let through to get their 401, except for the loginPaths.
+func (s *Server) checkCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, _ := s.sessionStore.Get(r, "user")
+		sesh := s.sessionStore.getSesh(session)
+		if !sesh.Authenticated && !loginPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sent := r.Header.Get(csrfHeader)
+		if sent == "" {
+			sent = r.PostFormValue(csrfField)
+		}
+		if sesh.CSRFToken == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(sesh.CSRFToken)) != 1 {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "403 forbidden, invalid CSRF token. Go back, reload the page and try again.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
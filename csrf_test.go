@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// So that every session the tests make, including by logging in, has testCSRFToken.
+func init() {
+	newCSRFToken = func() (string, error) { return testCSRFToken, nil }
+}
+
+func TestCSRF(t *testing.T) {
+	restore := newCSRFToken
+	defer func() { newCSRFToken = restore }()
+	made := 0
+	newCSRFToken = func() (string, error) {
+		made++
+		return fmt.Sprintf("token-%d", made), nil
+	}
+
+	articles := MakeArticlesOfCategory(2, time.Now().UTC(), progCat)
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{admin})
+	defer closeDB()
+	// A real session store, so tokens go through the cookie like they do in a browser.
+	b := &testBrowser{server: NewServer(store, NewMemorySessionStore())}
+
+	t.Run("logging in needs the login page's token", func(t *testing.T) {
+		assertStatus(t, b.post(t, "/admin/login", userData("admin", "password")).Code, http.StatusForbidden)
+
+		loginToken := b.token(t, "/admin/login")
+		data := userData("admin", "password")
+		data.Set(csrfField, loginToken)
+		assertStatus(t, b.post(t, "/admin/login", data).Code, http.StatusSeeOther)
+
+		if b.token(t, "/admin/password") == loginToken {
+			t.Error("logging in should give the session a new token")
+		}
+	})
+
+	t.Run("posts without the session's token are forbidden", func(t *testing.T) {
+		slug := articles[0].Slug
+		for name, token := range map[string]string{"missing": "", "wrong": "token-0", "old": "token-1"} {
+			t.Run(name, func(t *testing.T) {
+				resp := b.post(t, "/"+slug+"/delete", url.Values{csrfField: {token}})
+				assertStatus(t, resp.Code, http.StatusForbidden)
+				assertContains(t, resp.Body.String(), "invalid CSRF token")
+			})
+		}
+		_, a, err := store.getArticle(slug)
+		assertNoError(t, err)
+		if a.DeletedAt != "" {
+			t.Error("article was deleted without a token")
+		}
+	})
+
+	t.Run("delete asks first", func(t *testing.T) {
+		slug := articles[0].Slug
+		resp := b.send(t, newGetRequest(t, "/"+slug+"/delete"))
+		assertStatus(t, resp.Code, http.StatusOK)
+		assertContains(t, resp.Body.String(), `action="/`+slug+`/delete" method="post"`)
+		_, a, err := store.getArticle(slug)
+		assertNoError(t, err)
+		if a.DeletedAt != "" {
+			t.Error("GET should not delete the article")
+		}
+
+		token := b.token(t, "/"+slug+"/delete")
+		assertStatus(t, b.post(t, "/"+slug+"/delete", url.Values{csrfField: {token}}).Code, http.StatusSeeOther)
+		_, a, err = store.getArticle(slug)
+		assertNoError(t, err)
+		if a.DeletedAt == "" {
+			t.Error("article wasn't moved to the trash")
+		}
+	})
+
+	t.Run("the token can be sent in a header", func(t *testing.T) {
+		token := b.token(t, "/admin")
+		req, _ := http.NewRequest(http.MethodPost, "/admin/trash/"+articles[0].Slug+"/restore", nil)
+		req.Header.Set(csrfHeader, token)
+		assertStatus(t, b.send(t, req).Code, http.StatusSeeOther)
+	})
+
+	t.Run("every form on admin pages has the token", func(t *testing.T) {
+		for _, path := range []string{"/admin", "/admin/users", "/admin/categories", "/admin/trash", "/admin/profile", "/" + articles[1].Slug + "/edit", "/" + articles[1].Slug + "/revisions"} {
+			body := b.send(t, newGetRequest(t, path)).Body.String()
+			forms := strings.Count(body, `method="post"`)
+			fields := strings.Count(body, `name="csrf_token"`)
+			if forms == 0 || fields != forms {
+				t.Errorf("%s has %d post forms and %d csrf_token fields", path, forms, fields)
+			}
+		}
+	})
+
+	t.Run("logging out needs the token", func(t *testing.T) {
+		assertStatus(t, b.post(t, "/admin/logout", url.Values{}).Code, http.StatusForbidden)
+		token := b.token(t, "/admin")
+		assertStatus(t, b.post(t, "/admin/logout", url.Values{csrfField: {token}}).Code, http.StatusSeeOther)
+	})
+
+	t.Run("visitors who are not logged in still get a 401", func(t *testing.T) {
+		b.cookies = nil
+		assertStatus(t, b.post(t, "/"+articles[1].Slug+"/delete", url.Values{}).Code, http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	diffSame    = "same"
+	diffAdded   = "added"
+	diffRemoved = "removed"
+)
+
+type DiffLine struct {
+	Kind string
+	Text string
+}
+
+// Compares two texts line by line, using the longest common subsequence of their lines.
+// Lines only in a are removed, lines only in b are added.
+func diffLines(a, b string) []DiffLine {
+	x, y := splitLines(a), splitLines(b)
+
+	// Lines shared at the start and end don't need to go through the table.
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	var ret []DiffLine
+	for _, line := range x[:prefix] {
+		ret = append(ret, DiffLine{diffSame, line})
+	}
+	ret = append(ret, diffMiddle(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])...)
+	for _, line := range x[len(x)-suffix:] {
+		ret = append(ret, DiffLine{diffSame, line})
+	}
+	return ret
+}
+
+// The most cells the table in diffMiddle may have. Past this, about a thousand changed lines
+// on each side, the middle is shown as replaced rather than taking more memory and time.
+const maxDiffCells = 1 << 20
+
+func diffMiddle(x, y []string) []DiffLine {
+	if (len(x)+1)*(len(y)+1) > maxDiffCells {
+		return replacedLines(x, y)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ret []DiffLine
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			ret = append(ret, DiffLine{diffSame, x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ret = append(ret, DiffLine{diffRemoved, x[i]})
+			i++
+		default:
+			ret = append(ret, DiffLine{diffAdded, y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		ret = append(ret, DiffLine{diffRemoved, x[i]})
+	}
+	for ; j < len(y); j++ {
+		ret = append(ret, DiffLine{diffAdded, y[j]})
+	}
+	return ret
+}
+
+// Every line of x removed, then every line of y added.
+func replacedLines(x, y []string) []DiffLine {
+	ret := make([]DiffLine, 0, len(x)+len(y))
+	for _, line := range x {
+		ret = append(ret, DiffLine{diffRemoved, line})
+	}
+	for _, line := range y {
+		ret = append(ret, DiffLine{diffAdded, line})
+	}
+	return ret
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
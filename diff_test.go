@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b string
+		want []DiffLine
+	}{
+		{"same", "a\nb", "a\nb", []DiffLine{{diffSame, "a"}, {diffSame, "b"}}},
+		{"empty", "", "", nil},
+		{"added", "a\nc", "a\nb\nc", []DiffLine{{diffSame, "a"}, {diffAdded, "b"}, {diffSame, "c"}}},
+		{"removed", "a\nb\nc", "a\nc", []DiffLine{{diffSame, "a"}, {diffRemoved, "b"}, {diffSame, "c"}}},
+		{"changed", "a\nb\nc", "a\nB\nc", []DiffLine{{diffSame, "a"}, {diffRemoved, "b"}, {diffAdded, "B"}, {diffSame, "c"}}},
+		{"from nothing", "", "a", []DiffLine{{diffAdded, "a"}}},
+		{"windows line endings", "a\r\nb", "a\nb", []DiffLine{{diffSame, "a"}, {diffSame, "b"}}},
+		{"moved", "a\nb\nc\nd", "b\nc\na\nd", []DiffLine{{diffRemoved, "a"}, {diffSame, "b"}, {diffSame, "c"}, {diffAdded, "a"}, {diffSame, "d"}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := diffLines(c.a, c.b)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesTooLarge(t *testing.T) {
+	const n = 20000
+	var a, b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&a, "old %d\n", i)
+		fmt.Fprintf(&b, "new %d\n", i)
+	}
+
+	got := diffLines("first\n"+a.String()+"last", "first\n"+b.String()+"last")
+	// The lines they share at either end are still matched.
+	assertInt(t, len(got), 2*n+2)
+	if got[0] != (DiffLine{diffSame, "first"}) || got[len(got)-1] != (DiffLine{diffSame, "last"}) {
+		t.Errorf("got first %v and last %v, want them unchanged", got[0], got[len(got)-1])
+	}
+	if got[1] != (DiffLine{diffRemoved, "old 0"}) || got[n+1] != (DiffLine{diffAdded, "new 0"}) {
+		t.Errorf("got %v and %v, want every old line removed then every new line added", got[1], got[n+1])
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/gorilla/mux"
+)
+
+// How many of the newest articles each feed carries.
+const feedLength = 20
+
+const (
+	feedRSS  = "feed.xml"
+	feedAtom = "atom.xml"
+	feedJSON = "feed.json"
+)
+
+var feedContentTypes = map[string]string{
+	feedRSS:  "application/rss+xml; charset=utf-8",
+	feedAtom: "application/atom+xml; charset=utf-8",
+	feedJSON: "application/feed+json; charset=utf-8",
+}
+
+// Serves /feed.xml, /atom.xml and /feed.json, and the same under a category, tag or author,
+// e.g. /other/feed.xml, /tag/go/feed.xml or /author/mik/feed.xml.
+func (s *Server) Feed(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	title := "Gorocode"
+	link := siteURL + "/"
+
+	var articles []Article
+	var err error
+	if username, ok := vars["username"]; ok {
+		var author User
+		author, err = s.store.getUser(username)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if author.Id == 0 {
+			notFound(w)
+			return
+		}
+		title += " - " + author.Name()
+		link = siteURL + authorPath(author.Username)
+		articles, err = s.store.getLatestByAuthor(author.Id, feedLength)
+	} else if slug, ok := vars["tag"]; ok {
+		var tag Tag
+		tag, err = s.store.getTag(slug)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if tag.Slug == "" {
+			notFound(w)
+			return
+		}
+		title += " - Tagged: " + tag.Name
+		link = siteURL + tagPath(tag.Slug)
+		articles, err = s.store.getLatestTagged(tag.Slug, feedLength)
+	} else {
+		category := ""
+		if slug, ok := vars["category"]; ok {
+			var c Category
+			c, err = s.store.getCategory(slug)
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+			if c.Slug == "" {
+				notFound(w)
+				return
+			}
+			category = c.Name
+			title += " - " + c.Name
+			link = siteURL + c.Path()
+		}
+		articles, err = s.store.getLatest(category, feedLength)
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	kind := path.Base(r.URL.Path)
+	feed, err := buildFeed(articles, title, link)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	out, err := renderFeed(feed, kind)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", feedContentTypes[kind])
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(out)))
+	// Handles If-None-Match and If-Modified-Since.
+	http.ServeContent(w, r, "", feed.Updated, bytes.NewReader(out))
+}
+
+// link is the page the feed mirrors, e.g. the category's index.
+func buildFeed(articles []Article, title, link string) (*feeds.Feed, error) {
+	updated, err := feedUpdated(articles)
+	if err != nil {
+		return nil, err
+	}
+	feed := &feeds.Feed{
+		Title:       title,
+		Link:        &feeds.Link{Href: link},
+		Description: defaultDescription,
+		Id:          link,
+		Updated:     updated,
+	}
+
+	for _, a := range articles {
+		created, err := myStringToTime(a.Published)
+		if err != nil {
+			return nil, err
+		}
+		edited, err := myStringToTime(a.Edited)
+		if err != nil {
+			return nil, err
+		}
+		item := &feeds.Item{
+			Title:       a.Title,
+			Link:        &feeds.Link{Href: siteURL + "/" + a.Slug},
+			Id:          siteURL + "/" + a.Slug,
+			Description: a.Preview,
+			Created:     created,
+			Updated:     edited,
+		}
+		if a.AuthorName != "" {
+			item.Author = &feeds.Author{Name: a.AuthorName}
+		}
+		if feedFullContent {
+			item.Content = string(sanitizeBody(a.Body))
+		}
+		feed.Add(item)
+	}
+	return feed, nil
+}
+
+func renderFeed(feed *feeds.Feed, kind string) ([]byte, error) {
+	var out string
+	var err error
+	switch kind {
+	case feedAtom:
+		out, err = feed.ToAtom()
+	case feedJSON:
+		out, err = feed.ToJSON()
+	default:
+		out, err = feed.ToRss()
+	}
+	return []byte(out), err
+}
+
+// The most recent publish or edit time. Zero for an empty feed.
+func feedUpdated(articles []Article) (time.Time, error) {
+	var latest time.Time
+	for _, a := range articles {
+		for _, t := range []string{a.Published, a.Edited} {
+			parsed, err := myStringToTime(t)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if parsed.After(latest) {
+				latest = parsed
+			}
+		}
+	}
+	return latest, nil
+}
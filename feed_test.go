@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFeeds(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+
+	progArticles, otherArticles := MakeSeparatedArticles(feedLength + 5)
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, append(progArticles, otherArticles...), []User{})
+	defer closeDB()
+	sessStore := StubSessionStore{}
+	server := NewServer(store, &sessStore)
+
+	newestProg := reverseArticles(progArticles)
+	newestOther := reverseArticles(otherArticles)
+
+	t.Run("rss", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/feed.xml"))
+
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Header().Get("Content-Type"), "application/rss+xml")
+
+		var rss struct {
+			Channel struct {
+				Items []struct {
+					Title   string `xml:"title"`
+					Link    string `xml:"link"`
+					PubDate string `xml:"pubDate"`
+				} `xml:"item"`
+			} `xml:"channel"`
+		}
+		assertNoError(t, xml.Unmarshal(resp.Body.Bytes(), &rss))
+		assertInt(t, len(rss.Channel.Items), feedLength)
+
+		// Both categories are in the main feed, newest first.
+		assertContains(t, rss.Channel.Items[0].Title, newestOther[0].Title)
+		assertContains(t, rss.Channel.Items[1].Title, newestProg[0].Title)
+		assertContains(t, rss.Channel.Items[0].Link, siteURL+"/"+newestOther[0].Slug)
+		assertContains(t, rss.Channel.Items[0].PubDate, mustStringToTime(t, newestOther[0].Published).Format(time.RFC1123Z))
+	})
+
+	t.Run("atom", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/atom.xml"))
+
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Header().Get("Content-Type"), "application/atom+xml")
+
+		var atom struct {
+			Entries []struct {
+				Title     string `xml:"title"`
+				Updated   string `xml:"updated"`
+				Published string `xml:"published"`
+			} `xml:"entry"`
+		}
+		assertNoError(t, xml.Unmarshal(resp.Body.Bytes(), &atom))
+		assertInt(t, len(atom.Entries), feedLength)
+		assertContains(t, atom.Entries[0].Updated, mustStringToTime(t, newestOther[0].Edited).Format(time.RFC3339))
+	})
+
+	t.Run("json feed", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/feed.json"))
+
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Header().Get("Content-Type"), "application/feed+json")
+
+		var feed struct {
+			Version string `json:"version"`
+			Items   []struct {
+				Title   string `json:"title"`
+				URL     string `json:"url"`
+				Summary string `json:"summary"`
+			} `json:"items"`
+		}
+		assertNoError(t, json.Unmarshal(resp.Body.Bytes(), &feed))
+		assertContains(t, feed.Version, "jsonfeed.org")
+		assertInt(t, len(feed.Items), feedLength)
+		assertContains(t, feed.Items[0].Summary, newestOther[0].Preview)
+	})
+
+	t.Run("category feeds only carry their category", func(t *testing.T) {
+		for _, path := range []string{"/other/feed.xml", "/other/atom.xml", "/other/feed.json"} {
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, newGetRequest(t, path))
+
+			assertStatus(t, resp.Code, 200)
+			assertContains(t, resp.Body.String(), newestOther[0].Title)
+			assertNotContain(t, resp.Body.String(), newestProg[0].Title)
+		}
+
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/programming/feed.xml"))
+		assertContains(t, resp.Body.String(), newestProg[0].Title)
+		assertNotContain(t, resp.Body.String(), newestOther[0].Title)
+
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/not-a-category/feed.xml"))
+		assertStatus(t, resp.Code, 404)
+	})
+
+	t.Run("preview or full content", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/other/feed.json"))
+		assertNotContain(t, resp.Body.String(), "content_html")
+
+		feedFullContent = true
+		defer func() { feedFullContent = false }()
+
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/other/feed.json"))
+		assertContains(t, resp.Body.String(), "content_html")
+		assertContains(t, resp.Body.String(), "Lorem ipsum dolor sit amet")
+	})
+
+	t.Run("conditional get", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/feed.xml"))
+
+		etag := resp.Header().Get("ETag")
+		lastModified := resp.Header().Get("Last-Modified")
+		if etag == "" || lastModified == "" {
+			t.Fatalf("want ETag and Last-Modified, got %q and %q", etag, lastModified)
+		}
+		assertContains(t, lastModified, mustStringToTime(t, newestOther[0].Edited).Format(http.TimeFormat))
+
+		req := newGetRequest(t, "/feed.xml")
+		req.Header.Set("If-None-Match", etag)
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		assertStatus(t, resp.Code, http.StatusNotModified)
+
+		req = newGetRequest(t, "/feed.xml")
+		req.Header.Set("If-Modified-Since", lastModified)
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		assertStatus(t, resp.Code, http.StatusNotModified)
+
+		req = newGetRequest(t, "/feed.xml")
+		req.Header.Set("If-None-Match", `"stale"`)
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		assertStatus(t, resp.Code, 200)
+	})
+
+	t.Run("feeds are linked from every page", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/"))
+
+		assertContains(t, resp.Body.String(), `<link rel="alternate" type="application/rss+xml" title="Gorocode RSS" href="/feed.xml">`)
+		assertContains(t, resp.Body.String(), `href="/other/feed.xml"`)
+	})
+}
+
+func TestFeedSlugsReserved(t *testing.T) {
+	for _, feed := range []string{feedRSS, feedAtom, feedJSON} {
+		assertArticleSlugReserved(t, feed)
+	}
+}
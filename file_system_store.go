@@ -2,9 +2,10 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -14,207 +15,1021 @@ type FileSystemStore struct {
 	db *sql.DB
 }
 
-func NewFileSystemStore(dbFile *os.File, articles []Article, users []User) (*FileSystemStore, func()) {
+func NewFileSystemStore(dbFile *os.File, articles []Article, users []User) (*FileSystemStore, func(), error) {
 	f := new(FileSystemStore)
 
 	if dbFile == nil {
-		log.Print("nil file given to NewFileSystemStore")
-	} else {
-		db, err := sql.Open("sqlite3", dbFile.Name())
-		checkErr(err)
-		f.db = db
+		return nil, func() {}, errors.New("nil file given to NewFileSystemStore")
+	}
+
+	// Foreign keys are needed to clean up an article's tags when it's deleted.
+	db, err := sql.Open("sqlite3", dbFile.Name()+"?_foreign_keys=on")
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("problem opening database %s, %v", dbFile.Name(), err)
+	}
+	f.db = db
+
+	cleanUp := func() {
+		f.db.Close()
+	}
 
-		// If dbFile is empty, setup db.
-		f.setupDB(dbFile)
+	if err := f.migrate(); err != nil {
+		cleanUp()
+		return nil, func() {}, err
+	}
+
+	// Articles saved before the search index existed.
+	if err := f.indexMissingArticles(); err != nil {
+		cleanUp()
+		return nil, func() {}, err
+	}
 
-		if users != nil {
-			f.saveUsers(users)
+	if err := f.fixCategorySlugs(); err != nil {
+		cleanUp()
+		return nil, func() {}, err
+	}
+
+	if users != nil {
+		if err := f.saveUsers(users); err != nil {
+			cleanUp()
+			return nil, func() {}, err
 		}
+	}
 
-		if articles != nil {
-			if len(f.getAll()) == 0 {
-				f.saveArticles(articles)
+	if articles != nil {
+		existing, err := f.getAll()
+		if err != nil {
+			cleanUp()
+			return nil, func() {}, err
+		}
+		if len(existing) == 0 {
+			if err := f.saveArticles(articles); err != nil {
+				cleanUp()
+				return nil, func() {}, err
 			}
-		} else {
-			// log.Print("no articles given to NewFileSystemStore")
 		}
 	}
 
-	cleanUp := func() {
-		f.db.Close()
+	return f, cleanUp, nil
+}
+
+// Every published article, newest first. Bodies are left empty, use getArticle for the full article.
+func (f *FileSystemStore) getAll() ([]Article, error) {
+	rows, err := f.db.Query("SELECT " + articleSummaryColumns + " FROM Articles WHERE Status = 'published' AND DeletedAt IS NULL ORDER BY Published DESC, uid DESC")
+	if err != nil {
+		return nil, err
 	}
-	return f, cleanUp
+	return scanArticleSummaries(rows)
 }
 
-func (f *FileSystemStore) getAll() []Article {
+// Returns a page of a category's published articles, newest first, along with how many there are.
+// Bodies are left empty, use getArticle for the full article.
+func (f *FileSystemStore) getPage(category string, page, perPage int) ([]Article, int, error) {
+	var total int
+	err := f.db.QueryRow("SELECT COUNT(*) FROM Articles WHERE Status = 'published' AND DeletedAt IS NULL AND Category = ?", category).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p := Pagination{Page: page, PerPage: perPage, Total: total}
+	rows, err := f.db.Query("SELECT "+articleSummaryColumns+" FROM Articles WHERE Status = 'published' AND DeletedAt IS NULL AND Category = ? ORDER BY Published DESC, uid DESC LIMIT ? OFFSET ?",
+		category, perPage, p.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	articles, err := scanArticleSummaries(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return articles, total, nil
+}
+
+// Returns the newest n published articles of a category, or of every category if category is empty. Bodies are included.
+func (f *FileSystemStore) getLatest(category string, n int) ([]Article, error) {
+	rows, err := f.db.Query("SELECT "+articleColumns+", "+authorColumns+", "+editorColumn+" FROM Articles WHERE Status = 'published' AND DeletedAt IS NULL AND (? = '' OR Category = ?) ORDER BY Published DESC, uid DESC LIMIT ?",
+		category, category, n)
+	if err != nil {
+		return nil, err
+	}
+	return scanFullArticles(rows)
+}
+
+// Every column but uid.
+const articleColumns = "Title, Preview, Body, Slug, Published, Edited, Category, Format, Source, Status, AuthorID, EditedByID"
+
+// The editor's username, for Article.EditedBy. Read after authorColumns.
+const editorColumn = "COALESCE((SELECT Username FROM Users WHERE uid = EditedByID), '')"
+
+// The author's username and display name, for Article.Author and AuthorName. Read after articleColumns or articleSummaryColumns.
+const authorColumns = "COALESCE((SELECT Username FROM Users WHERE uid = AuthorID), ''), " +
+	"COALESCE((SELECT COALESCE(NULLIF(DisplayName, ''), Username) FROM Users WHERE uid = AuthorID), '')"
+
+func scanFullArticles(rows *sql.Rows) ([]Article, error) {
+	defer rows.Close()
+
 	var ret []Article
-	rows, err := f.db.Query("SELECT * FROM Articles")
-	checkErr(err)
+	for rows.Next() {
+		var a Article
+		if err := rows.Scan(&a.Title, &a.Preview, &a.Body, &a.Slug, &a.Published, &a.Edited, &a.Category, &a.Format, &a.Source, &a.Status, &a.AuthorID, &a.EditedByID, &a.Author, &a.AuthorName, &a.EditedBy); err != nil {
+			return nil, err
+		}
+		ret = append(ret, a)
+	}
+	return ret, rows.Err()
+}
+
+// Columns read by listings, everything but the body.
+const articleSummaryColumns = "Title, Preview, Slug, Published, Edited, Category, Format, Status, AuthorID, " + authorColumns
+
+func scanArticleSummaries(rows *sql.Rows) ([]Article, error) {
 	defer rows.Close()
 
+	var ret []Article
 	for rows.Next() {
 		var a Article
-		var id int
-		err = rows.Scan(&id, &a.Title, &a.Preview, &a.Body, &a.Slug, &a.Published, &a.Edited, &a.Category)
-		checkErr(err)
+		if err := rows.Scan(&a.Title, &a.Preview, &a.Slug, &a.Published, &a.Edited, &a.Category, &a.Format, &a.Status, &a.AuthorID, &a.Author, &a.AuthorName); err != nil {
+			return nil, err
+		}
 		ret = append(ret, a)
 	}
+	return ret, rows.Err()
+}
+
+// Returns an id of 0 and an empty article if no article uses the slug.
+// Articles of every status are returned, including trashed ones, which have DeletedAt set.
+func (f *FileSystemStore) getArticle(slug string) (int, Article, error) {
+	var a Article
+	var id int
+	row := f.db.QueryRow("SELECT uid, "+articleColumns+", "+authorColumns+", "+editorColumn+", COALESCE(DeletedAt, '') FROM Articles WHERE Slug = ? Limit 1", strings.ToLower(slug))
+	err := row.Scan(&id, &a.Title, &a.Preview, &a.Body, &a.Slug, &a.Published, &a.Edited, &a.Category, &a.Format, &a.Source, &a.Status, &a.AuthorID, &a.EditedByID, &a.Author, &a.AuthorName, &a.EditedBy, &a.DeletedAt)
+	if err == sql.ErrNoRows {
+		return 0, Article{}, nil
+	}
+	if err != nil {
+		return 0, Article{}, err
+	}
 
-	return reverseArticles(ret)
+	a.Tags, err = f.getArticleTags(id)
+	if err != nil {
+		return 0, Article{}, err
+	}
+	return id, a, nil
 }
 
-func (f *FileSystemStore) getPage(page int, category string) (articles []Article, p int, maxPage int) {
-	var ret []Article
-	rows, err := f.db.Query("SELECT * FROM Articles WHERE Category = ?", category)
-	checkErr(err)
+func (f *FileSystemStore) newArticle(a Article) error {
+	if err := f.checkSlugFree(a.Slug, 0); err != nil {
+		return err
+	}
+
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	a.Slug = strings.ToLower(a.Slug)
+	res, err := tx.Exec("INSERT INTO Articles("+articleColumns+") values(?, ?, ?, ?, DATETIME(?), ?, ?, ?, ?, ?, ?, ?)",
+		a.Title, a.Preview, a.Body, a.Slug, a.Published, a.Edited, a.Category, a.Format, a.Source, a.Status, a.AuthorID, a.EditedByID)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err := setArticleTags(tx, int(id), a.Tags); err != nil {
+		return err
+	}
+	if err := addRevision(tx, int(id), a); err != nil {
+		return err
+	}
+	if err := claimSlug(tx, a.Slug); err != nil {
+		return err
+	}
+	if err := indexArticle(tx, int(id), a); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// An empty Published keeps the article's publish time.
+func (f *FileSystemStore) editArticle(id int, edited Article) error {
+	if err := f.checkSlugFree(edited.Slug, id); err != nil {
+		return err
+	}
+
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldSlug string
+	if err := tx.QueryRow("SELECT Slug FROM Articles WHERE uid = ?", id).Scan(&oldSlug); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE Articles SET Title = ?, Preview = ?, Body = ?, Slug = ?, Published = COALESCE(DATETIME(?), Published), Edited = ?, Category = ?, Format = ?, Source = ?, Status = ?, EditedByID = ? WHERE uid = ?",
+		edited.Title, edited.Preview, edited.Body, edited.Slug, edited.Published, edited.Edited, edited.Category, edited.Format, edited.Source, edited.Status, edited.EditedByID, id)
+	if err != nil {
+		return err
+	}
+	if err := setArticleTags(tx, id, edited.Tags); err != nil {
+		return err
+	}
+	if err := addRevision(tx, id, edited); err != nil {
+		return err
+	}
+	if !strings.EqualFold(oldSlug, edited.Slug) {
+		_, err := tx.Exec("INSERT OR REPLACE INTO SlugHistory(Slug, ArticleID) values(?, ?)", strings.ToLower(oldSlug), id)
+		if err != nil {
+			return err
+		}
+	}
+	if err := claimSlug(tx, edited.Slug); err != nil {
+		return err
+	}
+	if err := indexArticle(tx, id, edited); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Drafts, scheduled and unlisted articles, newest first. Bodies are left empty.
+func (f *FileSystemStore) getUnpublished() ([]Article, error) {
+	rows, err := f.db.Query("SELECT " + articleSummaryColumns + " FROM Articles WHERE Status != 'published' AND DeletedAt IS NULL ORDER BY Published DESC, uid DESC")
+	if err != nil {
+		return nil, err
+	}
+	return scanArticleSummaries(rows)
+}
+
+// Publishes every scheduled article whose publish time is at or before now. Returns how many were published.
+func (f *FileSystemStore) publishScheduled(now string) (int, error) {
+	res, err := f.db.Exec("UPDATE Articles SET Status = 'published' WHERE Status = 'scheduled' AND DeletedAt IS NULL AND Published <= DATETIME(?)", now)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Moves an article to the trash. It keeps its slug until it's purged.
+func (f *FileSystemStore) trashArticle(id int, now string) error {
+	_, err := f.db.Exec("UPDATE Articles SET DeletedAt = DATETIME(?) WHERE uid = ?", now, id)
+	return err
+}
+
+// Takes an article back out of the trash, with the status it had before.
+func (f *FileSystemStore) restoreArticle(id int) error {
+	_, err := f.db.Exec("UPDATE Articles SET DeletedAt = NULL WHERE uid = ?", id)
+	return err
+}
+
+// Trashed articles, most recently trashed first. Bodies are left empty.
+func (f *FileSystemStore) getTrash() ([]Article, error) {
+	rows, err := f.db.Query("SELECT " + articleSummaryColumns + ", DeletedAt FROM Articles WHERE DeletedAt IS NOT NULL ORDER BY DeletedAt DESC, uid DESC")
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
+	var ret []Article
 	for rows.Next() {
 		var a Article
-		var id int
-		err = rows.Scan(&id, &a.Title, &a.Preview, &a.Body, &a.Slug, &a.Published, &a.Edited, &a.Category)
-		checkErr(err)
+		if err := rows.Scan(&a.Title, &a.Preview, &a.Slug, &a.Published, &a.Edited, &a.Category, &a.Format, &a.Status, &a.AuthorID, &a.Author, &a.AuthorName, &a.DeletedAt); err != nil {
+			return nil, err
+		}
 		ret = append(ret, a)
 	}
+	return ret, rows.Err()
+}
+
+// Permanently deletes every article trashed at or before the given time. Returns how many were deleted.
+func (f *FileSystemStore) purgeTrash(before string) (int, error) {
+	res, err := f.db.Exec("DELETE FROM Articles WHERE DeletedAt <= DATETIME(?)", before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Permanently deletes an article, along with its tags and revisions.
+func (f *FileSystemStore) deleteArticle(id int) error {
+	_, err := f.db.Exec("DELETE FROM Articles WHERE uid = ?", id)
+	return err
+}
+
+func (f *FileSystemStore) saveArticles(articles []Article) error {
+	for _, a := range articles {
+		if err := f.newArticle(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileSystemStore) doesSlugExist(slug string) (bool, error) {
+	id, _, err := f.getArticle(strings.ToLower(slug))
+	if err != nil {
+		return false, err
+	}
+	return id > 0, nil
+}
+
+// Returns errSlugTaken if an article other than the one with the given id already uses slug.
+func (f *FileSystemStore) checkSlugFree(slug string, id int) error {
+	owner, _, err := f.getArticle(slug)
+	if err != nil {
+		return err
+	}
+	if owner > 0 && owner != id {
+		return errSlugTaken
+	}
+	return nil
+}
+
+// Revisions
+
+// Records the article as it was just saved.
+func addRevision(tx *sql.Tx, articleID int, a Article) error {
+	_, err := tx.Exec("INSERT INTO ArticleRevisions(ArticleID, Title, Preview, Body, Format, Source, Slug, Category, Created, AuthorID) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		articleID, a.Title, a.Preview, a.Body, a.Format, a.Source, a.Slug, a.Category, a.Edited, a.EditedByID)
+	return err
+}
+
+const revisionColumns = "uid, ArticleID, Title, Preview, Body, Format, Source, Slug, Category, Created, AuthorID, " +
+	"COALESCE((SELECT Username FROM Users WHERE uid = AuthorID), '')"
 
-	return f.paginate(reverseArticles(ret), page)
+func scanRevision(row interface{ Scan(...interface{}) error }) (Revision, error) {
+	var r Revision
+	err := row.Scan(&r.Id, &r.ArticleID, &r.Title, &r.Preview, &r.Body, &r.Format, &r.Source, &r.Slug, &r.Category, &r.Created, &r.AuthorID, &r.Author)
+	return r, err
 }
 
-func (f *FileSystemStore) getArticle(slug string) (int, Article) {
-	rows, err := f.db.Query("SELECT * FROM Articles WHERE Slug = ? Limit 1", strings.ToLower(slug))
-	checkErr(err)
+// Every revision of an article, newest first.
+func (f *FileSystemStore) getRevisions(articleID int) ([]Revision, error) {
+	rows, err := f.db.Query("SELECT "+revisionColumns+" FROM ArticleRevisions WHERE ArticleID = ? ORDER BY uid DESC", articleID)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
+	var ret []Revision
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, rows.Err()
+}
+
+// Returns an empty Revision if the article has no revision with the id.
+func (f *FileSystemStore) getRevision(articleID, id int) (Revision, error) {
+	r, err := scanRevision(f.db.QueryRow("SELECT "+revisionColumns+" FROM ArticleRevisions WHERE ArticleID = ? AND uid = ?", articleID, id))
+	if err == sql.ErrNoRows {
+		return Revision{}, nil
+	}
+	return r, err
+}
+
+// Search
+
+// Adds or replaces an article's entry in the search index. Articles are removed from it by a trigger when they're deleted.
+func indexArticle(tx *sql.Tx, articleID int, a Article) error {
+	if _, err := tx.Exec("DELETE FROM ArticleSearch WHERE rowid = ?", articleID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT INTO ArticleSearch(rowid, Title, Preview, Body) values(?, ?, ?, ?)", articleID, a.Title, a.Preview, plainText(a.Body))
+	return err
+}
+
+func (f *FileSystemStore) indexMissingArticles() error {
+	rows, err := f.db.Query("SELECT uid, Title, Preview, Body FROM Articles WHERE uid NOT IN (SELECT rowid FROM ArticleSearch)")
+	if err != nil {
+		return err
+	}
+	missing := map[int]Article{}
 	for rows.Next() {
-		var a Article
 		var id int
-		err = rows.Scan(&id, &a.Title, &a.Preview, &a.Body, &a.Slug, &a.Published, &a.Edited, &a.Category)
-		checkErr(err)
-		return id, a
+		var a Article
+		if err := rows.Scan(&id, &a.Title, &a.Preview, &a.Body); err != nil {
+			rows.Close()
+			return err
+		}
+		missing[id] = a
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(missing) == 0 {
+		return err
 	}
-	return 0, Article{}
+
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, a := range missing {
+		if err := indexArticle(tx, id, a); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (f *FileSystemStore) newArticle(a Article) {
-	stmt, err := f.db.Prepare("INSERT INTO Articles(Title, Preview, Body, Slug, Published, Edited, Category) values(?, ?, ?, ?, DATETIME(?), ?, ?)")
-	checkErr(err)
-	_, err = stmt.Exec(a.Title, a.Preview, a.Body, strings.ToLower(a.Slug), a.Published, a.Edited, a.Category)
-	checkErr(err)
+// Returns a page of the published articles matching the query, best match first, along with how many there are.
+// Bodies are left empty.
+func (f *FileSystemStore) Search(q SearchQuery, page, perPage int) ([]SearchResult, int, error) {
+	const filter = `ArticleSearch MATCH ? AND a.Status = 'published' AND a.DeletedAt IS NULL
+		AND (? = '' OR a.Category IN (SELECT Name FROM Categories WHERE Slug = ?))`
+
+	var total int
+	err := f.db.QueryRow("SELECT COUNT(*) FROM ArticleSearch JOIN Articles a ON a.uid = ArticleSearch.rowid WHERE "+filter,
+		q.match(), q.Category, q.Category).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p := Pagination{Page: page, PerPage: perPage, Total: total}
+	// Matches in the title count for the most, then the preview.
+	rows, err := f.db.Query(`SELECT a.Title, a.Preview, a.Slug, a.Published, a.Edited, a.Category, a.Format, a.Status, a.AuthorID, `+authorColumns+`,
+		highlight(ArticleSearch, 0, ?, ?), snippet(ArticleSearch, -1, ?, ?, '…', 24)
+		FROM ArticleSearch JOIN Articles a ON a.uid = ArticleSearch.rowid WHERE `+filter+`
+		ORDER BY bm25(ArticleSearch, 10.0, 5.0, 1.0), a.Published DESC LIMIT ? OFFSET ?`,
+		searchMarkStart, searchMarkEnd, searchMarkStart, searchMarkEnd, q.match(), q.Category, q.Category, perPage, p.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var ret []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		var title, snippet string
+		if err := rows.Scan(&r.Title, &r.Preview, &r.Slug, &r.Published, &r.Edited, &r.Category, &r.Format, &r.Status, &r.AuthorID, &r.Author, &r.AuthorName, &title, &snippet); err != nil {
+			return nil, 0, err
+		}
+		r.TitleHTML = highlightMatches(title)
+		r.Snippet = highlightMatches(snippet)
+		ret = append(ret, r)
+	}
+	return ret, total, rows.Err()
 }
 
-func (f *FileSystemStore) editArticle(id int, edited Article) {
-	stmt, err := f.db.Prepare("UPDATE Articles SET Title = ?, Preview = ?, Body = ?, Slug = ?, Edited = ?, Category = ? WHERE uid = ?")
-	checkErr(err)
-	_, err = stmt.Exec(edited.Title, edited.Preview, edited.Body, edited.Slug, edited.Edited, edited.Category, id)
-	checkErr(err)
+// Slug history
+
+// An article now using slug takes it over from whichever article used to have it.
+func claimSlug(tx *sql.Tx, slug string) error {
+	_, err := tx.Exec("DELETE FROM SlugHistory WHERE Slug = ?", strings.ToLower(slug))
+	return err
 }
 
-func (f *FileSystemStore) deleteArticle(id int) {
-	stmt, err := f.db.Prepare("DELETE FROM Articles WHERE uid = ?")
-	checkErr(err)
-	_, err = stmt.Exec(id)
-	checkErr(err)
+// Returns the current slug of the article that used to have slug, or an empty string if none did.
+func (f *FileSystemStore) getRedirect(slug string) (string, error) {
+	var current string
+	err := f.db.QueryRow("SELECT a.Slug FROM SlugHistory h JOIN Articles a ON a.uid = h.ArticleID WHERE h.Slug = ?", strings.ToLower(slug)).Scan(&current)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return current, err
 }
 
-func (f *FileSystemStore) saveArticles(articles []Article) {
-	for _, a := range articles {
-		f.newArticle(a)
+// Slugs an article used to have, most recent first.
+func (f *FileSystemStore) getSlugHistory(articleID int) ([]string, error) {
+	rows, err := f.db.Query("SELECT Slug FROM SlugHistory WHERE ArticleID = ? ORDER BY uid DESC", articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []string
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		ret = append(ret, slug)
+	}
+	return ret, rows.Err()
+}
+
+// Stops an old slug redirecting to the article.
+func (f *FileSystemStore) deleteSlugHistory(articleID int, slug string) error {
+	_, err := f.db.Exec("DELETE FROM SlugHistory WHERE ArticleID = ? AND Slug = ?", articleID, strings.ToLower(slug))
+	return err
+}
+
+// Tags
+
+// Replaces an article's tags, creating any tags that don't exist yet.
+func setArticleTags(tx *sql.Tx, articleID int, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM ArticleTags WHERE ArticleID = ?", articleID); err != nil {
+		return err
+	}
+	for _, name := range tags {
+		slug := tagSlug(name)
+		if _, err := tx.Exec("INSERT OR IGNORE INTO Tags(Name, Slug) values(?, ?)", name, slug); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT OR IGNORE INTO ArticleTags(ArticleID, TagID) SELECT ?, uid FROM Tags WHERE Slug = ?", articleID, slug)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileSystemStore) getArticleTags(articleID int) ([]string, error) {
+	rows, err := f.db.Query("SELECT t.Name FROM Tags t JOIN ArticleTags at ON at.TagID = t.uid WHERE at.ArticleID = ? ORDER BY t.Name", articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		ret = append(ret, name)
+	}
+	return ret, rows.Err()
+}
+
+// Returns an empty Tag if no tag has the slug.
+func (f *FileSystemStore) getTag(slug string) (Tag, error) {
+	var t Tag
+	err := f.db.QueryRow("SELECT Name, Slug FROM Tags WHERE Slug = ?", strings.ToLower(slug)).Scan(&t.Name, &t.Slug)
+	if err == sql.ErrNoRows {
+		return Tag{}, nil
+	}
+	return t, err
+}
+
+// Returns a page of the published articles with a tag, newest first, along with how many there are.
+// Bodies are left empty, use getArticle for the full article.
+func (f *FileSystemStore) getTagPage(slug string, page, perPage int) ([]Article, int, error) {
+	var total int
+	err := f.db.QueryRow("SELECT COUNT(*) FROM ArticleTags at JOIN Tags t ON t.uid = at.TagID JOIN Articles a ON a.uid = at.ArticleID WHERE t.Slug = ? AND a.Status = 'published' AND a.DeletedAt IS NULL", slug).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p := Pagination{Page: page, PerPage: perPage, Total: total}
+	rows, err := f.db.Query("SELECT "+articleSummaryColumns+" FROM Articles WHERE Status = 'published' AND DeletedAt IS NULL AND uid IN (SELECT at.ArticleID FROM ArticleTags at JOIN Tags t ON t.uid = at.TagID WHERE t.Slug = ?) ORDER BY Published DESC, uid DESC LIMIT ? OFFSET ?",
+		slug, perPage, p.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	articles, err := scanArticleSummaries(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return articles, total, nil
+}
+
+// Returns the newest n published articles with a tag. Bodies are included.
+func (f *FileSystemStore) getLatestTagged(slug string, n int) ([]Article, error) {
+	rows, err := f.db.Query("SELECT "+articleColumns+", "+authorColumns+", "+editorColumn+" FROM Articles WHERE Status = 'published' AND DeletedAt IS NULL AND uid IN (SELECT at.ArticleID FROM ArticleTags at JOIN Tags t ON t.uid = at.TagID WHERE t.Slug = ?) ORDER BY Published DESC, uid DESC LIMIT ?",
+		slug, n)
+	if err != nil {
+		return nil, err
+	}
+	return scanFullArticles(rows)
+}
+
+// Authors
+
+// Published articles written by the user, newest first. Bodies are left empty.
+func (f *FileSystemStore) getAuthorPage(authorID, page, perPage int) ([]Article, int, error) {
+	var total int
+	err := f.db.QueryRow("SELECT COUNT(*) FROM Articles WHERE Status = 'published' AND DeletedAt IS NULL AND AuthorID = ?", authorID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p := Pagination{Page: page, PerPage: perPage, Total: total}
+	rows, err := f.db.Query("SELECT "+articleSummaryColumns+" FROM Articles WHERE Status = 'published' AND DeletedAt IS NULL AND AuthorID = ? ORDER BY Published DESC, uid DESC LIMIT ? OFFSET ?",
+		authorID, perPage, p.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	articles, err := scanArticleSummaries(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return articles, total, nil
+}
+
+// The newest n published articles written by the user. Bodies are included.
+func (f *FileSystemStore) getLatestByAuthor(authorID, n int) ([]Article, error) {
+	rows, err := f.db.Query("SELECT "+articleColumns+", "+authorColumns+", "+editorColumn+" FROM Articles WHERE Status = 'published' AND DeletedAt IS NULL AND AuthorID = ? ORDER BY Published DESC, uid DESC LIMIT ?",
+		authorID, n)
+	if err != nil {
+		return nil, err
 	}
+	return scanFullArticles(rows)
 }
 
-func (f *FileSystemStore) doesSlugExist(slug string) bool {
-	_, a := f.getArticle(strings.ToLower(slug))
-	if a == (Article{}) {
-		return false
+// Every tag on a published article, alphabetically, with how many published articles have it.
+func (f *FileSystemStore) getTagCounts() ([]Tag, error) {
+	rows, err := f.db.Query("SELECT t.Name, t.Slug, COUNT(*) FROM Tags t JOIN ArticleTags at ON at.TagID = t.uid JOIN Articles a ON a.uid = at.ArticleID WHERE a.Status = 'published' AND a.DeletedAt IS NULL GROUP BY t.uid ORDER BY t.Name COLLATE NOCASE")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.Name, &t.Slug, &t.Count); err != nil {
+			return nil, err
+		}
+		ret = append(ret, t)
 	}
-	return true
+	return ret, rows.Err()
 }
 
-// func (f *FileSystemStore) getIdFromSlug(slug string) int {
-// 	return 0
-// }
+// Categories
+
+// Selects a category's columns, with its article count and whether it's the home category.
+const categoryColumns = `c.uid, c.Name, c.Slug, c.Description, c.SortOrder,
+	(SELECT COUNT(*) FROM Articles WHERE Category = c.Name),
+	c.uid = (SELECT uid FROM Categories ORDER BY SortOrder, uid LIMIT 1)`
+
+// Every category in sort order. The first is the home category.
+func (f *FileSystemStore) getCategories() ([]Category, error) {
+	rows, err := f.db.Query("SELECT " + categoryColumns + " FROM Categories c ORDER BY c.SortOrder, c.uid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []Category
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.Id, &c.Name, &c.Slug, &c.Description, &c.SortOrder, &c.Count, &c.Home); err != nil {
+			return nil, err
+		}
+		ret = append(ret, c)
+	}
+	return ret, rows.Err()
+}
+
+// Returns an empty Category if no category has the slug.
+func (f *FileSystemStore) getCategory(slug string) (Category, error) {
+	var c Category
+	err := f.db.QueryRow("SELECT "+categoryColumns+" FROM Categories c WHERE c.Slug = ?", strings.ToLower(slug)).
+		Scan(&c.Id, &c.Name, &c.Slug, &c.Description, &c.SortOrder, &c.Count, &c.Home)
+	if err == sql.ErrNoRows {
+		return Category{}, nil
+	}
+	return c, err
+}
+
+func (f *FileSystemStore) newCategory(c Category) error {
+	if err := f.checkCategoryFree(c, 0); err != nil {
+		return err
+	}
+	_, err := f.db.Exec("INSERT INTO Categories(Name, Slug, Description, SortOrder) values(?, ?, ?, ?)",
+		c.Name, c.Slug, c.Description, c.SortOrder)
+	return err
+}
+
+// Renaming a category moves its articles to the new name.
+func (f *FileSystemStore) editCategory(id int, c Category) error {
+	if err := f.checkCategoryFree(c, id); err != nil {
+		return err
+	}
+
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldName string
+	if err := tx.QueryRow("SELECT Name FROM Categories WHERE uid = ?", id).Scan(&oldName); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE Categories SET Name = ?, Slug = ?, Description = ?, SortOrder = ? WHERE uid = ?",
+		c.Name, c.Slug, c.Description, c.SortOrder, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE Articles SET Category = ? WHERE Category = ?", c.Name, oldName); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Moves the category's articles to the category named moveTo, then deletes it.
+// Returns errCategoryNotEmpty if it has articles and moveTo is empty, and errLastCategory if it's the only category.
+func (f *FileSystemStore) deleteCategory(id int, moveTo string) error {
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var name string
+	var count, total int
+	err = tx.QueryRow("SELECT Name, (SELECT COUNT(*) FROM Articles WHERE Category = c.Name), (SELECT COUNT(*) FROM Categories) FROM Categories c WHERE uid = ?", id).
+		Scan(&name, &count, &total)
+	if err != nil {
+		return err
+	}
+	if total == 1 {
+		return errLastCategory
+	}
+	if count > 0 {
+		if moveTo == "" {
+			return errCategoryNotEmpty
+		}
+		if _, err := tx.Exec("UPDATE Articles SET Category = ? WHERE Category = ?", moveTo, name); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM Categories WHERE uid = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Migration 0005 made slugs for the categories articles already had in SQL, keeping characters
+// categorySlug takes out, so "Go/Web" got "go/web". Those are made again with categorySlug.
+func (f *FileSystemStore) fixCategorySlugs() error {
+	categories, err := f.getCategories()
+	if err != nil {
+		return err
+	}
+	for _, c := range categories {
+		slug := categorySlug(c.Slug)
+		if slug == c.Slug {
+			continue
+		}
+		if slug == "" {
+			slug = "category"
+		}
+		var taken int
+		if err := f.db.QueryRow("SELECT COUNT(*) FROM Categories WHERE Slug = ?", slug).Scan(&taken); err != nil {
+			return err
+		}
+		if taken > 0 {
+			slug += "-" + strconv.Itoa(c.Id)
+		}
+		if _, err := f.db.Exec("UPDATE Categories SET Slug = ? WHERE uid = ?", slug, c.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns errCategoryTaken if a category other than the one with the given id already uses c's name or slug.
+func (f *FileSystemStore) checkCategoryFree(c Category, id int) error {
+	var owner int
+	err := f.db.QueryRow("SELECT uid FROM Categories WHERE (Name = ? OR Slug = ?) AND uid != ?", c.Name, c.Slug, id).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return errCategoryTaken
+}
 
 // User
 
-func (f *FileSystemStore) newUser(u User) {
-	stmt, err := f.db.Prepare("INSERT INTO Users(Username, Email, Password_Hash) values(?, ?, ?)")
-	checkErr(err)
-	_, err = stmt.Exec(u.Username, u.Email, u.Password_Hash)
-	checkErr(err)
+func (f *FileSystemStore) newUser(u User) error {
+	if u.Role == "" {
+		u.Role = roleAuthor
+	}
+	_, err := f.db.Exec("INSERT INTO Users(Username, Email, Password_Hash, Role) values(?, ?, ?, ?)", u.Username, u.Email, u.Password_Hash, u.Role)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE") {
+		return errUsernameTaken
+	}
+	return err
 }
 
-func (f *FileSystemStore) saveUsers(users []User) {
+func (f *FileSystemStore) saveUsers(users []User) error {
 	for _, u := range users {
-		f.newUser(u)
+		if err := f.newUser(u); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
+// Returns an empty User if no user has the username.
 func (f *FileSystemStore) getUser(username string) (User, error) {
-	rows, err := f.db.Query("SELECT * FROM Users WHERE Username = ? Limit 1", username)
-	checkErr(err)
+	var u User
+	row := f.db.QueryRow("SELECT uid, Username, Email, Password_Hash, Role, DisplayName, Bio, Avatar, TOTPSecret FROM Users WHERE Username = ? Limit 1", username)
+	err := row.Scan(&u.Id, &u.Username, &u.Email, &u.Password_Hash, &u.Role, &u.DisplayName, &u.Bio, &u.Avatar, &u.TOTPSecret)
+	if err == sql.ErrNoRows {
+		return User{}, nil
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (f *FileSystemStore) setPassword(username, hash string) error {
+	res, err := f.db.Exec("UPDATE Users SET Password_Hash = ? WHERE Username = ?", hash, username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("no user %q to set the password of, %v", username, err)
+	}
+	return nil
+}
+
+func (f *FileSystemStore) countUsers() (int, error) {
+	var n int
+	err := f.db.QueryRow("SELECT COUNT(*) FROM Users").Scan(&n)
+	return n, err
+}
+
+// Creates the first user as an admin, unless there are users already. Returns whether u was created.
+func (f *FileSystemStore) bootstrapUser(u User) (bool, error) {
+	res, err := f.db.Exec("INSERT INTO Users(Username, Email, Password_Hash, Role) SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM Users)",
+		u.Username, u.Email, u.Password_Hash, roleAdmin)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Every user, oldest first. Password hashes are left empty.
+func (f *FileSystemStore) getUsers() ([]User, error) {
+	rows, err := f.db.Query("SELECT uid, Username, Email, Role, DisplayName FROM Users ORDER BY uid")
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
+	var ret []User
 	for rows.Next() {
 		var u User
-		err = rows.Scan(&u.Id, &u.Username, &u.Email, &u.Password_Hash)
-		checkErr(err)
-		return u, nil
+		if err := rows.Scan(&u.Id, &u.Username, &u.Email, &u.Role, &u.DisplayName); err != nil {
+			return nil, err
+		}
+		ret = append(ret, u)
+	}
+	return ret, rows.Err()
+}
+
+// Saves the user's display name, bio and avatar.
+func (f *FileSystemStore) setProfile(username string, u User) error {
+	res, err := f.db.Exec("UPDATE Users SET DisplayName = ?, Bio = ?, Avatar = ? WHERE Username = ?", u.DisplayName, u.Bio, u.Avatar, username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("no user %q to set the profile of, %v", username, err)
+	}
+	return nil
+}
+
+// Returns errLastAdmin rather than leave the blog without an admin.
+func (f *FileSystemStore) setRole(username, role string) error {
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if role != roleAdmin {
+		if err := checkNotLastAdmin(tx, username); err != nil {
+			return err
+		}
+	}
+	res, err := tx.Exec("UPDATE Users SET Role = ? WHERE Username = ?", role, username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("no user %q to set the role of, %v", username, err)
+	}
+	return tx.Commit()
+}
+
+// Their articles are kept, without an author. Returns errLastAdmin rather than delete the only admin.
+func (f *FileSystemStore) deleteUser(username string) error {
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkNotLastAdmin(tx, username); err != nil {
+		return err
 	}
-	return User{}, fmt.Errorf("user does not exist")
+	_, err = tx.Exec("UPDATE Articles SET AuthorID = 0 WHERE AuthorID = (SELECT uid FROM Users WHERE Username = ?)", username)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM Users WHERE Username = ?", username); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (f *FileSystemStore) setupDB(dbFile *os.File) {
-	fileInfo, err := dbFile.Stat()
-	checkErr(err)
-	if fileInfo.Size() == 0 {
-		f.createTables(f.db)
+func checkNotLastAdmin(tx *sql.Tx, username string) error {
+	var others int
+	err := tx.QueryRow("SELECT COUNT(*) FROM Users WHERE Role = 'admin' AND Username != ?", username).Scan(&others)
+	if err != nil {
+		return err
+	}
+	var isAdmin bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM Users WHERE Role = 'admin' AND Username = ?)", username).Scan(&isAdmin)
+	if err != nil {
+		return err
 	}
+	if isAdmin && others == 0 {
+		return errLastAdmin
+	}
+	return nil
 }
 
-func (f *FileSystemStore) createTables(db *sql.DB) {
-	createArticleTableSQL := `CREATE TABLE Articles (
-    "uid" INTEGER PRIMARY KEY AUTOINCREMENT,
-    "Title" VARCHAR(64) NULL,
-    "Preview" TEXT NULL,
-    "Body" TEXT NULL,
-    "Slug" VARCHAR(64) NULL,
-    "Published" VARCHAR(64) NULL,
-    "Edited" VARCHAR(64) NULL,
-    "Category" VARCHAR(64) NULL
-  );`
-	stmt, err := db.Prepare(createArticleTableSQL)
-	checkErr(err)
-	stmt.Exec()
+// Turns on two-factor authentication with secret, replacing any recovery codes with
+// recoveryHashes. An empty secret and no hashes turns it off.
+func (f *FileSystemStore) setTOTP(username, secret string, recoveryHashes []string) error {
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	createUserTableSQL := `CREATE TABLE Users (
-		"uid" INTEGER PRIMARY KEY AUTOINCREMENT,
-		"Username" VARCHAR(64) NULL,
-		"Email" VARCHAR(64) NULL,
-		"Password_Hash" VARCHAR(255) NULL
-	);`
-	stmt, err = db.Prepare(createUserTableSQL)
-	checkErr(err)
-	stmt.Exec()
+	var id int
+	err = tx.QueryRow("SELECT uid FROM Users WHERE Username = ?", username).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no user %q to set the TOTP secret of", username)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE Users SET TOTPSecret = ?, TOTPLastStep = 0 WHERE uid = ?", secret, id); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, id, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// Given a slice of articles and a page number, will return that page's articles, the actual current page and the highest page number.
-func (f *FileSystemStore) paginate(a []Article, page int) ([]Article, int, int) {
-	if len(a) <= perPage {
-		return a, 1, 1
+func (f *FileSystemStore) setRecoveryCodes(userID int, hashes []string) error {
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
+	if err := replaceRecoveryCodes(tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	p := page
-	if page < 1 {
-		p = 1
+func replaceRecoveryCodes(tx *sql.Tx, userID int, hashes []string) error {
+	if _, err := tx.Exec("DELETE FROM RecoveryCodes WHERE UserID = ?", userID); err != nil {
+		return err
 	}
-	maxPage := (len(a) / perPage)
-	if page > maxPage {
-		p = maxPage
+	for _, h := range hashes {
+		if _, err := tx.Exec("INSERT INTO RecoveryCodes (UserID, Hash) VALUES (?, ?)", userID, h); err != nil {
+			return err
+		}
 	}
-	endArticle := (p * perPage) - 1
-	if len(a) < endArticle {
-		endArticle = len(a)
+	return nil
+}
+
+// Records step as used, returning false if it or a later step already has been,
+// so each code only logs in once.
+func (f *FileSystemStore) useTOTPStep(userID int, step int64) (bool, error) {
+	res, err := f.db.Exec("UPDATE Users SET TOTPLastStep = ? WHERE uid = ? AND TOTPLastStep < ?", step, userID, step)
+	if err != nil {
+		return false, err
 	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Deletes the recovery code with hash, returning false if the user had no such code.
+func (f *FileSystemStore) useRecoveryCode(userID int, hash string) (bool, error) {
+	res, err := f.db.Exec("DELETE FROM RecoveryCodes WHERE UserID = ? AND Hash = ?", userID, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
 
-	return a[(p-1)*perPage : endArticle+1], p, maxPage
+func (f *FileSystemStore) countRecoveryCodes(userID int) (int, error) {
+	var n int
+	err := f.db.QueryRow("SELECT COUNT(*) FROM RecoveryCodes WHERE UserID = ?", userID).Scan(&n)
+	return n, err
 }
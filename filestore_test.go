@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -13,13 +14,14 @@ func TestFileSystemStore(t *testing.T) {
 			defer cleanTempFile()
 
 			articles := MakeBothTypesOfArticle(25)
-			_, closeDB := NewFileSystemStore(tmpFile, articles, []User{})
+			_, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
 			closeDB()
 
 			// Normal load, check if 50 articles exist
-			store, closeDB := NewFileSystemStore(tmpFile, []Article{}, []User{})
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
 
-			got := store.getAll()
+			got, err := store.getAll()
+			assertNoError(t, err)
 
 			if len(got) != 50 {
 				t.Error("Articles not saved between DB reloads.")
@@ -29,28 +31,36 @@ func TestFileSystemStore(t *testing.T) {
 
 			// Because tmpFile is not empty, ignore articles, check if 50 articles exist
 			articles = MakeBothTypesOfArticle(25)
-			store, closeDB = NewFileSystemStore(tmpFile, articles, []User{})
+			store, closeDB = mustNewFileSystemStore(t, tmpFile, articles, []User{})
 			defer closeDB()
 
-			got = store.getAll()
+			got, err = store.getAll()
+			assertNoError(t, err)
 
 			if len(got) != 50 {
 				t.Error("Articles were added to a non-empty db file during db reload")
 			}
 		})
+		t.Run("returns an error when given no file", func(t *testing.T) {
+			_, _, err := NewFileSystemStore(nil, []Article{}, []User{})
+			if err == nil {
+				t.Error("expected an error for a nil db file")
+			}
+		})
 		t.Run("can load dbfile with tables but no articles", func(t *testing.T) {
 			tmpFile, cleanTempFile := makeTempFile()
 			defer cleanTempFile()
 
-			_, closeDB := NewFileSystemStore(tmpFile, []Article{}, []User{})
+			_, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
 			closeDB()
 			// Blank db setup finished.
 
 			articles := MakeBothTypesOfArticle(25)
-			store, closeDB := NewFileSystemStore(tmpFile, articles, []User{})
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
 			defer closeDB()
 
-			got := store.getAll()
+			got, err := store.getAll()
+			assertNoError(t, err)
 
 			if len(got) != 50 {
 				t.Error("failed to write to an already setup empty db.")
@@ -64,11 +74,12 @@ func TestFileSystemStore(t *testing.T) {
 			defer cleanTempFile()
 
 			articles := MakeBothTypesOfArticle(50)
-			store, closeDB := NewFileSystemStore(tmpFile, articles, []User{})
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
 			defer closeDB()
 
-			got := store.getAll()
-			want := reverseArticles(articles)
+			got, err := store.getAll()
+			assertNoError(t, err)
+			want := withoutBodies(reverseArticles(articles))
 			assertArticles(t, got, want)
 		})
 
@@ -77,35 +88,56 @@ func TestFileSystemStore(t *testing.T) {
 			defer cleanTempFile()
 
 			progWant, otherWant := MakeSeparatedArticles(50)
-			store, closeDB := NewFileSystemStore(tmpFile, append(progWant, otherWant...), []User{})
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, append(progWant, otherWant...), []User{})
 			defer closeDB()
 
-			progWant = reverseArticles(progWant)
-			otherWant = reverseArticles(otherWant)
+			progWant = withoutBodies(reverseArticles(progWant))
+			otherWant = withoutBodies(reverseArticles(otherWant))
 
-			got, p, mxP := store.getPage(1, progCat)
+			got, total, err := store.getPage(progCat, 1, defaultPerPage)
+			assertNoError(t, err)
 
-			assertInt(t, p, 1)
-			assertInt(t, mxP, 50/perPage)
-			assertArticles(t, got, progWant[0:perPage])
+			assertInt(t, total, 50)
+			assertArticles(t, got, progWant[0:defaultPerPage])
 
-			got, p, mxP = store.getPage(-1, progCat)
+			got, total, err = store.getPage(otherCat, 3, defaultPerPage)
+			assertNoError(t, err)
 
-			assertInt(t, p, 1)
-			assertInt(t, mxP, 50/perPage)
-			assertArticles(t, got, progWant[0:perPage])
+			assertInt(t, total, 50)
+			assertArticles(t, got, otherWant[2*defaultPerPage:3*defaultPerPage])
 
-			got, p, mxP = store.getPage(3, otherCat)
+			// Last page is partly full.
+			got, _, err = store.getPage(otherCat, 3, 20)
+			assertNoError(t, err)
 
-			assertInt(t, p, 3)
-			assertInt(t, mxP, 50/perPage)
-			assertArticles(t, got, otherWant[2*perPage:3*perPage])
+			assertArticles(t, got, otherWant[40:])
 
-			got, p, mxP = store.getPage(6, otherCat)
+			// Past the end.
+			got, _, err = store.getPage(otherCat, 6, defaultPerPage)
+			assertNoError(t, err)
 
-			assertInt(t, p, 5)
-			assertInt(t, mxP, 50/perPage)
-			assertArticles(t, got, otherWant[4*perPage:5*perPage])
+			assertInt(t, len(got), 0)
+		})
+
+		t.Run("get latest", func(t *testing.T) {
+			tmpFile, cleanTempFile := makeTempFile()
+			defer cleanTempFile()
+
+			articles := MakeBothTypesOfArticle(10)
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+			defer closeDB()
+
+			got, err := store.getLatest("", 5)
+			assertNoError(t, err)
+			assertArticles(t, got, reverseArticles(articles)[:5])
+
+			_, otherWant := MakeSeparatedArticles(10)
+			got, err = store.getLatest(otherCat, 3)
+			assertNoError(t, err)
+			assertInt(t, len(got), 3)
+			for i, a := range got {
+				assertArticleWithoutTime(t, a, reverseArticles(otherWant)[i])
+			}
 		})
 
 		t.Run("get single article", func(t *testing.T) {
@@ -113,13 +145,16 @@ func TestFileSystemStore(t *testing.T) {
 			defer cleanTempFile()
 
 			prog, other := MakeSeparatedArticles(20)
-			store, closeDB := NewFileSystemStore(tmpFile, append(prog, other...), []User{})
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, append(prog, other...), []User{})
 			defer closeDB()
 
-			_, got := store.getArticle(prog[0].Slug)
+			_, got, err := store.getArticle(prog[0].Slug)
+			assertNoError(t, err)
 			assertArticle(t, got, prog[0])
 
-			_, got = store.getArticle("does-not-exist")
+			id, got, err := store.getArticle("does-not-exist")
+			assertNoError(t, err)
+			assertInt(t, id, 0)
 			assertArticle(t, got, Article{})
 		})
 
@@ -127,16 +162,17 @@ func TestFileSystemStore(t *testing.T) {
 			tmpFile, cleanTempFile := makeTempFile()
 			defer cleanTempFile()
 
-			store, closeDB := NewFileSystemStore(tmpFile, []Article{}, []User{})
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
 			defer closeDB()
 
 			validArticle := validArticleBase
 			validArticle.Published = myTimeToString(time.Now().UTC())
 			validArticle.Edited = myTimeToString(time.Now().UTC())
 
-			store.newArticle(validArticle)
+			assertNoError(t, store.newArticle(validArticle))
 
-			_, got := store.getArticle(validArticle.Slug)
+			_, got, err := store.getArticle(validArticle.Slug)
+			assertNoError(t, err)
 
 			assertArticle(t, got, validArticle)
 		})
@@ -149,16 +185,18 @@ func TestFileSystemStore(t *testing.T) {
 			tmpFile, cleanTempFile := makeTempFile()
 			defer cleanTempFile()
 
-			store, closeDB := NewFileSystemStore(tmpFile, []Article{old}, []User{})
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{old}, []User{})
 			defer closeDB()
 
 			want := editedBase
 			want.Edited = myTimeToString(time.Now().UTC().Add(time.Second * time.Duration(1)))
 
-			oldID, _ := store.getArticle(validArticleBase.Slug)
-			store.editArticle(oldID, want)
+			oldID, _, err := store.getArticle(validArticleBase.Slug)
+			assertNoError(t, err)
+			assertNoError(t, store.editArticle(oldID, want))
 
-			newID, got := store.getArticle(want.Slug)
+			newID, got, err := store.getArticle(want.Slug)
+			assertNoError(t, err)
 
 			if oldID != newID {
 				t.Errorf("Article not patched but replaced, oldID: %d, newID: %d", oldID, newID)
@@ -167,11 +205,39 @@ func TestFileSystemStore(t *testing.T) {
 			if got.Published != old.Published {
 				t.Error("published time changes when editing article")
 			}
-			if !myStringToTime(got.Edited).After(myStringToTime(old.Edited)) {
+			if !mustStringToTime(t, got.Edited).After(mustStringToTime(t, old.Edited)) {
 				t.Error("edited time not updated when editing article")
 			}
 		})
 
+		t.Run("slug already used by another article", func(t *testing.T) {
+			articles := MakeArticlesOfCategory(2, time.Now().UTC(), progCat)
+
+			tmpFile, cleanTempFile := makeTempFile()
+			defer cleanTempFile()
+
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+			defer closeDB()
+
+			err := store.newArticle(articles[0])
+			if !errors.Is(err, errSlugTaken) {
+				t.Errorf("got %v, want %v", err, errSlugTaken)
+			}
+
+			id, _, err := store.getArticle(articles[1].Slug)
+			assertNoError(t, err)
+			edit := articles[1]
+			edit.Slug = articles[0].Slug
+			err = store.editArticle(id, edit)
+			if !errors.Is(err, errSlugTaken) {
+				t.Errorf("got %v, want %v", err, errSlugTaken)
+			}
+
+			// Keeping an article's own slug is not a conflict.
+			assertNoError(t, store.editArticle(id, articles[1]))
+			assertInt(t, countArticles(t, store), len(articles))
+		})
+
 		t.Run("delete article", func(t *testing.T) {
 			articles := MakeBothTypesOfArticle(5)
 			toDelete := articles[4]
@@ -179,18 +245,84 @@ func TestFileSystemStore(t *testing.T) {
 			tmpFile, cleanTempFile := makeTempFile()
 			defer cleanTempFile()
 
-			store, closeDB := NewFileSystemStore(tmpFile, articles, []User{})
+			store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
 			defer closeDB()
 
-			id, _ := store.getArticle(toDelete.Slug)
-			store.deleteArticle(id)
+			id, _, err := store.getArticle(toDelete.Slug)
+			assertNoError(t, err)
+			assertNoError(t, store.deleteArticle(id))
 
-			_, got := store.getArticle(toDelete.Slug)
+			_, got, err := store.getArticle(toDelete.Slug)
+			assertNoError(t, err)
 			assertArticle(t, got, Article{})
 
-			if len(store.getAll()) != len(articles)-1 {
+			if countArticles(t, store) != len(articles)-1 {
 				t.Error("article not deleted")
 			}
 		})
 	})
 }
+
+func BenchmarkGetPage(b *testing.B) {
+	const total = 100000
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+
+	store, closeDB, err := NewFileSystemStore(tmpFile, nil, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer closeDB()
+
+	// Inserting one transaction per article would take minutes.
+	tx, err := store.db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for i := 0; i < total/2; i++ {
+		for _, a := range []Article{MakeArticleOfCategory(i, now, progCat), MakeArticleOfCategory(i, now, otherCat)} {
+			_, err := tx.Exec("INSERT INTO Articles(Title, Preview, Body, Slug, Published, Edited, Category) values(?, ?, ?, ?, DATETIME(?), ?, ?)",
+				a.Title, a.Preview, a.Body, a.Slug, a.Published, a.Edited, a.Category)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("sql", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := store.getPage(progCat, i%500+1, defaultPerPage); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// How getPage used to work, for comparison: read the whole category, bodies included, then reverse and slice it.
+	b.Run("in memory", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rows, err := store.db.Query("SELECT uid, Title, Preview, Body, Slug, Published, Edited, Category FROM Articles WHERE Category = ?", progCat)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var all []Article
+			for rows.Next() {
+				var a Article
+				var id int
+				if err := rows.Scan(&id, &a.Title, &a.Preview, &a.Body, &a.Slug, &a.Published, &a.Edited, &a.Category); err != nil {
+					b.Fatal(err)
+				}
+				all = append(all, a)
+			}
+			rows.Close()
+
+			p := Pagination{Page: i%500 + 1, PerPage: defaultPerPage, Total: len(all)}
+			all = reverseArticles(all)
+			_ = all[p.Offset() : p.Offset()+p.PerPage]
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
@@ -8,12 +9,13 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -24,10 +26,28 @@ var viewTemplate *template.Template
 var formTemplate *template.Template
 var loginTemplate *template.Template
 var adminPanelTemplate *template.Template
-
-const perPage = 10
+var categoriesTemplate *template.Template
+var revisionsTemplate *template.Template
+var trashTemplate *template.Template
+var searchTemplate *template.Template
+var passwordTemplate *template.Template
+var usersTemplate *template.Template
+var authorTemplate *template.Template
+var profileTemplate *template.Template
+var deleteArticleTemplate *template.Template
+var loginCodeTemplate *template.Template
+var twoFactorTemplate *template.Template
+var lockoutsTemplate *template.Template
+var sessionsTemplate *template.Template
+
+// Functions available to every template. Set by NewServer.
+var templateFuncs = template.FuncMap{
+	"categories": func() []Category { return nil },
+}
 
 const maxTitleLength = 50
+
+// The categories every new database starts with.
 const progCat = "Programming"
 const otherCat = "Other"
 
@@ -39,9 +59,20 @@ const (
 	errSlugEmpty         = "Slug cannot be empty"
 	errSlugAlreadyExists = "Slug is already being used by another article"
 	errSlugBad           = "Slug contains illegal characters"
+	errSlugIsCategory    = "Slug is already being used by a category"
+	errSlugReserved      = "Slug is reserved, please choose another"
 	errCatInvalid        = "Category is invalid"
+	errFormatInvalid     = "Format must be HTML or Markdown"
+	errTagLong           = "Tags cannot be longer than 32 characters"
+	errTagBad            = "Tags must contain a letter or number"
+	errStatusInvalid     = "Status must be draft, scheduled, published or unlisted"
+	errPublishAtInvalid  = "Scheduled articles need a publish time in the future"
+	errSaveFailed        = "Article could not be saved, please try again"
 )
 
+// Returned by Store writes when the slug belongs to a different article.
+var errSlugTaken = errors.New("slug is already in use")
+
 const (
 	loginNoUsername = "Please enter a username."
 	loginNoPassword = "Please enter a password."
@@ -49,20 +80,48 @@ const (
 )
 
 type Article struct {
-	Title     string
+	Title string
+	// Plain text in either format. Listings, feeds and search show it as typed, never as HTML or Markdown.
 	Preview   string
 	Body      string
 	Slug      string
 	Published string
 	Edited    string
 	Category  string
+	Format    string
+	// Markdown source of Body. Empty for HTML articles.
+	Source string
+	Tags   []string
+	Status string
+	// uid of the user who wrote the article, 0 if their account has been deleted.
+	AuthorID int
+	// The author's username and the name to show in bylines, looked up from AuthorID when read. Empty without an author.
+	Author     string
+	AuthorName string
+	// uid of whoever saved this version, 0 if nobody is recorded or their account has been deleted.
+	EditedByID int
+	// Their username, looked up from EditedByID when read.
+	EditedBy string
+	// When the article was moved to the trash. Empty if it isn't in the trash.
+	DeletedAt string
+	// Slugs that redirect to the article. Only filled in for the edit form.
+	PreviousSlugs []string
 }
 
 type PageInfo struct {
 	CurrentPage int
 	MaxPage     int
-	Next        int
-	Prev        int
+	PrevURL     string
+	NextURL     string
+	Pages       []PageLink
+}
+
+// A link in the pagination list. Ellipses stand in for skipped pages.
+type PageLink struct {
+	Number     int
+	URL        string
+	IsCurrent  bool
+	IsEllipsis bool
 }
 
 type User struct {
@@ -70,33 +129,71 @@ type User struct {
 	Username      string
 	Email         string
 	Password_Hash string
+	Role          string
+	// Shown on the author page. All optional.
+	DisplayName string
+	Bio         string
+	// URL of an image.
+	Avatar string
+	// Base32, empty unless the user has turned on two-factor authentication.
+	TOTPSecret string
+}
+
+// The display name, or the username if there isn't one.
+func (u User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Username
 }
 
 type Sesh struct {
-	name          string
+	Name          string
 	Authenticated bool
+	// Forms have to send it back, see checkCSRF.
+	CSRFToken string
+	// The password was right but the user has two-factor authentication, so they
+	// aren't Authenticated until CheckLoginCode gets a code.
+	AwaitingCode bool
+	// A secret being set up on the two-factor page, not saved until a code from it is checked.
+	EnrollSecret string
 }
 
-// Old, use if saving admin data to db.
-// func (u *User) checkPassword(password string) bool {
-// 	// hash password then check if == to Password_Hash
-// 	err := bcrypt.CompareHashAndPassword([]byte(u.Password_Hash), []byte(password))
-// 	return err == nil
-// }
-
-// Current, checks against password in env var.
+// A user that doesn't exist, an empty User, is checked against a dummy hash,
+// so a failed login takes as long whether or not the username exists.
 func (u *User) checkPassword(password string) bool {
-	if password == "" {
-		return false
+	hash := u.Password_Hash
+	if hash == "" {
+		hash = dummyPasswordHash()
 	}
-	return password == admin_pass
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil && u.Password_Hash != ""
 }
 
+var bcryptCost = 14
+
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
 	return string(bytes), err
 }
 
+var dummyHash struct {
+	once sync.Once
+	hash string
+}
+
+// Made on first use, with the same cost as real hashes so checking it takes as long.
+func dummyPasswordHash() string {
+	dummyHash.once.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("not a real password"), bcryptCost)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dummyHash.hash = string(hash)
+	})
+	return dummyHash.hash
+}
+
 var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
 func isEmailValid(e string) bool {
@@ -115,20 +212,21 @@ func isEmailValid(e string) bool {
 }
 
 func (s *Server) isAuth(r *http.Request) bool {
-	session, err := s.sessionStore.Get(r, "user")
+	user, err := s.currentUser(r)
 	if err != nil {
+		log.Print(err)
 		return false
 	}
-	sesh := s.sessionStore.getSesh(session)
-	return sesh.Authenticated
+	return user.Id != 0
 }
 
-func getIP(r *http.Request) string {
-	forwarded := r.Header.Get("X-FORWARDED-FOR")
-	if forwarded != "" {
-		return forwarded
+// The logged in user's username, or an empty string.
+func (s *Server) username(r *http.Request) string {
+	session, err := s.sessionStore.Get(r, "user")
+	if err != nil {
+		return ""
 	}
-	return r.RemoteAddr
+	return s.sessionStore.getSesh(session).Name
 }
 
 func MakeBothTypesOfArticle(n int) []Article {
@@ -153,6 +251,8 @@ func MakeArticlesOfCategory(amount int, now time.Time, category string) []Articl
 			Published: nowOffset,
 			Edited:    nowOffset,
 			Category:  category,
+			Format:    formatHTML,
+			Status:    statusPublished,
 		}
 		ret = append(ret, art)
 	}
@@ -171,6 +271,8 @@ func MakeArticleOfCategory(i int, now time.Time, category string) Article {
 		Published: nowOffset,
 		Edited:    nowOffset,
 		Category:  category,
+		Format:    formatHTML,
+		Status:    statusPublished,
 	}
 	return ret
 }
@@ -187,11 +289,10 @@ func myTimeToString(t time.Time) string {
 	return dateString
 }
 
-func myStringToTime(s string) time.Time {
+// Errors for anything myTimeToString couldn't have written, rather than reading it as the zero time.
+func myStringToTime(s string) (time.Time, error) {
 	layout := "2006-01-02 15:04:05"
-	t, err := time.Parse(layout, s)
-	checkErr(err)
-	return t
+	return time.Parse(layout, s)
 }
 
 func articlesWithoutTimes(articles []Article) []Article {
@@ -213,11 +314,31 @@ func dateWithoutTime(date string) string {
 	return date[:10]
 }
 
-func checkErr(err error) {
-	if err != nil {
-		log.Print(err.Error())
-		// panic(err)
-	}
+func notFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, "404 not found")
+}
+
+func badRequest(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprint(w, "400 bad request")
+}
+
+func forbidden(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprint(w, "403 forbidden")
+}
+
+// For articles that have been deleted.
+func gone(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusGone)
+	fmt.Fprint(w, "410 gone")
+}
+
+func serverError(w http.ResponseWriter, err error) {
+	log.Print(err)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprint(w, "500 internal server error")
 }
 
 func makeTempFile() (*os.File, func()) {
@@ -237,17 +358,8 @@ func makeTempFile() (*os.File, func()) {
 	return tmpfile, removeFile
 }
 
-func reverseArticles(in []Article) []Article {
-	ret := make([]Article, len(in))
-
-	for i, a := range in {
-		ret[len(ret)-i-1] = a
-	}
-
-	return ret
-}
-
-func (s *Server) ValidateArticle(a Article, checkSlugExists bool) (errors []string) {
+// Returns the validation errors to show the user. err is only set if the store couldn't be checked.
+func (s *Server) ValidateArticle(a Article, checkSlugExists bool) (errors []string, err error) {
 	// Check each field.
 	// If Title is too long or doesn't exist.
 	if len([]rune(a.Title)) > maxTitleLength {
@@ -261,7 +373,7 @@ func (s *Server) ValidateArticle(a Article, checkSlugExists bool) (errors []stri
 		errors = append(errors, errPreviewEmpty)
 	}
 	// If Body is empty.
-	if len(a.Body) == 0 {
+	if len(a.EditableBody()) == 0 {
 		errors = append(errors, errBodyEmpty)
 	}
 	// If Slug contains non-valid characters.
@@ -269,8 +381,17 @@ func (s *Server) ValidateArticle(a Article, checkSlugExists bool) (errors []stri
 		errors = append(errors, errSlugEmpty)
 	}
 	// If Slug is already in use.
-	if checkSlugExists && s.store.doesSlugExist(a.Slug) {
-		errors = append(errors, errSlugAlreadyExists)
+	if checkSlugExists {
+		exists, err := s.store.doesSlugExist(a.Slug)
+		if err != nil {
+			return errors, err
+		}
+		if exists {
+			errors = append(errors, errSlugAlreadyExists)
+		}
+	}
+	if isReservedPath(a.Slug) {
+		errors = append(errors, errSlugReserved)
 	}
 	illegalChars := "&$+,/:;=?@# <>[]{}|\\^%"
 slugCheck:
@@ -283,9 +404,46 @@ slugCheck:
 		}
 	}
 	// If Category is not one of the valid categories.
-	if a.Category != progCat && a.Category != otherCat {
+	categories, err := s.store.getCategories()
+	if err != nil {
+		return errors, err
+	}
+	validCategory := false
+	for _, c := range categories {
+		if c.Name == a.Category {
+			validCategory = true
+		}
+		if c.Slug == strings.ToLower(a.Slug) {
+			errors = append(errors, errSlugIsCategory)
+		}
+	}
+	if !validCategory {
 		errors = append(errors, errCatInvalid)
 	}
+	if a.Format != formatHTML && a.Format != formatMarkdown {
+		errors = append(errors, errFormatInvalid)
+	}
+	for _, t := range a.Tags {
+		if len([]rune(t)) > maxTagLength {
+			errors = append(errors, errTagLong)
+			break
+		}
+	}
+	for _, t := range a.Tags {
+		if tagSlug(t) == "" {
+			errors = append(errors, errTagBad)
+			break
+		}
+	}
+	if !isValidStatus(a.Status) {
+		errors = append(errors, errStatusInvalid)
+	}
+	if a.Status == statusScheduled {
+		publishAt, err := myStringToTime(a.Published)
+		if err != nil || !publishAt.After(s.clock.Now().UTC()) {
+			errors = append(errors, errPublishAtInvalid)
+		}
+	}
 	return
 }
 
@@ -300,66 +458,130 @@ func validateUserLogin(username, password string) []string {
 	return ret
 }
 
-func getPageNumber(r *http.Request) int {
-	vars := mux.Vars(r)
-	page, ok := vars["page"]
-	if !ok {
-		page = "1"
+func makePageInfoObject(p Pagination, basePath string) PageInfo {
+	return makePageInfo(p, func(n int) string { return pageURL(basePath, n, p.PerPage) })
+}
+
+// Like makePageInfoObject, for listings whose page urls aren't /page/N.
+func makePageInfo(p Pagination, urlOf func(page int) string) PageInfo {
+	info := PageInfo{CurrentPage: p.Page, MaxPage: p.MaxPage()}
+	if p.HasPrev() {
+		info.PrevURL = urlOf(p.Page - 1)
 	}
-	pageInt, err := strconv.Atoi(page)
-	if err != nil {
-		pageInt = 1
+	if p.HasNext() {
+		info.NextURL = urlOf(p.Page + 1)
 	}
-	return pageInt
+	for _, n := range p.Window() {
+		if n == 0 {
+			info.Pages = append(info.Pages, PageLink{IsEllipsis: true})
+			continue
+		}
+		info.Pages = append(info.Pages, PageLink{Number: n, URL: urlOf(n), IsCurrent: n == p.Page})
+	}
+	return info
 }
 
-func makePageInfoObject(page, maxPage int) PageInfo {
-	return PageInfo{page, maxPage, page + 1, page - 1}
+// Parses the files like template.ParseFiles, with templateFuncs available.
+func newTemplate(files ...string) (*template.Template, error) {
+	return template.New(path.Base(files[0])).Funcs(templateFuncs).ParseFiles(files...)
 }
 
 func setIndexTemplate() *template.Template {
-	return template.Must(template.ParseFiles("static/templates/base.html", "static/templates/nav.html", "static/templates/index.html"))
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/pagination.html", "static/templates/articleSummary.html", "static/templates/index.html"))
 }
 
 func setViewTemplate() *template.Template {
-	return template.Must(template.ParseFiles("static/templates/base.html", "static/templates/nav.html", "static/templates/article.html"))
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/article.html"))
 }
 
 func setFormTemplate() *template.Template {
-	return template.Must(template.ParseFiles("static/templates/base.html", "static/templates/nav.html", "static/templates/articleForm.html"))
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/articleForm.html"))
 }
 
 func setLoginTemplate() *template.Template {
-	return template.Must(template.ParseFiles("static/templates/base.html", "static/templates/nav.html", "static/templates/login.html"))
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/login.html"))
 }
 
 func setAdminPanelTemplate() *template.Template {
-	return template.Must(template.ParseFiles("static/templates/base.html", "static/templates/nav.html", "static/templates/adminPanel.html"))
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/adminPanel.html"))
 }
 
-func getArticleFromForm(r *http.Request) Article {
-	err := r.ParseForm()
-	checkErr(err)
+// err is set if the form couldn't be read, which should be answered with a 400.
+func getArticleFromForm(r *http.Request) (Article, error) {
+	if err := r.ParseForm(); err != nil {
+		return Article{}, err
+	}
 
 	a := Article{Title: r.FormValue("title")}
 	a.Preview = r.FormValue("preview")
-	a.Body = r.FormValue("body")
 	a.Slug = r.FormValue("slug")
-	a.Category = r.Form["category"][0]
-	return a
-}
+	a.Category = r.FormValue("category")
+	a.Tags = parseTags(r.FormValue("tags"))
 
-func indexPage(w http.ResponseWriter, a []Article, cat string, curPage, maxPage int, loggedIn bool) {
-	type ArticleWithIsEdited struct {
-		Article
-		IsEdited bool
+	// Forms from before statuses existed published straight away.
+	a.Status = r.FormValue("status")
+	if a.Status == "" {
+		a.Status = statusPublished
+	}
+	if a.Status == statusScheduled {
+		a.Published = parsePublishAt(r.FormValue("publish_at"))
 	}
 
-	articlesWithIsEdited := []ArticleWithIsEdited{}
+	// Forms from before Markdown support have no format field.
+	a.Format = r.FormValue("format")
+	if a.Format == "" {
+		a.Format = formatHTML
+	}
+	if a.Format == formatMarkdown {
+		a.Source = r.FormValue("body")
+	} else {
+		a.Body = r.FormValue("body")
+	}
+	return a, nil
+}
+
+type ArticleWithIsEdited struct {
+	Article
+	IsEdited bool
+}
+
+// For listings, which show dates without times and whether each article has been edited.
+func articlesWithIsEdited(a []Article) ([]ArticleWithIsEdited, error) {
+	ret := []ArticleWithIsEdited{}
 	for _, v := range a {
-		isEdited := myStringToTime(v.Published).Before(myStringToTime(v.Edited))
-		newA := articleWithoutTime(v)
-		articlesWithIsEdited = append(articlesWithIsEdited, ArticleWithIsEdited{newA, isEdited})
+		edited, err := isEdited(v)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, ArticleWithIsEdited{articleWithoutTime(v), edited})
+	}
+	return ret, nil
+}
+
+// Whether the article was edited after it was published.
+func isEdited(a Article) (bool, error) {
+	published, err := myStringToTime(a.Published)
+	if err != nil {
+		return false, err
+	}
+	edited, err := myStringToTime(a.Edited)
+	if err != nil {
+		return false, err
+	}
+	return published.Before(edited), nil
+}
+
+// basePath is the path of the listing's first page, which the pagination links are built from.
+func indexPage(w http.ResponseWriter, a []Article, cat, heading, intro, basePath string, p Pagination, v Viewer) {
+	articles, err := articlesWithIsEdited(a)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	description := defaultDescription
+	if intro != "" {
+		description = intro
 	}
 
 	// Reload HTML without rebuilding project.
@@ -369,34 +591,41 @@ func indexPage(w http.ResponseWriter, a []Article, cat string, curPage, maxPage
 
 	tmpl := indexTemplate
 	tmpl.Execute(w, struct {
-		Articles    []ArticleWithIsEdited
-		Category    string
-		PageInfo    PageInfo
-		LoggedIn    bool
+		Articles []ArticleWithIsEdited
+		Category string
+		Heading  string
+		Intro    string
+		PageInfo PageInfo
+		Viewer
 		Dev         bool
 		Description string
-	}{articlesWithIsEdited, cat, makePageInfoObject(curPage, maxPage), loggedIn, DEV, defaultDescription})
+	}{articles, cat, heading, intro, makePageInfoObject(p, basePath), v, DEV, description})
 }
 
-func articleView(w http.ResponseWriter, a Article, loggedIn bool) {
-	viewTemplate = setViewTemplate()
-
-	tmpl := viewTemplate
-	// This could be done differently. This may also be what was breaking the 'if DEV' statement.
-	tmpl = template.Must(tmpl.Parse("{{define \"body\"}}" + a.Body + "{{end}}"))
+func articleView(w http.ResponseWriter, a Article, body template.HTML, v Viewer) {
+	// Reload HTML without rebuilding project.
+	if DEV {
+		viewTemplate = setViewTemplate()
+	}
 
-	isEdited := myStringToTime(a.Published).Before(myStringToTime(a.Edited))
+	edited, err := isEdited(a)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
 
+	tmpl := viewTemplate
 	tmpl.Execute(w, struct {
-		Article     Article
-		IsEdited    bool
-		LoggedIn    bool
+		Article  Article
+		Body     template.HTML
+		IsEdited bool
+		Viewer
 		Dev         bool
 		Description string
-	}{articleWithoutTime(a), isEdited, loggedIn, DEV, dateWithoutTime(a.Published) + " " + a.Preview})
+	}{articleWithoutTime(a), body, edited, v, DEV, dateWithoutTime(a.Published) + " " + a.Preview})
 }
 
-func executeArticleForm(w http.ResponseWriter, a Article, slugValueAttr template.HTMLAttr, formAction string, loggedIn bool, errors ...[]string) {
+func executeArticleForm(w http.ResponseWriter, a Article, slugValueAttr template.HTMLAttr, formAction string, v Viewer, errors ...[]string) {
 	if DEV {
 		formTemplate = setFormTemplate()
 	}
@@ -407,45 +636,57 @@ func executeArticleForm(w http.ResponseWriter, a Article, slugValueAttr template
 			SlugValueAttr template.HTMLAttr
 			FormAction    string
 			Errors        []string
-			LoggedIn      bool
-			Dev           bool
-			Description   string
-		}{a, slugValueAttr, formAction, errors[0], loggedIn, DEV, defaultDescription})
+			Viewer
+			Dev         bool
+			Description string
+		}{a, slugValueAttr, formAction, errors[0], v, DEV, defaultDescription})
 	} else {
 		tmpl.Execute(w, struct {
 			Article       Article
 			SlugValueAttr template.HTMLAttr
 			FormAction    string
 			Errors        []string
-			Dev           bool
-			LoggedIn      bool
-			Description   string
-		}{a, slugValueAttr, formAction, []string{}, loggedIn, DEV, defaultDescription})
+			Viewer
+			Dev         bool
+			Description string
+		}{a, slugValueAttr, formAction, []string{}, v, DEV, defaultDescription})
 	}
 }
 
-func loginForm(w http.ResponseWriter, errors []string, loggedIn bool) {
+// The form needs a session to tie its CSRF token to, even though nobody is logged in yet.
+func (s *Server) loginForm(w http.ResponseWriter, r *http.Request, status int, errors []string) {
+	token, err := s.csrfToken(w, r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	v := Viewer{LoggedIn: s.isAuth(r), CSRFToken: token}
 	if DEV {
 		loginTemplate = setLoginTemplate()
 	}
+	w.WriteHeader(status)
 	tmpl := loginTemplate
 	tmpl.Execute(w, struct {
-		Errors      []string
-		LoggedIn    bool
+		Errors []string
+		Viewer
 		Dev         bool
 		Description string
-	}{errors, loggedIn, DEV, defaultDescription})
+	}{errors, v, DEV, defaultDescription})
 }
 
-func adminPanel(w http.ResponseWriter, articles []Article, loggedIn bool) {
+// Only links to what the user is allowed to do.
+func adminPanel(w http.ResponseWriter, articles []Article, user User, v Viewer) {
 	if DEV {
 		adminPanelTemplate = setAdminPanelTemplate()
 	}
 	tmpl := adminPanelTemplate
 	tmpl.Execute(w, struct {
-		Articles    []Article
-		LoggedIn    bool
+		Articles   []Article
+		User       User
+		CanEditAny bool
+		CanManage  bool
+		Viewer
 		Dev         bool
 		Description string
-	}{articles, loggedIn, DEV, defaultDescription})
+	}{articles, user, user.can(permEditAny), user.can(permManage), v, DEV, defaultDescription})
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"html"
+	"html/template"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// Theme used for code blocks unless blog_code_theme is set. Any chroma style name works.
+const defaultCodeTheme = "github"
+
+// Served from memory, it's generated from the theme at startup.
+const highlightCSSPath = "/static/css/highlight.css"
+
+// Drop the cache rather than let it grow forever. Bodies are re-highlighted on the next view.
+const maxCachedBodies = 500
+
+// Matches code blocks from Markdown fences or written by hand as <pre><code class="language-x">.
+// data-highlight lists lines to highlight, e.g. "1,3-5".
+var codeBlockRegex = regexp.MustCompile(`(?s)<pre><code class="language-([\w+#-]+)"(?: data-highlight="([0-9, -]*)")?>(.*?)</code></pre>`)
+
+type Highlighter struct {
+	style *chroma.Style
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]template.HTML
+}
+
+func NewHighlighter(theme string) *Highlighter {
+	h := new(Highlighter)
+	h.style = styles.Get(theme)
+	h.cache = map[[sha256.Size]byte]template.HTML{}
+	return h
+}
+
+// Sanitizes and highlights an article body. Results are cached by body, so each revision is only rendered once.
+func (h *Highlighter) renderBody(body string) template.HTML {
+	key := sha256.Sum256([]byte(body))
+
+	h.mu.Lock()
+	cached, ok := h.cache[key]
+	h.mu.Unlock()
+	if ok {
+		return cached
+	}
+
+	rendered := template.HTML(h.highlight(string(sanitizeBody(body))))
+
+	h.mu.Lock()
+	if len(h.cache) >= maxCachedBodies {
+		h.cache = map[[sha256.Size]byte]template.HTML{}
+	}
+	h.cache[key] = rendered
+	h.mu.Unlock()
+
+	return rendered
+}
+
+// Expects sanitized HTML. Code blocks that contain markup instead of plain text are left alone.
+func (h *Highlighter) highlight(body string) string {
+	return codeBlockRegex.ReplaceAllStringFunc(body, func(block string) string {
+		match := codeBlockRegex.FindStringSubmatch(block)
+		lang, lines, escaped := match[1], match[2], match[3]
+		if strings.Contains(escaped, "<") {
+			return block
+		}
+
+		lexer := lexers.Get(lang)
+		if lexer == nil {
+			lexer = lexers.Fallback
+		}
+		iterator, err := chroma.Coalesce(lexer).Tokenise(nil, html.UnescapeString(escaped))
+		if err != nil {
+			return block
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(`<div class="code-block"><button class="button is-small copy-button" type="button" data-copy-code>Copy</button>`)
+		if err := h.formatter(parseLineRanges(lines)).Format(&buf, h.style, iterator); err != nil {
+			return block
+		}
+		buf.WriteString(`</div>`)
+		return buf.String()
+	})
+}
+
+func (h *Highlighter) formatter(highlighted [][2]int) *chromahtml.Formatter {
+	return chromahtml.New(
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(true),
+		chromahtml.LineNumbersInTable(true),
+		chromahtml.HighlightLines(highlighted),
+	)
+}
+
+// Writes the stylesheet for the theme's classes.
+func (h *Highlighter) WriteCSS(w io.Writer) error {
+	return h.formatter(nil).WriteCSS(w, h.style)
+}
+
+// Parses "1,3-5" into [[1 1] [3 5]]. Anything unreadable is skipped.
+func parseLineRanges(s string) [][2]int {
+	var ret [][2]int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			continue
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(strings.TrimSpace(to))
+			if err != nil || end < start {
+				continue
+			}
+		}
+		ret = append(ret, [2]int{start, end})
+	}
+	return ret
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHighlighter(t *testing.T) {
+	h := NewHighlighter(defaultCodeTheme)
+
+	t.Run("highlights code blocks with a language", func(t *testing.T) {
+		got := string(h.renderBody(`<p>Intro</p><pre><code class="language-go">if a &lt; b {
+	return &#34;x&#34;
+}</code></pre>`))
+
+		assertContains(t, got, "<p>Intro</p>")
+		assertContains(t, got, `<div class="chroma">`)
+		assertContains(t, got, `<span class="k">if</span>`)
+		// Entities are decoded before highlighting and escaped once on the way out.
+		assertContains(t, got, "&lt;")
+		assertNotContain(t, got, "&amp;lt;")
+		assertContains(t, got, "<span class=\"lnt\">3\n</span>")
+		assertContains(t, got, "data-copy-code")
+	})
+
+	t.Run("highlights the requested lines", func(t *testing.T) {
+		got := string(h.renderBody(`<pre><code class="language-go" data-highlight="2">a := 1
+b := 2
+c := 3</code></pre>`))
+
+		if strings.Count(got, `hl"`) != 2 {
+			t.Errorf("want line 2 highlighted in the number and code columns, got %s", got)
+		}
+	})
+
+	t.Run("leaves blocks without a language or with markup alone", func(t *testing.T) {
+		plain := `<pre><code>just text</code></pre>`
+		assertContains(t, string(h.renderBody(plain)), plain)
+
+		marked := `<pre><code class="language-go"><strong>bold</strong></code></pre>`
+		assertContains(t, string(h.renderBody(marked)), marked)
+	})
+
+	t.Run("unknown languages still get line numbers", func(t *testing.T) {
+		got := string(h.renderBody(`<pre><code class="language-notalanguage">x</code></pre>`))
+		assertContains(t, got, "<span class=\"lnt\">1\n</span>")
+	})
+
+	t.Run("markdown fences can highlight lines", func(t *testing.T) {
+		body, err := renderMarkdown("```go {1,3}\na := 1\nb := 2\nc := 3\n```")
+		assertNoError(t, err)
+		assertContains(t, body, `<pre><code class="language-go" data-highlight="1,3">`)
+
+		got := string(h.renderBody(body))
+		if strings.Count(got, `hl"`) != 4 {
+			t.Errorf("want lines 1 and 3 highlighted, got %s", got)
+		}
+	})
+
+	t.Run("scripts are still removed", func(t *testing.T) {
+		got := string(h.renderBody(`<script>alert(1)</script><pre><code class="language-js">alert(1)</code></pre>`))
+		assertNotContain(t, got, "<script>")
+	})
+
+	t.Run("rendered bodies are cached", func(t *testing.T) {
+		cached := NewHighlighter(defaultCodeTheme)
+		body := `<pre><code class="language-go">x := 1</code></pre>`
+		first := cached.renderBody(body)
+		if len(cached.cache) != 1 {
+			t.Fatalf("want 1 cached body, got %d", len(cached.cache))
+		}
+		if second := cached.renderBody(body); second != first {
+			t.Error("cached render differs from the first")
+		}
+		cached.renderBody(body + "<p>edited</p>")
+		assertInt(t, len(cached.cache), 2)
+	})
+
+	t.Run("line ranges", func(t *testing.T) {
+		got := parseLineRanges("1, 3-5,x,7-6,9")
+		want := [][2]int{{1, 1}, {3, 5}, {9, 9}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("theme stylesheet is served", func(t *testing.T) {
+		server := NewServer(&StubStore{}, &StubSessionStore{})
+
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, highlightCSSPath))
+
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Header().Get("Content-Type"), "text/css")
+		assertContains(t, resp.Body.String(), ".chroma")
+	})
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/smtp"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/jordan-wright/email"
+	"github.com/alecthomas/chroma/v2/styles"
 )
 
 var DEV bool
@@ -20,20 +28,106 @@ var base = os.Getenv("blog_dir")
 var dbName = "blog.db"
 var dbPath = base + "/" + dbName
 
-var sendEmailToAdmin func(*http.Request, bool) = func(*http.Request, bool) {}
+var codeTheme = defaultCodeTheme
 
-// Defaults for testing. Uses env vars for production.
+// Used to build absolute links in feeds.
+var siteURL = "http://localhost:" + strconv.Itoa(port)
+
+// Feeds carry article previews unless set to true.
+var feedFullContent = false
+
+// Used to create the admin the first time the blog runs in production.
 var admin_username = "admin"
 var admin_pass = "password"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		if err := keygen(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "notify" {
+		if err := notifyFromCommandLine(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Sets up and runs the blog until it's told to stop. Returns errors rather than calling
+// log.Fatal, so everything deferred, like flushing notifications, still runs.
+func run() error {
 	if base == "" {
-		log.Fatal("Environment variable not set: blog_dir")
+		return errors.New("Environment variable not set: blog_dir")
 	}
 
 	var err error
 	var dbFile *os.File
 	var server *Server
+	// Told about logins and publishing, see notifierFromEnv.
+	var notifier Notifier
+
+	if url := os.Getenv("blog_url"); url != "" {
+		siteURL = strings.TrimSuffix(url, "/")
+	} else {
+		log.Printf("Environment variable not set: blog_url, defaulting to %s", siteURL)
+	}
+
+	switch os.Getenv("blog_feed_content") {
+	case "", "preview":
+	case "full":
+		feedFullContent = true
+	default:
+		return errors.New("Environment variable invalid: blog_feed_content, must be preview or full")
+	}
+
+	if theme := os.Getenv("blog_code_theme"); theme != "" {
+		if _, ok := styles.Registry[theme]; !ok {
+			return fmt.Errorf("Environment variable invalid: blog_code_theme, unknown theme %s", theme)
+		}
+		codeTheme = theme
+	}
+
+	if days := os.Getenv("blog_trash_days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return errors.New("Environment variable invalid: blog_trash_days, must be a number of days")
+		}
+		trashRetention = time.Duration(n) * 24 * time.Hour
+	}
+
+	if proxy := os.Getenv("blog_trusted_proxy"); proxy != "" {
+		if net.ParseIP(proxy) == nil {
+			return errors.New("Environment variable invalid: blog_trusted_proxy, must be an IP address")
+		}
+		trustedProxy = net.ParseIP(proxy).String()
+	}
+
+	// Where failed logins are counted. In memory they're forgotten on restart.
+	var sqliteLimiter bool
+	switch os.Getenv("blog_login_limiter") {
+	case "", "memory":
+	case "sqlite":
+		sqliteLimiter = true
+	default:
+		return errors.New("Environment variable invalid: blog_login_limiter, must be memory or sqlite")
+	}
+
+	// Where sessions are kept. In cookies they can't be listed or revoked.
+	var sqliteSessions bool
+	switch os.Getenv("blog_session_store") {
+	case "", "memory":
+	case "sqlite":
+		sqliteSessions = true
+	default:
+		return errors.New("Environment variable invalid: blog_session_store, must be memory or sqlite")
+	}
 
 	DEV, err = strconv.ParseBool(os.Getenv("blog_dev"))
 	if err != nil {
@@ -55,12 +149,18 @@ func main() {
 			Username:      "admin",
 			Email:         "admin@example.com",
 			Password_Hash: pass_hash,
+			Role:          roleAdmin,
 		}
 
-		store, closeDB := NewFileSystemStore(dbFile, fakes, []User{admin})
+		store, closeDB, err := NewFileSystemStore(dbFile, fakes, []User{admin})
+		if err != nil {
+			return fmt.Errorf("problem setting up store %v", err)
+		}
 		defer closeDB()
 		sessStore := NewMemorySessionStore()
 		server = NewServer(store, sessStore)
+		notifier = NewLogNotifier(os.Stderr)
+		server.notifier = notifier
 	} else {
 		log.Print("Running in PRODUCTION mode.")
 
@@ -73,81 +173,170 @@ func main() {
 
 		dbFile, err = os.OpenFile(dbPath, os.O_RDWR|os.O_CREATE, 0600)
 		if err != nil {
-			log.Fatalf("problem opening %s %v", dbPath, err)
+			return fmt.Errorf("problem opening %s %v", dbPath, err)
 		}
 		defer dbFile.Close()
 
+		// Only needed until the admin has been created.
 		admin_username = os.Getenv("blog_username")
-		if admin_username == "" {
-			log.Fatal("Environment variable not set: blog_username")
-		}
-
 		admin_pass = os.Getenv("blog_password")
-		if admin_pass == "" {
-			log.Fatal("Environment variable not set: blog_password")
-		}
-
-		// Not used at the moment.
-		// pass_hash, err := HashPassword(admin_pass)
-		// if err != nil {
-		// 	log.Fatal("Couldn't hash password")
-		// }
 
 		admin_email := os.Getenv("blog_email")
 		if admin_email == "" {
-			log.Fatal("Environment variable not set: blog_email")
+			return errors.New("Environment variable not set: blog_email")
 		}
 		if !isEmailValid(admin_email) {
-			log.Fatal("Environment variable invalid: blog_email")
-		}
-
-		bridge_pass := os.Getenv("blog_bridgepass")
-		if bridge_pass == "" {
-			log.Fatal("Environment variable not set: blog_bridgepass")
-		}
-
-		// admin := User{
-		// 	Username:      admin_username,
-		// 	Email:         admin_email,
-		// 	Password_Hash: pass_hash,
-		// }
-
-		// Only used in production mode. Not in tests nor development mode.
-		sendEmailToAdmin = func(r *http.Request, successfulLogin bool) {
-			e := email.NewEmail()
-			e.From = "Blog Server <" + admin_email + ">"
-			e.To = []string{admin_email}
-
-			// if backup {
-			// TRYING TO ATTACH A FILE CAUSES A 'ContentID is not valid' error.
-			// e.Subject = "Blog Backup"
-			// e.AttachFile(dbPath)
-
-			// Send details about the login attempt. Location, username.
-			ip := getIP(r)
-			if successfulLogin {
-				e.Subject = "Blog Successful Login Attempt Notification"
-				e.Text = []byte("SUCCESSFUL LOGIN ATTEMPT. IP: " + ip)
-			} else {
-				e.Subject = "Blog Failed Login Attempt Notification"
-				e.Text = []byte("FAILED LOGIN ATTEMPT. IP: " + ip + ", attempted username: " + r.FormValue("username") + ", attempted password: " + r.FormValue("password"))
-			}
+			return errors.New("Environment variable invalid: blog_email")
+		}
 
-			err := e.Send("127.0.0.1:1025", smtp.PlainAuth("", admin_email, bridge_pass, "127.0.0.1"))
-			if err != nil {
-				log.Print(err)
-			}
+		batcher, closeNotifier, err := notifierFromEnv(admin_email)
+		if err != nil {
+			return err
 		}
+		defer closeNotifier()
+		notifier = batcher
 
-		// store, closeDB := NewFileSystemStore(dbFile, []Article{}, []User{admin})
-		store, closeDB := NewFileSystemStore(dbFile, []Article{}, []User{User{}})
+		store, closeDB, err := NewFileSystemStore(dbFile, []Article{}, nil)
+		if err != nil {
+			return fmt.Errorf("problem setting up store %v", err)
+		}
 		defer closeDB()
-		sessStore := NewMemorySessionStore()
+		if err := bootstrapAdmin(store, admin_username, admin_email, admin_pass); err != nil {
+			return err
+		}
+		sessionKeys, err := sessionKeysFromEnv()
+		if err != nil {
+			return fmt.Errorf("problem loading session keys %v", err)
+		}
+		if sessionKeys == nil {
+			log.Print("Environment variable not set: blog_session_keys or blog_session_keys_file, restarting will log everyone out. Run keygen to make keys")
+		}
+		var sessStore SessionStore = NewMemorySessionStore(sessionKeys...)
+		if sqliteSessions {
+			sessStore = NewSQLiteSessionStore(store.db, sessionKeys...)
+		}
 		server = NewServer(store, sessStore)
+		server.notifier = notifier
+		if sqliteLimiter {
+			server.limiter = NewSQLiteLimiter(store.db)
+		}
 	}
 
+	scheduler := NewScheduler(server.store, realClock{}, schedulerInterval, trashRetention)
+	scheduler.notifier = notifier
+	scheduler.sessions, _ = server.sessionStore.(serverSessions)
+	stopScheduler := scheduler.Start()
+	defer stopScheduler()
+
 	log.Printf("Running server on port %d", port)
-	if err := http.ListenAndServe(":"+strconv.Itoa(port), server); err != nil {
-		log.Fatalf("could not listen on port %d %v", port, err)
+	return serve(server)
+}
+
+// How long requests that are running get to finish when shutting down.
+const shutdownTimeout = 30 * time.Second
+
+// Serves until SIGINT or SIGTERM, then stops taking requests and waits for the running ones.
+func serve(handler http.Handler) error {
+	srv := &http.Server{Addr: ":" + strconv.Itoa(port), Handler: handler}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("could not listen on port %d %v", port, err)
+	case sig := <-stop:
+		log.Printf("Received %v, shutting down", sig)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+// Sends notifications to whichever of these are set, batched so a burst of events is one email or request:
+// blog_smtp_addr (host:port) emails adminEmail, logging in with blog_smtp_username and blog_smtp_password if set.
+// blog_webhook_url is POSTed JSON. blog_notify_log is a file to append JSON lines to.
+// blog_notify_window is how long to batch for, a minute by default.
+// Call flush before exiting, so batched events aren't lost.
+func notifierFromEnv(adminEmail string) (n *Batcher, flush func(), err error) {
+	var notifiers multiNotifier
+	var closers []func() error
+
+	if addr := os.Getenv("blog_smtp_addr"); addr != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, nil, errors.New("Environment variable invalid: blog_smtp_addr, must be host:port")
+		}
+		var auth smtp.Auth
+		if password := os.Getenv("blog_smtp_password"); password != "" {
+			username := os.Getenv("blog_smtp_username")
+			if username == "" {
+				username = adminEmail
+			}
+			auth = smtp.PlainAuth("", username, password, host)
+		}
+		notifiers = append(notifiers, &SMTPNotifier{Addr: addr, Auth: auth, From: adminEmail, To: []string{adminEmail}})
+	} else {
+		log.Print("Environment variable not set: blog_smtp_addr, notifications won't be emailed")
+	}
+
+	if webhook := os.Getenv("blog_webhook_url"); webhook != "" {
+		if !isWebURL(webhook) {
+			return nil, nil, errors.New("Environment variable invalid: blog_webhook_url, must be an http or https URL")
+		}
+		notifiers = append(notifiers, &WebhookNotifier{URL: webhook, Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+
+	window := time.Minute
+	if w := os.Getenv("blog_notify_window"); w != "" {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			return nil, nil, errors.New("Environment variable invalid: blog_notify_window, must be a duration like 1m")
+		}
+		window = d
+	}
+
+	if path := os.Getenv("blog_notify_log"); path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("problem opening %s %v", path, err)
+		}
+		closers = append(closers, f.Close)
+		notifiers = append(notifiers, NewLogNotifier(f))
+	}
+
+	b := NewBatcher(notifiers, window, notifyBatchMax)
+	return b, func() {
+		if err := b.Flush(); err != nil {
+			log.Print(err)
+		}
+		for _, c := range closers {
+			c()
+		}
+	}, nil
+}
+
+// The notify command, for jobs outside the blog like a nightly backup. Sends the event
+// through the notifiers notifierFromEnv sets up, straight away rather than batched.
+//
+//	blog notify backup_finished [detail]
+func notifyFromCommandLine(args []string) error {
+	adminEmail := os.Getenv("blog_email")
+	if adminEmail == "" {
+		return errors.New("Environment variable not set: blog_email")
+	}
+	n, closeNotifier, err := notifierFromEnv(adminEmail)
+	if err != nil {
+		return err
+	}
+	defer closeNotifier()
+	if err := notifyCommand(args, n); err != nil {
+		return err
 	}
+	return n.Flush()
 }
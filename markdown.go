@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// Article formats. HTML articles are stored as typed, Markdown articles keep their source and the HTML rendered from it.
+const (
+	formatHTML     = "html"
+	formatMarkdown = "markdown"
+)
+
+// CommonMark plus the GFM tables, footnotes and task lists.
+// Raw HTML is passed through, authors could write the same thing in an HTML article anyway.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Table,
+		extension.Footnote,
+		extension.TaskList,
+	),
+	goldmark.WithRendererOptions(
+		html.WithUnsafe(),
+		renderer.WithNodeRenderers(util.Prioritized(fencedCodeRenderer{}, 100)),
+	),
+)
+
+// Highlighted lines can be given after the language of a fence, e.g. ```go {1,3-5}
+var fenceLinesRegex = regexp.MustCompile(`\{([0-9, -]+)\}`)
+
+// Renders fences the same way as the default renderer, plus a data-highlight attribute for the Highlighter.
+type fencedCodeRenderer struct{}
+
+func (r fencedCodeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.render)
+}
+
+func (r fencedCodeRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		w.WriteString("</code></pre>\n")
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*ast.FencedCodeBlock)
+	w.WriteString("<pre><code")
+	if language := n.Language(source); language != nil {
+		w.WriteString(` class="language-`)
+		html.DefaultWriter.Write(w, language)
+		w.WriteString(`"`)
+		if n.Info != nil {
+			if lines := fenceLinesRegex.FindSubmatch(n.Info.Segment.Value(source)); lines != nil {
+				w.WriteString(` data-highlight="`)
+				w.Write(lines[1])
+				w.WriteString(`"`)
+			}
+		}
+	}
+	w.WriteString(">")
+	for i := 0; i < n.Lines().Len(); i++ {
+		line := n.Lines().At(i)
+		html.DefaultWriter.RawWrite(w, line.Value(source))
+	}
+	return ast.WalkContinue, nil
+}
+
+func renderMarkdown(source string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Fills in Body from Source for Markdown articles. HTML articles are returned unchanged.
+// Preview is plain text whatever the format, so it's never rendered.
+func renderArticle(a Article) (Article, error) {
+	if a.Format != formatMarkdown {
+		return a, nil
+	}
+	body, err := renderMarkdown(a.Source)
+	if err != nil {
+		return a, err
+	}
+	a.Body = body
+	return a, nil
+}
+
+// The text the author edits: the Markdown source, or the HTML body itself.
+func (a Article) EditableBody() string {
+	if a.Format == formatMarkdown {
+		return a.Source
+	}
+	return a.Body
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestMarkdown(t *testing.T) {
+	t.Run("renders CommonMark and GFM extensions", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			source string
+			want   []string
+		}{
+			{"paragraphs and emphasis", "Some *emphasis* here.", []string{"<p>Some <em>emphasis</em> here.</p>"}},
+			{"fenced code", "```go\nfmt.Println(\"hi\")\n```", []string{`<pre><code class="language-go">`}},
+			{"tables", "| a | b |\n| - | - |\n| 1 | 2 |", []string{"<table>", "<th>a</th>", "<td>2</td>"}},
+			{"footnotes", "Claim.[^1]\n\n[^1]: Source.", []string{`<sup id="fnref:1">`, `<div class="footnotes" role="doc-endnotes">`}},
+			{"task lists", "- [x] done\n- [ ] todo", []string{`<input checked="" disabled="" type="checkbox"`, `<input disabled="" type="checkbox"`}},
+		}
+
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				got, err := renderMarkdown(c.source)
+				assertNoError(t, err)
+				for _, want := range c.want {
+					assertContains(t, got, want)
+				}
+			})
+		}
+	})
+
+	t.Run("renderArticle fills in the body of Markdown articles only", func(t *testing.T) {
+		md := Article{Format: formatMarkdown, Source: "# Heading", Preview: "*Plain* text"}
+		got, err := renderArticle(md)
+		assertNoError(t, err)
+		assertContains(t, got.Body, "<h1>Heading</h1>")
+		if got.Preview != md.Preview {
+			t.Errorf("preview changed, got %q, want %q", got.Preview, md.Preview)
+		}
+		if got.Source != md.Source {
+			t.Errorf("source changed, got %q, want %q", got.Source, md.Source)
+		}
+
+		html := Article{Format: formatHTML, Body: "<p># Not a heading</p>"}
+		got, err = renderArticle(html)
+		assertNoError(t, err)
+		assertArticle(t, got, html)
+	})
+}
@@ -11,22 +11,34 @@ type MemorySessionStore struct {
 	cs *sessions.CookieStore
 }
 
-func NewMemorySessionStore() *MemorySessionStore {
-	authKeyOne := securecookie.GenerateRandomKey(64)
-	encryptionKeyOne := securecookie.GenerateRandomKey(32)
+// keyPairs are auth and encryption keys, see parseSessionKeys. Without any, random keys are
+// made, so sessions don't outlast the process.
+func NewMemorySessionStore(keyPairs ...[]byte) *MemorySessionStore {
+	if len(keyPairs) == 0 {
+		keyPairs = [][]byte{
+			securecookie.GenerateRandomKey(sessionAuthKeyLen),
+			securecookie.GenerateRandomKey(sessionEncryptionKeyLen),
+		}
+	}
 
 	m := new(MemorySessionStore)
-	m.cs = m.NewCookieStore(
-		authKeyOne,
-		encryptionKeyOne,
-	)
+	m.cs = m.NewCookieStore(keyPairs...)
+
+	m.cs.Options = newSessionOptions()
+	return m
+}
+
+// How long a session lasts after it was last saved, in seconds.
+const sessionMaxAge = 22800
 
-	m.cs.Options = &sessions.Options{
-		MaxAge:   22800,
+func newSessionOptions() *sessions.Options {
+	return &sessions.Options{
+		MaxAge:   sessionMaxAge,
 		HttpOnly: true,
 		Path:     "/",
+		// Cookies aren't sent with other sites' POSTs. checkCSRF still checks for browsers that ignore this.
+		SameSite: http.SameSiteLaxMode,
 	}
-	return m
 }
 
 func (m *MemorySessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
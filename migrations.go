@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each file is named NNNN_description.sql and is run once, in order of NNNN.
+// Never edit a migration that has been released, add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations() ([]migration, error) {
+	names, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []migration
+	for _, entry := range names {
+		name := entry.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s is not named NNNN_description.sql", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s is not named NNNN_description.sql", name)
+		}
+		contents, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, migration{version, name, string(contents)})
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].version < ret[j].version })
+	for i, m := range ret {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %s is out of sequence, expected version %d", m.name, i+1)
+		}
+	}
+	return ret, nil
+}
+
+// Brings the database up to the latest schema version known by this binary.
+func (f *FileSystemStore) migrate() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	_, err = f.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		"version" INTEGER PRIMARY KEY,
+		"name" VARCHAR(255) NOT NULL,
+		"applied_at" VARCHAR(64) NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	current, err := f.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema is version %d but this binary only knows up to version %d, refusing to start", current, len(migrations))
+	}
+
+	for _, m := range migrations[current:] {
+		if err := f.applyMigration(m); err != nil {
+			// go-sqlite3 leaves FTS5 out unless it's asked for, see the README.
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				return fmt.Errorf("migration %s failed, SQLite was built without FTS5, rebuild with -tags sqlite_fts5", m.name)
+			}
+			return fmt.Errorf("migration %s failed, %v", m.name, err)
+		}
+	}
+	return nil
+}
+
+func (f *FileSystemStore) applyMigration(m migration) error {
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations(version, name, applied_at) values(?, ?, ?)", m.version, m.name, myTimeToString(time.Now().UTC())); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Returns 0 for a database that has never been migrated.
+func (f *FileSystemStore) schemaVersion() (int, error) {
+	var version sql.NullInt64
+	err := f.db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
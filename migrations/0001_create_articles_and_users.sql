@@ -0,0 +1,19 @@
+-- Databases created before migrations existed already have these tables,
+-- so this step only records them as version 1.
+CREATE TABLE IF NOT EXISTS Articles (
+  "uid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Title" VARCHAR(64) NULL,
+  "Preview" TEXT NULL,
+  "Body" TEXT NULL,
+  "Slug" VARCHAR(64) NULL,
+  "Published" VARCHAR(64) NULL,
+  "Edited" VARCHAR(64) NULL,
+  "Category" VARCHAR(64) NULL
+);
+
+CREATE TABLE IF NOT EXISTS Users (
+  "uid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Username" VARCHAR(64) NULL,
+  "Email" VARCHAR(64) NULL,
+  "Password_Hash" VARCHAR(255) NULL
+);
@@ -0,0 +1,4 @@
+-- Index pages filter by category and sort by publish date, article pages look up by slug.
+CREATE INDEX IF NOT EXISTS idx_articles_category_published ON Articles(Category, Published);
+CREATE INDEX IF NOT EXISTS idx_articles_published ON Articles(Published);
+CREATE INDEX IF NOT EXISTS idx_articles_slug ON Articles(Slug);
@@ -0,0 +1,3 @@
+-- Articles written before Markdown support are HTML. Source holds the Markdown an article was rendered from.
+ALTER TABLE Articles ADD COLUMN Format VARCHAR(16) NOT NULL DEFAULT 'html';
+ALTER TABLE Articles ADD COLUMN Source TEXT NOT NULL DEFAULT '';
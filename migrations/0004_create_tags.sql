@@ -0,0 +1,14 @@
+-- Free-form tags. An article can have any number of tags and a tag any number of articles.
+CREATE TABLE Tags (
+  "uid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Name" VARCHAR(64) NOT NULL,
+  "Slug" VARCHAR(64) NOT NULL UNIQUE
+);
+
+CREATE TABLE ArticleTags (
+  "ArticleID" INTEGER NOT NULL REFERENCES Articles(uid) ON DELETE CASCADE,
+  "TagID" INTEGER NOT NULL REFERENCES Tags(uid) ON DELETE CASCADE,
+  PRIMARY KEY ("ArticleID", "TagID")
+);
+
+CREATE INDEX idx_article_tags_tag ON ArticleTags(TagID);
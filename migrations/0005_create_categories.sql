@@ -0,0 +1,15 @@
+-- Categories used to be hardcoded. Articles still refer to their category by name.
+-- The category with the lowest SortOrder is shown on the home page.
+CREATE TABLE Categories (
+  "uid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Name" VARCHAR(64) NOT NULL UNIQUE,
+  "Slug" VARCHAR(64) NOT NULL UNIQUE,
+  "Description" TEXT NOT NULL DEFAULT '',
+  "SortOrder" INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO Categories(Name, Slug, SortOrder) VALUES ('Programming', 'programming', 0), ('Other', 'other', 1);
+
+-- Keep any other category an article was saved with, so it doesn't vanish from the site.
+INSERT OR IGNORE INTO Categories(Name, Slug, SortOrder)
+  SELECT DISTINCT Category, lower(replace(Category, ' ', '-')), 2 FROM Articles WHERE Category != '';
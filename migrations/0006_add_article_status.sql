@@ -0,0 +1,4 @@
+-- Every article written before statuses existed was published straight away.
+ALTER TABLE Articles ADD COLUMN Status VARCHAR(16) NOT NULL DEFAULT 'published';
+
+CREATE INDEX idx_articles_status_published ON Articles(Status, Published);
@@ -0,0 +1,24 @@
+-- Who saved the current version of an article.
+ALTER TABLE Articles ADD COLUMN EditedBy VARCHAR(64) NOT NULL DEFAULT '';
+
+-- A copy of an article every time it's saved, oldest first.
+CREATE TABLE ArticleRevisions (
+  "uid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "ArticleID" INTEGER NOT NULL REFERENCES Articles(uid) ON DELETE CASCADE,
+  "Title" VARCHAR(64) NOT NULL DEFAULT '',
+  "Preview" TEXT NOT NULL DEFAULT '',
+  "Body" TEXT NOT NULL DEFAULT '',
+  "Format" VARCHAR(16) NOT NULL DEFAULT 'html',
+  "Source" TEXT NOT NULL DEFAULT '',
+  "Slug" VARCHAR(64) NOT NULL DEFAULT '',
+  "Category" VARCHAR(64) NOT NULL DEFAULT '',
+  "Created" VARCHAR(64) NOT NULL DEFAULT '',
+  "Author" VARCHAR(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_article_revisions_article ON ArticleRevisions(ArticleID, uid);
+
+-- Existing articles start with their current version as the first revision.
+INSERT INTO ArticleRevisions(ArticleID, Title, Preview, Body, Format, Source, Slug, Category, Created)
+  SELECT uid, COALESCE(Title, ''), COALESCE(Preview, ''), COALESCE(Body, ''), Format, Source, COALESCE(Slug, ''), COALESCE(Category, ''), COALESCE(Edited, '')
+  FROM Articles;
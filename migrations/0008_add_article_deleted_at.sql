@@ -0,0 +1,4 @@
+-- Trashed articles keep their row until they're purged. NULL means the article isn't in the trash.
+ALTER TABLE Articles ADD COLUMN DeletedAt DATETIME;
+
+CREATE INDEX idx_articles_deleted_at ON Articles(DeletedAt);
@@ -0,0 +1,9 @@
+-- Slugs articles used to have, so old links can be redirected.
+-- Each points straight at the article, so a chain of renames never needs more than one redirect.
+CREATE TABLE SlugHistory (
+  "uid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Slug" VARCHAR(64) NOT NULL UNIQUE,
+  "ArticleID" INTEGER NOT NULL REFERENCES Articles(uid) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_slug_history_article ON SlugHistory(ArticleID);
@@ -0,0 +1,7 @@
+-- Full-text index of articles, keyed by Articles.uid. Needs SQLite built with FTS5, see the sqlite_fts5 build tag.
+-- The store writes plain text versions of articles here when they're saved, and fills in any missing on startup.
+CREATE VIRTUAL TABLE ArticleSearch USING fts5(Title, Preview, Body, tokenize = 'porter unicode61');
+
+CREATE TRIGGER article_search_delete AFTER DELETE ON Articles BEGIN
+  DELETE FROM ArticleSearch WHERE rowid = old.uid;
+END;
@@ -0,0 +1,7 @@
+-- Old production databases were seeded with a blank user, which nobody can log in as.
+DELETE FROM Users WHERE Username IS NULL OR Username = '' OR Password_Hash IS NULL OR Password_Hash = '';
+
+-- Keep the first of any duplicate usernames, they couldn't be told apart at login.
+DELETE FROM Users WHERE uid NOT IN (SELECT MIN(uid) FROM Users GROUP BY Username);
+
+CREATE UNIQUE INDEX idx_users_username ON Users(Username);
@@ -0,0 +1,13 @@
+-- Everyone who could log in before roles could do anything, so they stay admins.
+ALTER TABLE Users ADD COLUMN Role VARCHAR(16) NOT NULL DEFAULT 'author';
+UPDATE Users SET Role = 'admin';
+
+-- 0 means nobody, for articles whose author has been deleted.
+-- Existing articles go to whoever last saved them, or the first admin.
+ALTER TABLE Articles ADD COLUMN AuthorID INTEGER NOT NULL DEFAULT 0;
+UPDATE Articles SET AuthorID = COALESCE(
+	(SELECT uid FROM Users WHERE Username = Articles.EditedBy),
+	(SELECT MIN(uid) FROM Users WHERE Role = 'admin'),
+	0);
+
+CREATE INDEX idx_articles_author ON Articles(AuthorID);
@@ -0,0 +1,4 @@
+-- Shown on author pages and in bylines. An empty DisplayName falls back to the username.
+ALTER TABLE Users ADD COLUMN DisplayName VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE Users ADD COLUMN Bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE Users ADD COLUMN Avatar VARCHAR(255) NOT NULL DEFAULT '';
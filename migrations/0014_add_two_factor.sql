@@ -0,0 +1,13 @@
+-- An empty TOTPSecret means the user hasn't turned on two-factor authentication.
+-- TOTPLastStep is the time step of the last code used, so a code can't be used twice.
+ALTER TABLE Users ADD COLUMN TOTPSecret VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE Users ADD COLUMN TOTPLastStep INTEGER NOT NULL DEFAULT 0;
+
+-- SHA-256 hashes of the unused recovery codes, each good for one login without the app.
+CREATE TABLE RecoveryCodes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	UserID INTEGER NOT NULL,
+	Hash VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX idx_recovery_codes_user ON RecoveryCodes(UserID);
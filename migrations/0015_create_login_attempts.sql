@@ -0,0 +1,6 @@
+-- Failed logins for the SQLite login limiter. Key is "ip:" or "user:" then the address or username.
+CREATE TABLE LoginAttempts (
+	Key VARCHAR(255) PRIMARY KEY,
+	Failures INTEGER NOT NULL DEFAULT 0,
+	LastFailure VARCHAR(20) NOT NULL
+);
@@ -0,0 +1,16 @@
+-- Sessions for the SQLite session store. The cookie holds a random ID and ID is its SHA-256
+-- hash, so the table alone can't be used to take over a session. Data is the gob encoded Sesh.
+CREATE TABLE Sessions (
+	ID VARCHAR(64) PRIMARY KEY,
+	Username VARCHAR(255) NOT NULL DEFAULT '',
+	Authenticated INTEGER NOT NULL DEFAULT 0,
+	IP VARCHAR(64) NOT NULL DEFAULT '',
+	UserAgent TEXT NOT NULL DEFAULT '',
+	Data BLOB NOT NULL,
+	Created VARCHAR(20) NOT NULL,
+	LastSeen VARCHAR(20) NOT NULL,
+	Expires VARCHAR(20) NOT NULL
+);
+
+CREATE INDEX idx_sessions_username ON Sessions(Username);
+CREATE INDEX idx_sessions_expires ON Sessions(Expires);
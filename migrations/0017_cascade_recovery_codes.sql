@@ -0,0 +1,15 @@
+-- 0014 left RecoveryCodes without a foreign key, so deleting a user kept their codes.
+-- SQLite can't add one to an existing table, so it's rebuilt, dropping codes of deleted users.
+CREATE TABLE RecoveryCodesNew (
+  "uid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "UserID" INTEGER NOT NULL REFERENCES Users(uid) ON DELETE CASCADE,
+  "Hash" VARCHAR(64) NOT NULL
+);
+
+INSERT INTO RecoveryCodesNew (UserID, Hash)
+  SELECT UserID, Hash FROM RecoveryCodes WHERE UserID IN (SELECT uid FROM Users);
+
+DROP TABLE RecoveryCodes;
+ALTER TABLE RecoveryCodesNew RENAME TO RecoveryCodes;
+
+CREATE INDEX idx_recovery_codes_user ON RecoveryCodes(UserID);
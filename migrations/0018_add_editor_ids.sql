@@ -0,0 +1,7 @@
+-- Editors were recorded by username, which renaming a user would orphan. Like AuthorID,
+-- 0 means nobody. The old EditedBy and Author columns are no longer written.
+ALTER TABLE Articles ADD COLUMN EditedByID INTEGER NOT NULL DEFAULT 0;
+UPDATE Articles SET EditedByID = COALESCE((SELECT uid FROM Users WHERE Username = Articles.EditedBy), 0);
+
+ALTER TABLE ArticleRevisions ADD COLUMN AuthorID INTEGER NOT NULL DEFAULT 0;
+UPDATE ArticleRevisions SET AuthorID = COALESCE((SELECT uid FROM Users WHERE Username = ArticleRevisions.Author), 0);
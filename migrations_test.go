@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// Schema written by NewFileSystemStore before migrations were added.
+const v0Schema = `CREATE TABLE Articles (
+    "uid" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "Title" VARCHAR(64) NULL,
+    "Preview" TEXT NULL,
+    "Body" TEXT NULL,
+    "Slug" VARCHAR(64) NULL,
+    "Published" VARCHAR(64) NULL,
+    "Edited" VARCHAR(64) NULL,
+    "Category" VARCHAR(64) NULL
+  );
+CREATE TABLE Users (
+		"uid" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"Username" VARCHAR(64) NULL,
+		"Email" VARCHAR(64) NULL,
+		"Password_Hash" VARCHAR(255) NULL
+	);`
+
+func makeV0Database(t *testing.T, path string, articles []Article) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	assertNoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(v0Schema)
+	assertNoError(t, err)
+	for _, a := range articles {
+		_, err = db.Exec("INSERT INTO Articles(Title, Preview, Body, Slug, Published, Edited, Category) values(?, ?, ?, ?, DATETIME(?), ?, ?)",
+			a.Title, a.Preview, a.Body, a.Slug, a.Published, a.Edited, a.Category)
+		assertNoError(t, err)
+	}
+}
+
+// Leaves the database at path as a binary that only knew up to version would have.
+func makeDatabaseAtVersion(t *testing.T, path string, version int) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on")
+	assertNoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE schema_migrations (
+		"version" INTEGER PRIMARY KEY,
+		"name" VARCHAR(255) NOT NULL,
+		"applied_at" VARCHAR(64) NOT NULL
+	);`)
+	assertNoError(t, err)
+	migrations, err := loadMigrations()
+	assertNoError(t, err)
+	f := &FileSystemStore{db: db}
+	for _, m := range migrations[:version] {
+		assertNoError(t, f.applyMigration(m))
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	assertNoError(t, err)
+	latest := len(migrations)
+
+	t.Run("new database is migrated to the latest version", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		defer closeDB()
+
+		version, err := store.schemaVersion()
+		assertNoError(t, err)
+		assertInt(t, version, latest)
+	})
+
+	t.Run("upgrades a v0 database and keeps its articles", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		articles := MakeArticlesOfCategory(5, time.Now().UTC(), progCat)
+		makeV0Database(t, tmpFile.Name(), articles)
+
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		defer closeDB()
+
+		version, err := store.schemaVersion()
+		assertNoError(t, err)
+		assertInt(t, version, latest)
+
+		got, err := store.getAll()
+		assertNoError(t, err)
+		assertArticles(t, got, withoutBodies(reverseArticles(articles)))
+
+		// Articles from before Markdown support are HTML.
+		_, old, err := store.getArticle(articles[0].Slug)
+		assertNoError(t, err)
+		assertArticle(t, old, articles[0])
+	})
+
+	t.Run("users from before roles are admins and own the old articles", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		articles := MakeArticlesOfCategory(1, time.Now().UTC(), progCat)
+		makeV0Database(t, tmpFile.Name(), articles)
+		db, err := sql.Open("sqlite3", tmpFile.Name())
+		assertNoError(t, err)
+		_, err = db.Exec("INSERT INTO Users(Username, Email, Password_Hash) values(?, ?, ?)", admin.Username, admin.Email, admin.Password_Hash)
+		assertNoError(t, err)
+		db.Close()
+
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		defer closeDB()
+
+		u, err := store.getUser(admin.Username)
+		assertNoError(t, err)
+		if u.Role != roleAdmin {
+			t.Errorf("got role %q, want %s", u.Role, roleAdmin)
+		}
+		_, got, err := store.getArticle(articles[0].Slug)
+		assertNoError(t, err)
+		assertInt(t, got.AuthorID, u.Id)
+	})
+
+	t.Run("recovery codes of deleted users are dropped", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		makeDatabaseAtVersion(t, tmpFile.Name(), 16)
+		db, err := sql.Open("sqlite3", tmpFile.Name())
+		assertNoError(t, err)
+		_, err = db.Exec("INSERT INTO Users(uid, Username, Email, Password_Hash) values(1, ?, ?, ?)", admin.Username, admin.Email, admin.Password_Hash)
+		assertNoError(t, err)
+		_, err = db.Exec("INSERT INTO RecoveryCodes(UserID, Hash) values(1, 'kept'), (2, 'orphaned')")
+		assertNoError(t, err)
+		db.Close()
+
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, nil)
+		defer closeDB()
+
+		for id, want := range map[int]int{1: 1, 2: 0} {
+			n, err := store.countRecoveryCodes(id)
+			assertNoError(t, err)
+			assertInt(t, n, want)
+		}
+		assertNoError(t, store.deleteUser(admin.Username))
+		n, err := store.countRecoveryCodes(1)
+		assertNoError(t, err)
+		assertInt(t, n, 0)
+	})
+
+	t.Run("editors recorded by username get their user id", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		makeDatabaseAtVersion(t, tmpFile.Name(), 17)
+		db, err := sql.Open("sqlite3", tmpFile.Name())
+		assertNoError(t, err)
+		_, err = db.Exec("INSERT INTO Users(uid, Username, Email, Password_Hash) values(1, ?, ?, ?)", admin.Username, admin.Email, admin.Password_Hash)
+		assertNoError(t, err)
+		_, err = db.Exec("INSERT INTO Articles(uid, Title, Preview, Body, Slug, Published, Edited, Category, EditedBy) values(1, 'Title', '', '', 'edited', DATETIME('now'), '', '', ?)", admin.Username)
+		assertNoError(t, err)
+		_, err = db.Exec("INSERT INTO ArticleRevisions(ArticleID, Author) values(1, ?), (1, 'deleted-user')", admin.Username)
+		assertNoError(t, err)
+		db.Close()
+
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, nil)
+		defer closeDB()
+
+		_, a, err := store.getArticle("edited")
+		assertNoError(t, err)
+		if a.EditedByID != 1 || a.EditedBy != admin.Username {
+			t.Errorf("got edited by %d %q, want 1 %q", a.EditedByID, a.EditedBy, admin.Username)
+		}
+		revs, err := store.getRevisions(1)
+		assertNoError(t, err)
+		assertInt(t, len(revs), 2)
+		if revs[0].AuthorID != 0 || revs[1].AuthorID != 1 || revs[1].Author != admin.Username {
+			t.Errorf("got revisions by %d and %d %q", revs[0].AuthorID, revs[1].AuthorID, revs[1].Author)
+		}
+	})
+
+	t.Run("reopening a migrated database applies nothing twice", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		_, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		closeDB()
+
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		defer closeDB()
+
+		var applied int
+		err := store.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied)
+		assertNoError(t, err)
+		assertInt(t, applied, latest)
+	})
+
+	t.Run("refuses to open a database newer than the binary", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		_, err := store.db.Exec("INSERT INTO schema_migrations(version, name, applied_at) values(?, ?, ?)", latest+1, "from_the_future.sql", myTimeToString(time.Now().UTC()))
+		assertNoError(t, err)
+		closeDB()
+
+		_, _, err = NewFileSystemStore(tmpFile, []Article{}, []User{})
+		if err == nil {
+			t.Error("expected an error opening a database from a newer binary")
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+// What a notification is about.
+type EventKind string
+
+const (
+	eventLoginSucceeded   EventKind = "login_succeeded"
+	eventLoginFailed      EventKind = "login_failed"
+	eventArticlePublished EventKind = "article_published"
+	// For whatever backs up the database, sent with the notify command.
+	eventBackupFinished EventKind = "backup_finished"
+	// Stands in for the events a Batcher had no room for.
+	eventsDropped EventKind = "events_dropped"
+)
+
+// The events jobs outside the blog can send with the notify command.
+var commandEvents = map[EventKind]bool{
+	eventBackupFinished: true,
+}
+
+// Events past this many in a batch are only counted.
+const notifyBatchMax = 50
+
+var eventSubjects = map[EventKind]string{
+	eventLoginSucceeded:   "Blog Successful Login",
+	eventLoginFailed:      "Blog Failed Login Attempt",
+	eventArticlePublished: "Blog Article Published",
+	eventBackupFinished:   "Blog Backup Finished",
+	eventsDropped:         "Blog Notifications Dropped",
+}
+
+// Something to tell the admin about. Events go to email, webhooks and log files, so they
+// never hold passwords, codes, tokens or anything else secret.
+type Event struct {
+	Kind EventKind `json:"kind"`
+	Time time.Time `json:"time"`
+	// Who logged in or tried to, from where.
+	Username string `json:"username,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	// The article, for article events.
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url,omitempty"`
+	// Anything else worth knowing, like how many scheduled articles were published.
+	Detail string `json:"detail,omitempty"`
+}
+
+// One line, for email bodies.
+func (e Event) String() string {
+	parts := []string{e.Time.UTC().Format("2006-01-02 15:04:05"), string(e.Kind)}
+	for _, f := range []struct{ name, value string }{
+		{"username", e.Username}, {"ip", e.IP}, {"title", e.Title}, {"url", e.URL}, {"detail", e.Detail},
+	} {
+		if f.value != "" {
+			parts = append(parts, fmt.Sprintf("%s=%q", f.name, f.value))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Sends events somewhere. Each call's events go together, as one email or request.
+type Notifier interface {
+	Notify(events []Event) error
+}
+
+// Used when nothing is configured, and in tests.
+type discardNotifier struct{}
+
+func (discardNotifier) Notify([]Event) error { return nil }
+
+// Sends every event to each notifier.
+type multiNotifier []Notifier
+
+func (m multiNotifier) Notify(events []Event) error {
+	var errs []error
+	for _, n := range m {
+		errs = append(errs, n.Notify(events))
+	}
+	return errors.Join(errs...)
+}
+
+// Emails the events, one email per call.
+type SMTPNotifier struct {
+	// host:port of the SMTP server.
+	Addr string
+	// Nil for servers that don't need logging in to.
+	Auth smtp.Auth
+	From string
+	To   []string
+}
+
+func (n *SMTPNotifier) Notify(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	e := email.NewEmail()
+	e.From = "Blog Server <" + n.From + ">"
+	e.To = n.To
+	e.Subject = eventSubjects[events[0].Kind]
+	if len(events) > 1 {
+		e.Subject = fmt.Sprintf("%d Blog Notifications", len(events))
+	}
+	var body strings.Builder
+	for _, ev := range events {
+		body.WriteString(ev.String() + "\n")
+	}
+	e.Text = []byte(body.String())
+	return e.Send(n.Addr, n.Auth)
+}
+
+// POSTs the events as JSON, {"events": [...]}, to URL.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n *WebhookNotifier) Notify(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(struct {
+		Events []Event `json:"events"`
+	}{events})
+	if err != nil {
+		return err
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded %s", n.URL, resp.Status)
+	}
+	return nil
+}
+
+// Writes each event as a line of JSON.
+type LogNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLogNotifier(w io.Writer) *LogNotifier {
+	return &LogNotifier{w: w}
+}
+
+func (n *LogNotifier) Notify(events []Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	enc := json.NewEncoder(n.w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Holds events and passes them on together once window has passed since the first,
+// so a run of failed logins is one email rather than hundreds. Past max events in a
+// window, the rest are only counted.
+type Batcher struct {
+	next   Notifier
+	window time.Duration
+	max    int
+
+	mu      sync.Mutex
+	pending []Event
+	dropped int
+	timer   *time.Timer
+}
+
+func NewBatcher(next Notifier, window time.Duration, max int) *Batcher {
+	return &Batcher{next: next, window: window, max: max}
+}
+
+// Only queues the events, so it never waits on the notifier behind it.
+func (b *Batcher) Notify(events []Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range events {
+		if len(b.pending) < b.max {
+			b.pending = append(b.pending, e)
+		} else {
+			b.dropped++
+		}
+	}
+	if b.timer == nil && len(events) != 0 {
+		b.timer = time.AfterFunc(b.window, func() {
+			if err := b.Flush(); err != nil {
+				log.Print(err)
+			}
+		})
+	}
+	return nil
+}
+
+// Sends whatever is waiting now. Call before exiting so nothing is lost.
+func (b *Batcher) Flush() error {
+	b.mu.Lock()
+	events, dropped := b.pending, b.dropped
+	b.pending, b.dropped = nil, 0
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if dropped > 0 {
+		events = append(events, Event{Kind: eventsDropped, Time: time.Now().UTC(), Detail: fmt.Sprintf("%d more events in this batch were not sent", dropped)})
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return b.next.Notify(events)
+}
+
+func (s *Server) notify(events ...Event) {
+	if err := s.notifier.Notify(events); err != nil {
+		log.Print(err)
+	}
+}
+
+// Only the username and where it came from. Never anything else from the form.
+func (s *Server) loginEvent(kind EventKind, r *http.Request, username string) Event {
+	return Event{Kind: kind, Time: s.clock.Now().UTC(), Username: username, IP: clientIP(r)}
+}
+
+func (s *Server) publishedEvent(a Article) Event {
+	return Event{Kind: eventArticlePublished, Time: s.clock.Now().UTC(), Username: a.EditedBy, Title: a.Title, URL: siteURL + "/" + a.Slug}
+}
+
+// Sends the event named by args[0] to n. The rest of args is the detail.
+func notifyCommand(args []string, n Notifier) error {
+	if len(args) == 0 || !commandEvents[EventKind(args[0])] {
+		return errors.New("usage: notify backup_finished [detail]")
+	}
+	return n.Notify([]Event{{Kind: EventKind(args[0]), Time: time.Now().UTC(), Detail: strings.Join(args[1:], " ")}})
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Just enough of an SMTP server to take emails from net/smtp, with AUTH PLAIN and no TLS.
+type fakeSMTPServer struct {
+	addr string
+
+	mu       sync.Mutex
+	messages []string
+	// Decoded AUTH PLAIN responses.
+	auths []string
+}
+
+func startFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assertNoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	s := &fakeSMTPServer{addr: l.Addr().String()}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost fake SMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			c.PrintfLine("250-localhost")
+			c.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_, initial, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(initial)
+			s.mu.Lock()
+			s.auths = append(s.auths, string(decoded))
+			s.mu.Unlock()
+			c.PrintfLine("235 2.7.0 Authentication successful")
+		case "DATA":
+			c.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			lines, err := c.ReadDotLines()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, strings.Join(lines, "\n"))
+			s.mu.Unlock()
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 Bye")
+			return
+		default:
+			c.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) got() (messages, auths []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...), append([]string(nil), s.auths...)
+}
+
+func makeEvents(n int) []Event {
+	var events []Event
+	for i := 0; i < n; i++ {
+		events = append(events, Event{Kind: eventLoginFailed, Time: newFakeClock().Now(), Username: "admin", IP: "192.0.2.1"})
+	}
+	return events
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	server := startFakeSMTPServer(t)
+	host, _, _ := net.SplitHostPort(server.addr)
+	n := &SMTPNotifier{
+		Addr: server.addr,
+		Auth: smtp.PlainAuth("", "blog@example.com", "smtp-pass", host),
+		From: "blog@example.com",
+		To:   []string{"admin@example.com"},
+	}
+
+	assertNoError(t, n.Notify(makeEvents(1)))
+	assertNoError(t, n.Notify(makeEvents(3)))
+
+	messages, auths := server.got()
+	assertInt(t, len(messages), 2)
+	assertContains(t, messages[0], "Subject: Blog Failed Login Attempt")
+	assertContains(t, messages[0], "login_failed username=3D\"admin\"") // quoted-printable
+	assertContains(t, messages[1], "Subject: 3 Blog Notifications")
+	assertInt(t, strings.Count(messages[1], "login_failed"), 3)
+	if len(auths) != 2 || auths[0] != "\x00blog@example.com\x00smtp-pass" {
+		t.Errorf("got auths %q", auths)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got struct {
+		Events []Event `json:"events"`
+	}
+	status := http.StatusOK
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertContains(t, r.Header.Get("Content-Type"), "application/json")
+		assertNoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(status)
+	}))
+	defer hook.Close()
+	n := &WebhookNotifier{URL: hook.URL}
+
+	assertNoError(t, n.Notify(makeEvents(2)))
+	assertInt(t, len(got.Events), 2)
+	if got.Events[0].Kind != eventLoginFailed || got.Events[0].Username != "admin" {
+		t.Errorf("got %+v", got.Events[0])
+	}
+
+	status = http.StatusInternalServerError
+	if err := n.Notify(makeEvents(1)); err == nil {
+		t.Error("want an error when the webhook fails")
+	}
+}
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	n := NewLogNotifier(&buf)
+	assertNoError(t, n.Notify(makeEvents(2)))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assertInt(t, len(lines), 2)
+	var e Event
+	assertNoError(t, json.Unmarshal([]byte(lines[1]), &e))
+	if e.Kind != eventLoginFailed || e.IP != "192.0.2.1" {
+		t.Errorf("got %+v", e)
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	t.Run("waits to send events together", func(t *testing.T) {
+		next := &recordingNotifier{}
+		b := NewBatcher(next, time.Hour, 10)
+		assertNoError(t, b.Notify(makeEvents(1)))
+		assertNoError(t, b.Notify(makeEvents(2)))
+		assertInt(t, len(next.got()), 0)
+
+		assertNoError(t, b.Flush())
+		assertInt(t, len(next.got()), 3)
+		assertNoError(t, b.Flush())
+		assertInt(t, len(next.got()), 3)
+	})
+
+	t.Run("counts events past the limit", func(t *testing.T) {
+		next := &recordingNotifier{}
+		b := NewBatcher(next, time.Hour, 2)
+		assertNoError(t, b.Notify(makeEvents(5)))
+		assertNoError(t, b.Flush())
+
+		got := next.got()
+		assertInt(t, len(got), 3)
+		if got[2].Kind != eventsDropped {
+			t.Fatalf("got %s, want %s last", got[2].Kind, eventsDropped)
+		}
+		assertContains(t, got[2].Detail, "3 more events")
+	})
+
+	t.Run("sends once the window has passed", func(t *testing.T) {
+		next := &recordingNotifier{}
+		b := NewBatcher(next, 10*time.Millisecond, 10)
+		assertNoError(t, b.Notify(makeEvents(2)))
+
+		deadline := time.Now().Add(2 * time.Second)
+		for len(next.got()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		assertInt(t, len(next.got()), 2)
+	})
+}
+
+func TestNotifyCommand(t *testing.T) {
+	t.Run("sends the event with the detail", func(t *testing.T) {
+		next := &recordingNotifier{}
+		assertNoError(t, notifyCommand([]string{"backup_finished", "blog.db", "copied"}, next))
+
+		got := next.got()
+		assertInt(t, len(got), 1)
+		if got[0].Kind != eventBackupFinished || got[0].Detail != "blog.db copied" {
+			t.Errorf("got %+v", got[0])
+		}
+	})
+
+	t.Run("only events meant for outside jobs", func(t *testing.T) {
+		for _, args := range [][]string{nil, {"login_succeeded"}, {"nonsense"}} {
+			next := &recordingNotifier{}
+			if err := notifyCommand(args, next); err == nil {
+				t.Errorf("%v: want an error", args)
+			}
+			assertInt(t, len(next.got()), 0)
+		}
+	})
+
+	t.Run("goes through the notifiers from the environment", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "notify.log")
+		t.Setenv("blog_email", "admin@example.com")
+		t.Setenv("blog_smtp_addr", "")
+		t.Setenv("blog_webhook_url", "")
+		t.Setenv("blog_notify_window", "")
+		t.Setenv("blog_notify_log", path)
+		assertNoError(t, notifyFromCommandLine([]string{"backup_finished"}))
+
+		data, err := os.ReadFile(path)
+		assertNoError(t, err)
+		var e Event
+		assertNoError(t, json.Unmarshal(data, &e))
+		if e.Kind != eventBackupFinished {
+			t.Errorf("got %+v", e)
+		}
+	})
+}
+
+func TestServerNotifications(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{admin})
+	defer closeDB()
+	sessStore := StubSessionStore{}
+	server := NewServer(store, &sessStore)
+	notifier := &recordingNotifier{}
+	smtpServer := startFakeSMTPServer(t)
+	server.notifier = multiNotifier{notifier, &SMTPNotifier{Addr: smtpServer.addr, From: "blog@example.com", To: []string{"admin@example.com"}}}
+
+	const attempted = "not-the-password-7d1f"
+	login := func(t *testing.T, password string) {
+		t.Helper()
+		req := newPostRequest(t, "/admin/login", userData("admin", password))
+		req.RemoteAddr = "192.0.2.1:1234"
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	t.Run("logins", func(t *testing.T) {
+		login(t, attempted)
+		login(t, "password")
+
+		events := notifier.got()
+		assertInt(t, len(events), 2)
+		if events[0].Kind != eventLoginFailed || events[1].Kind != eventLoginSucceeded {
+			t.Errorf("got %s then %s", events[0].Kind, events[1].Kind)
+		}
+		for _, e := range events {
+			if e.Username != "admin" || e.IP != "192.0.2.1" {
+				t.Errorf("got %+v, want the username and IP", e)
+			}
+		}
+	})
+
+	t.Run("attempted passwords are never sent", func(t *testing.T) {
+		payload, err := json.Marshal(notifier.got())
+		assertNoError(t, err)
+		assertNotContain(t, string(payload), attempted)
+
+		messages, _ := smtpServer.got()
+		assertInt(t, len(messages), 2)
+		for _, m := range messages {
+			assertNotContain(t, m, attempted)
+			assertNotContain(t, m, "password")
+		}
+	})
+
+	t.Run("publishing", func(t *testing.T) {
+		sessStore.sesh = Sesh{Name: admin.Username, Authenticated: true}
+		draft := newValidArticleWithTime()
+		draft.Status = statusDraft
+		server.ServeHTTP(httptest.NewRecorder(), newPostRequest(t, "/new", setDataValues(draft)))
+		assertInt(t, len(notifier.got()), 2)
+
+		draft.Status = statusPublished
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/"+draft.Slug+"/edit", setDataValues(draft)))
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+
+		events := notifier.got()
+		assertInt(t, len(events), 3)
+		if events[2].Kind != eventArticlePublished || events[2].Title != draft.Title {
+			t.Errorf("got %+v, want %s published", events[2], draft.Title)
+		}
+		assertContains(t, events[2].URL, "/"+draft.Slug)
+	})
+}
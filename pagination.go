@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultPerPage = 10
+const maxPerPage = 50
+
+// How many page links to show either side of the current page.
+const pageWindow = 2
+
+// Pagination describes one page of a listing of Total articles.
+type Pagination struct {
+	Page    int
+	PerPage int
+	Total   int
+}
+
+// The last page is allowed to be partly full. An empty listing still has one (empty) page.
+func (p Pagination) MaxPage() int {
+	if p.Total == 0 {
+		return 1
+	}
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+func (p Pagination) InRange() bool {
+	return p.Page >= 1 && p.Page <= p.MaxPage()
+}
+
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+func (p Pagination) HasPrev() bool {
+	return p.Page > 1
+}
+
+func (p Pagination) HasNext() bool {
+	return p.Page < p.MaxPage()
+}
+
+// Returns the page numbers to link to, with 0 standing in for a gap.
+// The first and last pages are always included, plus pageWindow pages either side of the current one.
+func (p Pagination) Window() []int {
+	var ret []int
+	maxPage := p.MaxPage()
+	for i := 1; i <= maxPage; i++ {
+		if i == 1 || i == maxPage || (i >= p.Page-pageWindow && i <= p.Page+pageWindow) {
+			if len(ret) > 0 && ret[len(ret)-1] != 0 && ret[len(ret)-1] != i-1 {
+				ret = append(ret, 0)
+			}
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// Returns false if the page in the url is not a number.
+func getPageNumber(r *http.Request) (int, bool) {
+	vars := mux.Vars(r)
+	page, ok := vars["page"]
+	if !ok {
+		return 1, true
+	}
+	pageInt, err := strconv.Atoi(page)
+	if err != nil {
+		return 0, false
+	}
+	return pageInt, true
+}
+
+// Reads ?per_page=, falling back to the default when missing or invalid and capping it at maxPerPage.
+func getPerPage(r *http.Request) int {
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		return defaultPerPage
+	}
+	if perPage > maxPerPage {
+		return maxPerPage
+	}
+	return perPage
+}
+
+// Builds the url of a page of a listing, keeping a non-default page size.
+func pageURL(basePath string, page, perPage int) string {
+	url := strings.TrimSuffix(basePath, "/") + "/page/" + strconv.Itoa(page)
+	if perPage != defaultPerPage {
+		url += "?per_page=" + strconv.Itoa(perPage)
+	}
+	return url
+}
+
+// Sets rel="prev" and rel="next" Link headers for the pages either side of the current one.
+func setPaginationLinks(w http.ResponseWriter, p Pagination, basePath string) {
+	var links []string
+	if p.HasPrev() {
+		links = append(links, "<"+pageURL(basePath, p.Page-1, p.PerPage)+">; rel=\"prev\"")
+	}
+	if p.HasNext() {
+		links = append(links, "<"+pageURL(basePath, p.Page+1, p.PerPage)+">; rel=\"next\"")
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
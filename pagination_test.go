@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPagination(t *testing.T) {
+	t.Run("max page counts partly full pages", func(t *testing.T) {
+		cases := []struct {
+			total   int
+			perPage int
+			want    int
+		}{
+			{0, 10, 1},
+			{1, 10, 1},
+			{10, 10, 1},
+			{11, 10, 2},
+			{25, 10, 3},
+			{50, 10, 5},
+			{25, 5, 5},
+		}
+
+		for _, c := range cases {
+			p := Pagination{Page: 1, PerPage: c.perPage, Total: c.total}
+			if got := p.MaxPage(); got != c.want {
+				t.Errorf("%d articles at %d per page, got max page %d, want %d", c.total, c.perPage, got, c.want)
+			}
+		}
+	})
+
+	t.Run("pages outside the listing are out of range", func(t *testing.T) {
+		cases := []struct {
+			page int
+			want bool
+		}{
+			{-1, false},
+			{0, false},
+			{1, true},
+			{3, true},
+			{4, false},
+		}
+
+		for _, c := range cases {
+			p := Pagination{Page: c.page, PerPage: 10, Total: 25}
+			if got := p.InRange(); got != c.want {
+				t.Errorf("page %d, got in range %v, want %v", c.page, got, c.want)
+			}
+		}
+	})
+
+	t.Run("window shows first, last and pages around the current one", func(t *testing.T) {
+		cases := []struct {
+			page int
+			want []int
+		}{
+			{1, []int{1, 2, 3, 0, 20}},
+			{4, []int{1, 2, 3, 4, 5, 6, 0, 20}},
+			{10, []int{1, 0, 8, 9, 10, 11, 12, 0, 20}},
+			{20, []int{1, 0, 18, 19, 20}},
+		}
+
+		for _, c := range cases {
+			p := Pagination{Page: c.page, PerPage: 10, Total: 200}
+			if got := p.Window(); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("page %d, got window %v, want %v", c.page, got, c.want)
+			}
+		}
+	})
+
+	t.Run("page urls", func(t *testing.T) {
+		if got := pageURL("/", 2, defaultPerPage); got != "/page/2" {
+			t.Errorf("got %s, want /page/2", got)
+		}
+		if got := pageURL("/other", 2, 5); got != "/other/page/2?per_page=5" {
+			t.Errorf("got %s, want /other/page/2?per_page=5", got)
+		}
+	})
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many wrong logins are allowed, counted per IP and per username.
+const (
+	// Failures allowed before having to wait between attempts.
+	freeLoginAttempts = 3
+	// The wait after the first failure past the free ones, doubling with each one after.
+	loginBackoff = time.Second
+	// After this many failures, the wait is lockoutDuration.
+	lockoutAttempts = 10
+	lockoutDuration = 15 * time.Minute
+	// Failures are forgotten once there have been none for this long.
+	failureMemory = time.Hour
+)
+
+const errTooManyAttempts = "Too many failed attempts, try again in %s"
+
+// Keeps count of failed logins. MemoryLimiter is used unless blog_login_limiter is sqlite.
+type Limiter interface {
+	get(key string) (loginAttempts, error)
+	// Counts a failure for key, starting again from one if the last was longer than failureMemory ago.
+	fail(key string, now time.Time) (loginAttempts, error)
+	reset(key string) error
+	// The keys with failures that haven't been forgotten, by key.
+	list(now time.Time) ([]loginAttempts, error)
+}
+
+type loginAttempts struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+}
+
+func (a loginAttempts) forgotten(now time.Time) bool {
+	return now.Sub(a.LastFailure) >= failureMemory
+}
+
+// How long until key can try again, zero if it can now.
+func (a loginAttempts) retryAfter(now time.Time) time.Duration {
+	if a.Failures <= freeLoginAttempts || a.forgotten(now) {
+		return 0
+	}
+	wait := lockoutDuration
+	if a.Failures < lockoutAttempts {
+		wait = loginBackoff << (a.Failures - freeLoginAttempts - 1)
+	}
+	if left := a.LastFailure.Add(wait).Sub(now); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// The limiter keys for logging in as username from r.
+func loginKeys(r *http.Request, username string) []string {
+	keys := []string{"ip:" + clientIP(r)}
+	if username != "" {
+		keys = append(keys, "user:"+username)
+	}
+	return keys
+}
+
+// The address of the reverse proxy in front of the blog, from blog_trusted_proxy.
+// Empty if there isn't one, so X-Forwarded-For is ignored.
+var trustedProxy = ""
+
+// The address the request came from, without the port. When it came through
+// trustedProxy that's the last X-Forwarded-For address, the one the proxy added,
+// as the ones before it can be made up. Anyone else could make up all of them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if trustedProxy == "" || host != trustedProxy {
+		return host
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		addrs := strings.Split(forwarded, ",")
+		return strings.TrimSpace(addrs[len(addrs)-1])
+	}
+	return host
+}
+
+// The longest any of keys has to wait.
+func (s *Server) loginWait(keys []string) (time.Duration, error) {
+	var longest time.Duration
+	for _, k := range keys {
+		a, err := s.limiter.get(k)
+		if err != nil {
+			return 0, err
+		}
+		if wait := a.retryAfter(s.clock.Now()); wait > longest {
+			longest = wait
+		}
+	}
+	return longest, nil
+}
+
+func (s *Server) loginFailed(keys []string) error {
+	for _, k := range keys {
+		if _, err := s.limiter.fail(k, s.clock.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sets the Retry-After header and returns the error to show on the form, which should be sent with a 429.
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) string {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return fmt.Sprintf(errTooManyAttempts, time.Duration(seconds)*time.Second)
+}
+
+type MemoryLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]loginAttempts
+	// When forgotten keys were last dropped.
+	pruned time.Time
+}
+
+// How often MemoryLimiter drops forgotten keys.
+const limiterPruneInterval = time.Minute
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{attempts: map[string]loginAttempts{}}
+}
+
+func (m *MemoryLimiter) get(key string) (loginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.attempts[key], nil
+}
+
+func (m *MemoryLimiter) fail(key string, now time.Time) (loginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Forgotten keys are dropped, so guessing at many usernames doesn't fill up memory.
+	// Only every so often, so each failure isn't a scan of every key.
+	if now.Sub(m.pruned) >= limiterPruneInterval {
+		for k, a := range m.attempts {
+			if a.forgotten(now) {
+				delete(m.attempts, k)
+			}
+		}
+		m.pruned = now
+	}
+	a := m.attempts[key]
+	if a.forgotten(now) {
+		a = loginAttempts{}
+	}
+	a.Key = key
+	a.Failures++
+	a.LastFailure = now
+	m.attempts[key] = a
+	return a, nil
+}
+
+func (m *MemoryLimiter) reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *MemoryLimiter) list(now time.Time) ([]loginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ret []loginAttempts
+	for _, a := range m.attempts {
+		if !a.forgotten(now) {
+			ret = append(ret, a)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key < ret[j].Key })
+	return ret, nil
+}
+
+// Keeps the counts in the LoginAttempts table, so they last through restarts.
+type SQLiteLimiter struct {
+	db *sql.DB
+}
+
+func NewSQLiteLimiter(db *sql.DB) *SQLiteLimiter {
+	return &SQLiteLimiter{db: db}
+}
+
+func (l *SQLiteLimiter) get(key string) (loginAttempts, error) {
+	a := loginAttempts{Key: key}
+	var last string
+	err := l.db.QueryRow("SELECT Failures, LastFailure FROM LoginAttempts WHERE Key = ?", key).Scan(&a.Failures, &last)
+	if err == sql.ErrNoRows {
+		return loginAttempts{}, nil
+	}
+	if err != nil {
+		return loginAttempts{}, err
+	}
+	if a.LastFailure, err = myStringToTime(last); err != nil {
+		return loginAttempts{}, err
+	}
+	return a, nil
+}
+
+func (l *SQLiteLimiter) fail(key string, now time.Time) (loginAttempts, error) {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return loginAttempts{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM LoginAttempts WHERE LastFailure <= ?", myTimeToString(now.Add(-failureMemory).UTC()))
+	if err != nil {
+		return loginAttempts{}, err
+	}
+	_, err = tx.Exec(`INSERT INTO LoginAttempts (Key, Failures, LastFailure) VALUES (?, 1, ?)
+		ON CONFLICT(Key) DO UPDATE SET Failures = Failures + 1, LastFailure = excluded.LastFailure`,
+		key, myTimeToString(now.UTC()))
+	if err != nil {
+		return loginAttempts{}, err
+	}
+	a := loginAttempts{Key: key, LastFailure: now}
+	if err := tx.QueryRow("SELECT Failures FROM LoginAttempts WHERE Key = ?", key).Scan(&a.Failures); err != nil {
+		return loginAttempts{}, err
+	}
+	return a, tx.Commit()
+}
+
+func (l *SQLiteLimiter) reset(key string) error {
+	_, err := l.db.Exec("DELETE FROM LoginAttempts WHERE Key = ?", key)
+	return err
+}
+
+func (l *SQLiteLimiter) list(now time.Time) ([]loginAttempts, error) {
+	rows, err := l.db.Query("SELECT Key, Failures, LastFailure FROM LoginAttempts WHERE LastFailure > ? ORDER BY Key", myTimeToString(now.Add(-failureMemory).UTC()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []loginAttempts
+	for rows.Next() {
+		var a loginAttempts
+		var last string
+		if err := rows.Scan(&a.Key, &a.Failures, &last); err != nil {
+			return nil, err
+		}
+		var err error
+		if a.LastFailure, err = myStringToTime(last); err != nil {
+			return nil, err
+		}
+		ret = append(ret, a)
+	}
+	return ret, rows.Err()
+}
+
+// A row of the lockouts page.
+type lockout struct {
+	loginAttempts
+	RetryAfter time.Duration
+}
+
+// Lists IPs and usernames with recent failed logins, so they can be unlocked.
+func (s *Server) AdminLockouts(w http.ResponseWriter, r *http.Request) {
+	attempts, err := s.limiter.list(s.clock.Now())
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	var lockouts []lockout
+	for _, a := range attempts {
+		lockouts = append(lockouts, lockout{a, a.retryAfter(s.clock.Now()).Round(time.Second)})
+	}
+
+	v := s.viewer(w, r)
+	if DEV {
+		lockoutsTemplate = setLockoutsTemplate()
+	}
+	tmpl := lockoutsTemplate
+	tmpl.Execute(w, struct {
+		Lockouts  []lockout
+		FreeTries int
+		Viewer
+		Dev         bool
+		Description string
+	}{lockouts, freeLoginAttempts, v, DEV, defaultDescription})
+}
+
+// Forgets the failures of the key field.
+func (s *Server) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	if err := s.limiter.reset(r.FormValue("key")); err != nil {
+		serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/lockouts", http.StatusSeeOther)
+}
+
+func setLockoutsTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/lockouts.html"))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := newFakeClock().Now()
+	cases := []struct {
+		failures int
+		ago      time.Duration
+		want     time.Duration
+	}{
+		{0, 0, 0},
+		{freeLoginAttempts, 0, 0},
+		{freeLoginAttempts + 1, 0, loginBackoff},
+		{freeLoginAttempts + 2, 0, 2 * loginBackoff},
+		{freeLoginAttempts + 3, time.Second, 4*loginBackoff - time.Second},
+		{freeLoginAttempts + 3, time.Minute, 0},
+		{lockoutAttempts, 0, lockoutDuration},
+		{lockoutAttempts + 5, time.Minute, lockoutDuration - time.Minute},
+		{lockoutAttempts, failureMemory, 0},
+	}
+	for _, c := range cases {
+		a := loginAttempts{Failures: c.failures, LastFailure: now.Add(-c.ago)}
+		if got := a.retryAfter(now); got != c.want {
+			t.Errorf("%d failures %v ago got %v, want %v", c.failures, c.ago, got, c.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	defer func(proxy string) { trustedProxy = proxy }(trustedProxy)
+
+	cases := []struct {
+		name      string
+		proxy     string
+		addr      string
+		forwarded string
+		want      string
+	}{
+		{"no proxy", "", "192.0.2.1:1234", "", "192.0.2.1"},
+		{"forwarded header without a trusted proxy", "", "192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},
+		{"forwarded header from someone other than the proxy", "10.0.0.1", "192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},
+		{"through the proxy", "10.0.0.1", "10.0.0.1:1234", "203.0.113.9, 198.51.100.7", "198.51.100.7"},
+		{"through the proxy without the header", "10.0.0.1", "10.0.0.1:1234", "", "10.0.0.1"},
+		{"IPv6", "::1", "[::1]:1234", "198.51.100.7", "198.51.100.7"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			trustedProxy = c.proxy
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = c.addr
+			if c.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", c.forwarded)
+			}
+			if got := clientIP(req); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLimiters(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, nil)
+	defer closeDB()
+
+	limiters := map[string]Limiter{
+		"memory": NewMemoryLimiter(),
+		"sqlite": NewSQLiteLimiter(store.db),
+	}
+	for name, l := range limiters {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			fail := func(key string) loginAttempts {
+				t.Helper()
+				a, err := l.fail(key, clock.Now())
+				assertNoError(t, err)
+				return a
+			}
+
+			fail("user:admin")
+			assertInt(t, fail("user:admin").Failures, 2)
+			fail("ip:192.0.2.1")
+
+			a, err := l.get("user:admin")
+			assertNoError(t, err)
+			assertInt(t, a.Failures, 2)
+			if !a.LastFailure.Equal(clock.Now()) {
+				t.Errorf("got last failure %v, want %v", a.LastFailure, clock.Now())
+			}
+
+			list, err := l.list(clock.Now())
+			assertNoError(t, err)
+			assertInt(t, len(list), 2)
+			assertContains(t, list[0].Key, "ip:192.0.2.1")
+
+			assertNoError(t, l.reset("user:admin"))
+			a, err = l.get("user:admin")
+			assertNoError(t, err)
+			assertInt(t, a.Failures, 0)
+
+			clock.Advance(failureMemory)
+			list, err = l.list(clock.Now())
+			assertNoError(t, err)
+			assertInt(t, len(list), 0)
+			assertInt(t, fail("ip:192.0.2.1").Failures, 1)
+		})
+	}
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{admin})
+	defer closeDB()
+	clock := newFakeClock()
+	sessStore := StubSessionStore{}
+	server := NewServer(store, &sessStore)
+	server.clock = clock
+	server.limiter = NewSQLiteLimiter(store.db)
+
+	login := func(t *testing.T, username, password, ip string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := newPostRequest(t, "/admin/login", userData(username, password))
+		req.RemoteAddr = ip + ":1234"
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("backs off after the free attempts", func(t *testing.T) {
+		for i := 0; i <= freeLoginAttempts; i++ {
+			assertStatus(t, login(t, "admin", "wrong", "192.0.2.1").Code, http.StatusUnauthorized)
+		}
+		resp := login(t, "admin", "password", "192.0.2.1")
+		assertStatus(t, resp.Code, http.StatusTooManyRequests)
+		assertContains(t, resp.Header().Get("Retry-After"), "1")
+		assertContains(t, resp.Body.String(), "Too many failed attempts, try again in 1s")
+		assertLoggedInStatus(t, sessStore, false)
+
+		clock.Advance(loginBackoff)
+		assertStatus(t, login(t, "admin", "wrong", "192.0.2.1").Code, http.StatusUnauthorized)
+		resp = login(t, "admin", "wrong", "192.0.2.1")
+		assertStatus(t, resp.Code, http.StatusTooManyRequests)
+		assertContains(t, resp.Header().Get("Retry-After"), "2")
+	})
+
+	t.Run("usernames are limited from any IP", func(t *testing.T) {
+		assertStatus(t, login(t, "admin", "password", "198.51.100.7").Code, http.StatusTooManyRequests)
+		assertStatus(t, login(t, "someone", "wrong", "198.51.100.7").Code, http.StatusUnauthorized)
+	})
+
+	t.Run("IPs are limited for any username", func(t *testing.T) {
+		assertStatus(t, login(t, "someone-else", "wrong", "192.0.2.1").Code, http.StatusTooManyRequests)
+	})
+
+	t.Run("a made up X-Forwarded-For counts against the real address", func(t *testing.T) {
+		for i := 0; i <= freeLoginAttempts; i++ {
+			req := newPostRequest(t, "/admin/login", userData("spoofer"+strconv.Itoa(i), "wrong"))
+			req.RemoteAddr = "192.0.2.50:1234"
+			req.Header.Set("X-Forwarded-For", "10.0.0."+strconv.Itoa(i))
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assertStatus(t, resp.Code, http.StatusUnauthorized)
+		}
+		assertStatus(t, login(t, "spoofer", "wrong", "192.0.2.50").Code, http.StatusTooManyRequests)
+
+		a, err := server.limiter.get("ip:10.0.0.0")
+		assertNoError(t, err)
+		assertInt(t, a.Failures, 0)
+	})
+
+	t.Run("locked out", func(t *testing.T) {
+		for i := 0; i < lockoutAttempts; i++ {
+			clock.Advance(time.Minute)
+			assertStatus(t, login(t, "nobody", "wrong", "203.0.113.9").Code, http.StatusUnauthorized)
+		}
+		resp := login(t, "nobody", "wrong", "203.0.113.9")
+		assertStatus(t, resp.Code, http.StatusTooManyRequests)
+		assertContains(t, resp.Header().Get("Retry-After"), "900")
+	})
+
+	t.Run("admins can see and unlock", func(t *testing.T) {
+		sessStore.sesh = Sesh{Name: admin.Username, Authenticated: true}
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/admin/lockouts"))
+		assertStatus(t, resp.Code, http.StatusOK)
+		assertContains(t, resp.Body.String(), "user:admin")
+		assertContains(t, resp.Body.String(), "user:nobody")
+		assertContains(t, resp.Body.String(), "ip:203.0.113.9")
+
+		for _, key := range []string{"user:admin", "ip:203.0.113.9"} {
+			resp = httptest.NewRecorder()
+			server.ServeHTTP(resp, newPostRequest(t, "/admin/lockouts/unlock", url.Values{"key": {key}}))
+			assertStatus(t, resp.Code, http.StatusSeeOther)
+		}
+		sessStore.sesh = Sesh{}
+
+		assertStatus(t, login(t, "admin", "password", "203.0.113.9").Code, http.StatusSeeOther)
+	})
+
+	t.Run("logging in forgets the username's failures", func(t *testing.T) {
+		a, err := server.limiter.get("user:admin")
+		assertNoError(t, err)
+		assertInt(t, a.Failures, 0)
+	})
+}
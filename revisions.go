@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type Revision struct {
+	Id        int
+	ArticleID int
+	Title     string
+	Preview   string
+	Body      string
+	Format    string
+	Source    string
+	Slug      string
+	Category  string
+	// When the revision was saved.
+	Created string
+	// uid of whoever saved it, 0 for revisions made before authors were recorded or by a deleted user.
+	AuthorID int
+	// Their username, looked up from AuthorID when read.
+	Author string
+}
+
+// The revision as plain text, so two revisions can be diffed line by line.
+func (r Revision) diffText() string {
+	body := r.Body
+	if r.Format == formatMarkdown {
+		body = r.Source
+	}
+	return "Title: " + r.Title + "\n" +
+		"Slug: " + r.Slug + "\n" +
+		"Category: " + r.Category + "\n" +
+		"Format: " + r.Format + "\n" +
+		"Preview:\n" + r.Preview + "\n" +
+		"Body:\n" + body
+}
+
+// Shows an article's revisions, with a diff between the from and to revisions.
+// Without them, the newest revision is compared with the one before it.
+func (s *Server) ArticleRevisions(w http.ResponseWriter, r *http.Request) {
+	id, article, err := s.store.getArticle(mux.Vars(r)["slug"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if id == 0 {
+		notFound(w)
+		return
+	}
+	if !s.checkCanEdit(w, r, article) {
+		return
+	}
+	s.revisionsPage(w, r, http.StatusOK, id, article, nil)
+}
+
+// Saves the revision's content over the article, which records it as a new revision.
+func (s *Server) RestoreRevision(w http.ResponseWriter, r *http.Request) {
+	id, article, err := s.store.getArticle(mux.Vars(r)["slug"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if id == 0 {
+		notFound(w)
+		return
+	}
+	if !s.checkCanEdit(w, r, article) {
+		return
+	}
+	revID, err := strconv.Atoi(mux.Vars(r)["revision"])
+	if err != nil {
+		notFound(w)
+		return
+	}
+	rev, err := s.store.getRevision(id, revID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if rev.Id == 0 {
+		notFound(w)
+		return
+	}
+
+	restored := article
+	restored.Title = rev.Title
+	restored.Preview = rev.Preview
+	restored.Body = rev.Body
+	restored.Format = rev.Format
+	restored.Source = rev.Source
+	restored.Slug = rev.Slug
+	restored.Category = rev.Category
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	restored.EditedByID, restored.EditedBy = user.Id, user.Username
+	setArticleTimes(&restored, article, s.clock.Now())
+
+	// The revision's category might have been deleted since.
+	errs, err := s.ValidateArticle(restored, false)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if len(errs) != 0 {
+		s.revisionsPage(w, r, http.StatusBadRequest, id, article, errs)
+		return
+	}
+	if err := s.store.editArticle(id, restored); err != nil {
+		if errors.Is(err, errSlugTaken) {
+			s.revisionsPage(w, r, http.StatusConflict, id, article, []string{errSlugAlreadyExists})
+			return
+		}
+		serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/"+restored.Slug+"/revisions", http.StatusSeeOther)
+}
+
+func (s *Server) revisionsPage(w http.ResponseWriter, r *http.Request, status, id int, article Article, errors []string) {
+	revisions, err := s.store.getRevisions(id)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	var from, to Revision
+	if len(revisions) > 1 {
+		from, to = revisions[1], revisions[0]
+	}
+	if f, err := strconv.Atoi(r.FormValue("from")); err == nil {
+		if from, err = s.store.getRevision(id, f); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	if t, err := strconv.Atoi(r.FormValue("to")); err == nil {
+		if to, err = s.store.getRevision(id, t); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	var diff []DiffLine
+	if from.Id != 0 && to.Id != 0 {
+		diff = diffLines(from.diffText(), to.diffText())
+	}
+
+	v := s.viewer(w, r)
+	if DEV {
+		revisionsTemplate = setRevisionsTemplate()
+	}
+	w.WriteHeader(status)
+	tmpl := revisionsTemplate
+	tmpl.Execute(w, struct {
+		Article   Article
+		Revisions []Revision
+		From      Revision
+		To        Revision
+		Diff      []DiffLine
+		Errors    []string
+		Viewer
+		Dev         bool
+		Description string
+	}{article, revisions, from, to, diff, errors, v, DEV, defaultDescription})
+}
+
+func setRevisionsTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/revisions.html"))
+}
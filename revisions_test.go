@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFileSystemStoreRevisions(t *testing.T) {
+	articles := MakeArticlesOfCategory(2, time.Now().UTC(), progCat)
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	mik := admin
+	mik.Username = "mik"
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{mik})
+	defer closeDB()
+
+	id, original, err := store.getArticle(articles[0].Slug)
+	assertNoError(t, err)
+	mik, err = store.getUser("mik")
+	assertNoError(t, err)
+
+	t.Run("new articles have one revision", func(t *testing.T) {
+		revs, err := store.getRevisions(id)
+		assertNoError(t, err)
+		assertInt(t, len(revs), 1)
+		if revs[0].Title != original.Title || revs[0].Body != original.Body {
+			t.Errorf("got revision %v, want the article as saved", revs[0])
+		}
+	})
+
+	t.Run("each save adds a revision", func(t *testing.T) {
+		edit := original
+		edit.Title = "Second Title"
+		edit.EditedByID = mik.Id
+		assertNoError(t, store.editArticle(id, edit))
+
+		revs, err := store.getRevisions(id)
+		assertNoError(t, err)
+		assertInt(t, len(revs), 2)
+		if revs[0].Title != "Second Title" || revs[0].Author != "mik" {
+			t.Errorf("got newest revision %q by %q, want %q by mik", revs[0].Title, revs[0].Author, "Second Title")
+		}
+		if revs[1].Title != original.Title {
+			t.Errorf("got oldest revision %q, want %q", revs[1].Title, original.Title)
+		}
+
+		_, got, err := store.getArticle(original.Slug)
+		assertNoError(t, err)
+		if got.EditedBy != "mik" {
+			t.Errorf("got edited by %q, want mik", got.EditedBy)
+		}
+	})
+
+	t.Run("renaming the editor keeps the history", func(t *testing.T) {
+		_, err := store.db.Exec("UPDATE Users SET Username = 'mikael' WHERE uid = ?", mik.Id)
+		assertNoError(t, err)
+		defer store.db.Exec("UPDATE Users SET Username = 'mik' WHERE uid = ?", mik.Id)
+
+		revs, err := store.getRevisions(id)
+		assertNoError(t, err)
+		_, got, err := store.getArticle(original.Slug)
+		assertNoError(t, err)
+		if revs[0].Author != "mikael" || got.EditedBy != "mikael" {
+			t.Errorf("got revision by %q, article edited by %q, want mikael", revs[0].Author, got.EditedBy)
+		}
+	})
+
+	t.Run("revisions belong to their article", func(t *testing.T) {
+		revs, err := store.getRevisions(id)
+		assertNoError(t, err)
+
+		otherID, _, err := store.getArticle(articles[1].Slug)
+		assertNoError(t, err)
+		got, err := store.getRevision(otherID, revs[0].Id)
+		assertNoError(t, err)
+		if got != (Revision{}) {
+			t.Errorf("got %v, want no revision", got)
+		}
+	})
+
+	t.Run("deleting an article deletes its revisions", func(t *testing.T) {
+		assertNoError(t, store.deleteArticle(id))
+		revs, err := store.getRevisions(id)
+		assertNoError(t, err)
+		assertInt(t, len(revs), 0)
+	})
+
+	t.Run("migrated articles get a first revision", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		legacy := MakeArticlesOfCategory(1, time.Now().UTC(), progCat)
+		makeV0Database(t, tmpFile.Name(), legacy)
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		defer closeDB()
+
+		id, _, err := store.getArticle(legacy[0].Slug)
+		assertNoError(t, err)
+		revs, err := store.getRevisions(id)
+		assertNoError(t, err)
+		assertInt(t, len(revs), 1)
+		if revs[0].Title != legacy[0].Title {
+			t.Errorf("got revision %q, want %q", revs[0].Title, legacy[0].Title)
+		}
+	})
+}
+
+func TestRevisionRoutes(t *testing.T) {
+	articles := MakeArticlesOfCategory(1, time.Now().UTC(), progCat)
+	article := articles[0]
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	mik := admin
+	mik.Username = "mik"
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{mik})
+	defer closeDB()
+	sessStore := StubSessionStore{}
+	server := NewServer(store, &sessStore)
+
+	id, _, err := store.getArticle(article.Slug)
+	assertNoError(t, err)
+
+	t.Run("revisions need a login", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/"+article.Slug+"/revisions"))
+		assertStatus(t, resp.Code, 401)
+
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/"+article.Slug+"/revisions/1/restore", nil))
+		assertStatus(t, resp.Code, 401)
+	})
+
+	sessStore.sesh = Sesh{Name: "mik", Authenticated: true}
+
+	edit := article
+	edit.Body = "A whole new body."
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, newPostRequest(t, "/"+article.Slug+"/edit", setDataValues(edit)))
+	assertStatus(t, resp.Code, 303)
+
+	revs, err := store.getRevisions(id)
+	assertNoError(t, err)
+	assertInt(t, len(revs), 2)
+	first := revs[1]
+
+	t.Run("edits are recorded with the editor", func(t *testing.T) {
+		if revs[0].Author != "mik" {
+			t.Errorf("got author %q, want mik", revs[0].Author)
+		}
+	})
+
+	t.Run("latest change is shown as a diff", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/"+article.Slug+"/revisions"))
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Body.String(), "- "+article.Body)
+		assertContains(t, resp.Body.String(), "+ A whole new body.")
+	})
+
+	t.Run("restoring saves the old content as a new revision", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/"+article.Slug+"/revisions/"+strconv.Itoa(first.Id)+"/restore", nil))
+		assertStatus(t, resp.Code, 303)
+
+		_, got, err := store.getArticle(article.Slug)
+		assertNoError(t, err)
+		if got.Body != article.Body {
+			t.Errorf("got body %q, want %q", got.Body, article.Body)
+		}
+
+		revs, err := store.getRevisions(id)
+		assertNoError(t, err)
+		assertInt(t, len(revs), 3)
+	})
+
+	t.Run("unknown revisions are 404", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/"+article.Slug+"/revisions/999/restore", nil))
+		assertStatus(t, resp.Code, 404)
+
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/missing/revisions"))
+		assertStatus(t, resp.Code, 404)
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	// Manages users and settings, and can do anything an editor can.
+	roleAdmin = "admin"
+	// Edits and deletes any article.
+	roleEditor = "editor"
+	// Writes articles, and edits only their own.
+	roleAuthor = "author"
+)
+
+var roles = []string{roleAdmin, roleEditor, roleAuthor}
+
+func isValidRole(role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type permission int
+
+const (
+	// Write new articles and edit their own. Every role has it.
+	permWrite permission = iota
+	// Edit, delete and restore anyone's articles.
+	permEditAny
+	// Manage users and site settings like categories.
+	permManage
+)
+
+func (u User) can(p permission) bool {
+	switch p {
+	case permWrite:
+		return isValidRole(u.Role)
+	case permEditAny:
+		return u.Role == roleAdmin || u.Role == roleEditor
+	case permManage:
+		return u.Role == roleAdmin
+	}
+	return false
+}
+
+// Authors can only edit articles they wrote.
+func (u User) canEdit(a Article) bool {
+	return u.can(permEditAny) || (u.can(permWrite) && a.AuthorID != 0 && a.AuthorID == u.Id)
+}
+
+type userContextKey struct{}
+
+// The logged in user, or an empty User if nobody is. Users whose account has
+// been deleted since they logged in count as logged out.
+func (s *Server) currentUser(r *http.Request) (User, error) {
+	if u, ok := r.Context().Value(userContextKey{}).(User); ok {
+		return u, nil
+	}
+	session, err := s.sessionStore.Get(r, "user")
+	if err != nil {
+		return User{}, nil
+	}
+	sesh := s.sessionStore.getSesh(session)
+	if !sesh.Authenticated {
+		return User{}, nil
+	}
+	return s.store.getUser(sesh.Name)
+}
+
+// Only lets users with the permission through to next. Logged out users get a 401, anyone else without it a 403.
+func (s *Server) require(p permission, next http.HandlerFunc) http.HandlerFunc {
+	return s.checkPermission(p, next, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
+
+// Like require, but logged out users are sent to the login page instead. For pages linked to from the admin panel.
+func (s *Server) requirePage(p permission, next http.HandlerFunc) http.HandlerFunc {
+	return s.checkPermission(p, next, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+	})
+}
+
+func (s *Server) checkPermission(p permission, next, loggedOut http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.currentUser(r)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if user.Id == 0 {
+			loggedOut(w, r)
+			return
+		}
+		if !user.can(p) {
+			forbidden(w)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+// Checks the logged in user may edit the article, responding with a 403 if not.
+func (s *Server) checkCanEdit(w http.ResponseWriter, r *http.Request, a Article) bool {
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return false
+	}
+	if !user.canEdit(a) {
+		forbidden(w)
+		return false
+	}
+	return true
+}
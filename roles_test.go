@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// An admin, an editor and two authors, with ids 1 to 4.
+func makeRoleUsers() []User {
+	users := []User{admin, admin, admin, admin}
+	for i, role := range []string{roleAdmin, roleEditor, roleAuthor, roleAuthor} {
+		users[i].Username = []string{"admin", "ed", "author", "other"}[i]
+		users[i].Role = role
+	}
+	return users
+}
+
+func TestUserPermissions(t *testing.T) {
+	own := Article{AuthorID: 3}
+	orphan := Article{AuthorID: 0}
+
+	cases := []struct {
+		role                   string
+		id                     int
+		write, editAny, manage bool
+		editOwn, editOrphan    bool
+	}{
+		{roleAdmin, 1, true, true, true, true, true},
+		{roleEditor, 2, true, true, false, true, true},
+		{roleAuthor, 3, true, false, false, true, false},
+		{roleAuthor, 4, true, false, false, false, false},
+		{"", 5, false, false, false, false, false},
+	}
+	for _, c := range cases {
+		u := User{Id: c.id, Role: c.role}
+		if u.can(permWrite) != c.write || u.can(permEditAny) != c.editAny || u.can(permManage) != c.manage {
+			t.Errorf("%q: got write %v, edit any %v, manage %v", c.role, u.can(permWrite), u.can(permEditAny), u.can(permManage))
+		}
+		if u.canEdit(own) != c.editOwn || u.canEdit(orphan) != c.editOrphan {
+			t.Errorf("%q %d: got edit own %v, edit orphan %v", c.role, c.id, u.canEdit(own), u.canEdit(orphan))
+		}
+	}
+}
+
+// Who each route lets in.
+const (
+	allowAnyone = iota
+	allowOwner
+	allowEditors
+	allowAdmins
+)
+
+type roleRoute struct {
+	method, path string
+	allow        int
+	// Logged out users are sent to the login page rather than getting a 401.
+	page bool
+}
+
+// The routes behind a login. Features with their own routes list them next to their tests.
+var roleRoutes = []roleRoute{
+	{"GET", "/admin", allowAnyone, true},
+	{"GET", "/admin/password", allowAnyone, true},
+	{"POST", "/admin/password", allowAnyone, false},
+	{"GET", "/new", allowAnyone, false},
+	{"POST", "/new", allowAnyone, false},
+	{"GET", "/{own}/edit", allowOwner, false},
+	{"POST", "/{own}/edit", allowOwner, false},
+	{"POST", "/{own}/previous-slugs/delete", allowOwner, false},
+	{"GET", "/{own}/revisions", allowOwner, false},
+	{"POST", "/{own}/revisions/1/restore", allowOwner, false},
+	{"GET", "/{own}/delete", allowEditors, true},
+	{"POST", "/{own}/delete", allowEditors, false},
+	{"GET", "/admin/trash", allowEditors, true},
+	{"POST", "/admin/trash/{trashed}/restore", allowEditors, false},
+	{"POST", "/admin/trash/{trashed}/purge", allowEditors, false},
+	{"GET", "/admin/categories", allowAdmins, true},
+	{"POST", "/admin/categories", allowAdmins, false},
+	{"POST", "/admin/categories/other/edit", allowAdmins, false},
+	{"POST", "/admin/categories/other/delete", allowAdmins, false},
+	{"GET", "/admin/users", allowAdmins, true},
+	{"POST", "/admin/users", allowAdmins, false},
+	{"POST", "/admin/users/other/edit", allowAdmins, false},
+	{"POST", "/admin/users/other/delete", allowAdmins, false},
+	{"GET", "/admin/lockouts", allowAdmins, true},
+	{"POST", "/admin/lockouts/unlock", allowAdmins, false},
+	{"GET", "/admin/sessions", allowAdmins, true},
+	{"POST", "/admin/sessions/revoke", allowAdmins, false},
+	{"POST", "/admin/sessions/revoke-user", allowAdmins, false},
+}
+
+func TestRoleRoutes(t *testing.T) {
+	routes := append(append([]roleRoute{}, roleRoutes...), profileRoleRoutes...)
+	routes = append(routes, twoFactorRoleRoutes...)
+	logins := []struct {
+		name     string
+		username string
+		// The highest level of route the user can use.
+		level int
+	}{
+		{"admin", "admin", allowAdmins},
+		{"editor", "ed", allowEditors},
+		{"author", "author", allowOwner},
+		{"other author", "other", allowAnyone},
+		{"logged out", "", -1},
+	}
+
+	for _, route := range routes {
+		for _, login := range logins {
+			t.Run(route.method+" "+route.path+" as "+login.name, func(t *testing.T) {
+				server, sessStore, own, trashed, cleanup := newRoleServer(t)
+				defer cleanup()
+				if login.username != "" {
+					sessStore.sesh = Sesh{Name: login.username, Authenticated: true}
+				}
+
+				path := strings.NewReplacer("{own}", own.Slug, "{trashed}", trashed.Slug).Replace(route.path)
+				req, _ := http.NewRequest(route.method, path, nil)
+				if route.method == "POST" {
+					data := setDataValues(own)
+					data.Set("role", roleEditor)
+					req = newPostRequest(t, path, data)
+				}
+				resp := httptest.NewRecorder()
+				server.ServeHTTP(resp, req)
+
+				switch {
+				case login.username == "" && route.page:
+					assertStatus(t, resp.Code, http.StatusSeeOther)
+					assertContains(t, resp.Header().Get("Location"), "/admin/login")
+				case login.username == "":
+					assertStatus(t, resp.Code, http.StatusUnauthorized)
+				case route.allow > login.level:
+					assertStatus(t, resp.Code, http.StatusForbidden)
+				case resp.Code == http.StatusUnauthorized || resp.Code == http.StatusForbidden:
+					t.Errorf("got %d, want to be let in", resp.Code)
+				}
+			})
+		}
+	}
+}
+
+// A server with the users from makeRoleUsers, an article written by author and one in the trash.
+func newRoleServer(t *testing.T) (*Server, *StubSessionStore, Article, Article, func()) {
+	t.Helper()
+	articles := MakeArticlesOfCategory(2, time.Now().UTC(), progCat)
+	articles[0].AuthorID = 3
+
+	tmpFile, cleanTempFile := makeTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, makeRoleUsers())
+	id, _, err := store.getArticle(articles[1].Slug)
+	assertNoError(t, err)
+	assertNoError(t, store.trashArticle(id, myTimeToString(time.Now().UTC())))
+
+	sessStore := &StubSessionStore{}
+	server := NewServer(store, sessStore)
+	return server, sessStore, articles[0], articles[1], func() {
+		closeDB()
+		cleanTempFile()
+	}
+}
+
+func TestAuthorsOwnArticles(t *testing.T) {
+	server, sessStore, own, _, cleanup := newRoleServer(t)
+	defer cleanup()
+	store := server.store.(*FileSystemStore)
+	unowned := MakeArticlesOfCategory(1, time.Now().UTC(), progCat)[0]
+	unowned.Slug = "unowned"
+	unowned.Title = "Unowned Article"
+	assertNoError(t, store.newArticle(unowned))
+
+	sessStore.sesh = Sesh{Name: "author", Authenticated: true}
+
+	t.Run("new articles belong to whoever wrote them", func(t *testing.T) {
+		a := validArticleBase
+		a.Slug = "by-author"
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/new", setDataValues(a)))
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+
+		_, got, err := store.getArticle(a.Slug)
+		assertNoError(t, err)
+		assertInt(t, got.AuthorID, 3)
+	})
+
+	t.Run("editors keep the author", func(t *testing.T) {
+		sessStore.sesh.Name = "ed"
+		defer func() { sessStore.sesh.Name = "author" }()
+
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/"+own.Slug+"/edit", setDataValues(own)))
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+
+		_, got, err := store.getArticle(own.Slug)
+		assertNoError(t, err)
+		assertInt(t, got.AuthorID, 3)
+	})
+
+	t.Run("the admin panel only lists their articles", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/admin"))
+		body := resp.Body.String()
+		assertContains(t, body, own.Title)
+		assertNotContain(t, body, unowned.Title)
+		assertNotContain(t, body, `href="/admin/users"`)
+	})
+}
+
+func TestFileSystemStoreRoles(t *testing.T) {
+	articles := MakeArticlesOfCategory(1, time.Now().UTC(), progCat)
+	articles[0].AuthorID = 3
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, makeRoleUsers())
+	defer closeDB()
+
+	t.Run("users", func(t *testing.T) {
+		users, err := store.getUsers()
+		assertNoError(t, err)
+		assertInt(t, len(users), 4)
+		for i, want := range makeRoleUsers() {
+			if users[i].Username != want.Username || users[i].Role != want.Role || users[i].Password_Hash != "" {
+				t.Errorf("got %+v, want %s with role %s and no hash", users[i], want.Username, want.Role)
+			}
+		}
+	})
+
+	t.Run("usernames are unique", func(t *testing.T) {
+		err := store.newUser(User{Username: "ed", Password_Hash: "x"})
+		if !errors.Is(err, errUsernameTaken) {
+			t.Errorf("got %v, want %v", err, errUsernameTaken)
+		}
+	})
+
+	t.Run("set role", func(t *testing.T) {
+		assertNoError(t, store.setRole("other", roleEditor))
+		u, err := store.getUser("other")
+		assertNoError(t, err)
+		if u.Role != roleEditor {
+			t.Errorf("got role %s, want %s", u.Role, roleEditor)
+		}
+	})
+
+	t.Run("the last admin can't be demoted or deleted", func(t *testing.T) {
+		if err := store.setRole("admin", roleEditor); !errors.Is(err, errLastAdmin) {
+			t.Errorf("got %v, want %v", err, errLastAdmin)
+		}
+		if err := store.deleteUser("admin"); !errors.Is(err, errLastAdmin) {
+			t.Errorf("got %v, want %v", err, errLastAdmin)
+		}
+
+		assertNoError(t, store.setRole("ed", roleAdmin))
+		assertNoError(t, store.setRole("admin", roleEditor))
+	})
+
+	t.Run("deleted authors' articles are kept without an author", func(t *testing.T) {
+		assertNoError(t, store.deleteUser("author"))
+		u, err := store.getUser("author")
+		assertNoError(t, err)
+		assertInt(t, u.Id, 0)
+
+		_, got, err := store.getArticle(articles[0].Slug)
+		assertNoError(t, err)
+		assertInt(t, got.AuthorID, 0)
+	})
+}
+
+func TestUserRoutes(t *testing.T) {
+	server, sessStore, _, _, cleanup := newRoleServer(t)
+	defer cleanup()
+	sessStore.sesh = Sesh{Name: "admin", Authenticated: true}
+
+	post := func(t *testing.T, path string, data url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, path, data))
+		return resp
+	}
+	newUser := func(username, role, password string) url.Values {
+		return url.Values{"username": {username}, "role": {role}, "password": {password}, "confirm_password": {password}}
+	}
+
+	t.Run("users page", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/admin/users"))
+		assertStatus(t, resp.Code, http.StatusOK)
+		assertContains(t, resp.Body.String(), `action="/admin/users/other/delete"`)
+	})
+
+	t.Run("add a user", func(t *testing.T) {
+		assertStatus(t, post(t, "/admin/users", newUser("newbie", roleAuthor, "longenough")).Code, http.StatusSeeOther)
+
+		sessStore.sesh.Name = "newbie"
+		defer func() { sessStore.sesh.Name = "admin" }()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/admin"))
+		assertStatus(t, resp.Code, http.StatusOK)
+	})
+
+	t.Run("invalid users", func(t *testing.T) {
+		cases := []struct {
+			name string
+			data url.Values
+			code int
+			want string
+		}{
+			{"no username", newUser(" ", roleAuthor, "longenough"), http.StatusBadRequest, errUserNoUsername},
+			{"unknown role", newUser("someone", "owner", "longenough"), http.StatusBadRequest, errUserBadRole},
+			{"short password", newUser("someone", roleAuthor, "short"), http.StatusBadRequest, errPasswordShort},
+			{"username taken", newUser("ed", roleAuthor, "longenough"), http.StatusConflict, errUserTaken},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				resp := post(t, "/admin/users", c.data)
+				assertStatus(t, resp.Code, c.code)
+				assertContains(t, resp.Body.String(), c.want)
+			})
+		}
+	})
+
+	t.Run("roles take effect straight away", func(t *testing.T) {
+		assertStatus(t, post(t, "/admin/users/ed/edit", url.Values{"role": {roleAuthor}}).Code, http.StatusSeeOther)
+
+		sessStore.sesh.Name = "ed"
+		defer func() { sessStore.sesh.Name = "admin" }()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/admin/trash"))
+		assertStatus(t, resp.Code, http.StatusForbidden)
+	})
+
+	t.Run("the last admin stays", func(t *testing.T) {
+		resp := post(t, "/admin/users/admin/edit", url.Values{"role": {roleEditor}})
+		assertStatus(t, resp.Code, http.StatusConflict)
+		assertContains(t, resp.Body.String(), errUserLastAdmin)
+
+		assertStatus(t, post(t, "/admin/users/admin/delete", nil).Code, http.StatusConflict)
+	})
+
+	t.Run("deleted users are logged out", func(t *testing.T) {
+		assertStatus(t, post(t, "/admin/users/other/delete", nil).Code, http.StatusSeeOther)
+		assertStatus(t, post(t, "/admin/users/other/delete", nil).Code, http.StatusNotFound)
+
+		sessStore.sesh.Name = "other"
+		defer func() { sessStore.sesh.Name = "admin" }()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/admin"))
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+	})
+}
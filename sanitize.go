@@ -0,0 +1,39 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// What article bodies may contain: the usual user generated content, plus the bits Markdown rendering produces.
+var bodyPolicy = newBodyPolicy()
+
+func newBodyPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Fenced code blocks, e.g. <code class="language-go">.
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.AllowAttrs("data-highlight").Matching(regexp.MustCompile(`^[0-9, -]*$`)).OnElements("code")
+	// Task list checkboxes.
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+	// Footnotes.
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^footnotes$`)).OnElements("div")
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(endnotes|noteref|backlink)$`)).OnElements("div", "a")
+	return p
+}
+
+// Strips every tag, for indexing. Tags are replaced with a space so words either side of one aren't joined.
+var plainTextPolicy = bluemonday.StrictPolicy().AddSpaceWhenStrippingTag(true)
+
+func plainText(body string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(plainTextPolicy.Sanitize(body))), " ")
+}
+
+// Article bodies are shown as they are, never run as templates, so {{ }} in code samples is just text.
+func sanitizeBody(body string) template.HTML {
+	return template.HTML(bodyPolicy.Sanitize(body))
+}
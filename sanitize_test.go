@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestSanitizeBody(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string
+		want    []string
+		notWant []string
+	}{
+		{"plain html is kept", "<p>Hello <strong>world</strong></p>", []string{"<p>Hello <strong>world</strong></p>"}, nil},
+		{"scripts are removed", `<p>hi</p><script>alert("x")</script>`, []string{"<p>hi</p>"}, []string{"<script", "alert"}},
+		{"event handlers are removed", `<img src="/static/images/logo.png" onerror="alert(1)">`, []string{`src="/static/images/logo.png"`}, []string{"onerror"}},
+		{"javascript links are removed", `<a href="javascript:alert(1)">x</a>`, nil, []string{"javascript:"}},
+		{"code language classes are kept", `<pre><code class="language-go">x</code></pre>`, []string{`<code class="language-go">`}, nil},
+		{"other classes are removed", `<p class="is-hidden">x</p>`, []string{"<p>x</p>"}, []string{"is-hidden"}},
+		{"template syntax is just text", `<pre><code>{{define "body"}}{{.Title}}{{end}}</code></pre>`, []string{`{{define &#34;body&#34;}}{{.Title}}{{end}}`}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := string(sanitizeBody(c.body))
+			for _, want := range c.want {
+				assertContains(t, got, want)
+			}
+			for _, notWant := range c.notWant {
+				assertNotContain(t, got, notWant)
+			}
+		})
+	}
+}
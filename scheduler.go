@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// How often the scheduler looks for articles that are due.
+const schedulerInterval = time.Minute
+
+// Tells the time, so tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Publishes scheduled articles once their publish time has passed,
+// purges articles that have been in the trash longer than retention,
+// and deletes expired sessions.
+type Scheduler struct {
+	store     Store
+	clock     Clock
+	interval  time.Duration
+	retention time.Duration
+	// Told when scheduled articles are published.
+	notifier Notifier
+	// Nil unless sessions are kept on the server.
+	sessions serverSessions
+}
+
+func NewScheduler(store Store, clock Clock, interval, retention time.Duration) *Scheduler {
+	return &Scheduler{store: store, clock: clock, interval: interval, retention: retention, notifier: discardNotifier{}}
+}
+
+// Runs the scheduler in the background until stop is called.
+func (sc *Scheduler) Start() (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(sc.interval)
+		defer ticker.Stop()
+		for {
+			sc.publishDue()
+			sc.purgeDue()
+			sc.purgeSessions()
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (sc *Scheduler) publishDue() int {
+	n, err := sc.store.publishScheduled(myTimeToString(sc.clock.Now().UTC()))
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	if n > 0 {
+		log.Printf("Published %d scheduled article(s)", n)
+		event := Event{Kind: eventArticlePublished, Time: sc.clock.Now().UTC(), Detail: fmt.Sprintf("%d scheduled article(s) published", n)}
+		if err := sc.notifier.Notify([]Event{event}); err != nil {
+			log.Print(err)
+		}
+	}
+	return n
+}
+
+func (sc *Scheduler) purgeDue() int {
+	n, err := sc.store.purgeTrash(myTimeToString(sc.clock.Now().UTC().Add(-sc.retention)))
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	if n > 0 {
+		log.Printf("Purged %d article(s) from the trash", n)
+	}
+	return n
+}
+
+func (sc *Scheduler) purgeSessions() int {
+	if sc.sessions == nil {
+		return 0
+	}
+	n, err := sc.sessions.deleteExpired(sc.clock.Now())
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Wrap matched words in search results. Control characters can't appear in
+// indexed text, so the markers survive escaping and are swapped for <mark> after.
+const (
+	searchMarkStart = "\x02"
+	searchMarkEnd   = "\x03"
+)
+
+// A search box query. Words and "quoted phrases" must all appear, a trailing *
+// matches any word starting with what's before it, and category:slug
+// (or category:"Name") limits results to one category.
+type SearchQuery struct {
+	// FTS5 strings, already quoted.
+	Terms []string
+	// Category slug, empty for every category.
+	Category string
+}
+
+func parseSearchQuery(q string) SearchQuery {
+	var ret SearchQuery
+	rs := []rune(q)
+	i := 0
+
+	// Reads up to the closing quote, or the end of q if there isn't one.
+	readQuoted := func() string {
+		i++
+		start := i
+		for i < len(rs) && rs[i] != '"' {
+			i++
+		}
+		s := string(rs[start:i])
+		if i < len(rs) {
+			i++
+		}
+		return s
+	}
+	readWord := func() string {
+		start := i
+		for i < len(rs) && !unicode.IsSpace(rs[i]) {
+			i++
+		}
+		return string(rs[start:i])
+	}
+	prefix := func() bool {
+		if i < len(rs) && rs[i] == '*' {
+			i++
+			return true
+		}
+		return false
+	}
+
+	for i < len(rs) {
+		switch {
+		case unicode.IsSpace(rs[i]):
+			i++
+		case rs[i] == '"':
+			phrase := readQuoted()
+			ret.addTerm(phrase, prefix())
+		case strings.HasPrefix(strings.ToLower(string(rs[i:])), "category:"):
+			i += len("category:")
+			if i < len(rs) && rs[i] == '"' {
+				ret.Category = categorySlug(readQuoted())
+			} else {
+				ret.Category = categorySlug(readWord())
+			}
+		default:
+			word := readWord()
+			isPrefix := strings.HasSuffix(word, "*")
+			ret.addTerm(strings.TrimRight(word, "*"), isPrefix)
+		}
+	}
+	return ret
+}
+
+// Quotes the term, so anything FTS5 would treat as syntax is searched for as text.
+func (q *SearchQuery) addTerm(term string, prefix bool) {
+	if strings.TrimSpace(term) == "" {
+		return
+	}
+	quoted := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	if prefix {
+		quoted += "*"
+	}
+	q.Terms = append(q.Terms, quoted)
+}
+
+// The FTS5 MATCH expression. Empty if there's nothing to search for.
+func (q SearchQuery) match() string {
+	return strings.Join(q.Terms, " ")
+}
+
+type SearchResult struct {
+	Article
+	// The title and the best matching part of the article, with matches in <mark>.
+	TitleHTML template.HTML
+	Snippet   template.HTML
+}
+
+// Escapes the text around the markers the index put around matches, then turns the markers into <mark>.
+func highlightMatches(s string) template.HTML {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, searchMarkStart, "<mark>")
+	s = strings.ReplaceAll(s, searchMarkEnd, "</mark>")
+	return template.HTML(s)
+}
+
+func (s *Server) Search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		var err error
+		if page, err = strconv.Atoi(p); err != nil {
+			notFound(w)
+			return
+		}
+	}
+	perPage := getPerPage(r)
+
+	var results []SearchResult
+	var total int
+	query := parseSearchQuery(q)
+	if query.match() != "" {
+		var err error
+		results, total, err = s.store.Search(query, page, perPage)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	p := Pagination{Page: page, PerPage: perPage, Total: total}
+	if !p.InRange() {
+		notFound(w)
+		return
+	}
+
+	for i := range results {
+		results[i].Article = articleWithoutTime(results[i].Article)
+	}
+
+	v := s.viewer(w, r)
+	if DEV {
+		searchTemplate = setSearchTemplate()
+	}
+	tmpl := searchTemplate
+	tmpl.Execute(w, struct {
+		Query    string
+		Searched bool
+		Results  []SearchResult
+		Total    int
+		PageInfo PageInfo
+		Viewer
+		Dev         bool
+		Description string
+	}{q, query.match() != "", results, total, makePageInfo(p, func(n int) string { return searchURL(q, n, perPage) }), v, DEV, defaultDescription})
+}
+
+func searchURL(q string, page, perPage int) string {
+	ret := "/search?q=" + url.QueryEscape(q)
+	if page != 1 {
+		ret += "&page=" + strconv.Itoa(page)
+	}
+	if perPage != defaultPerPage {
+		ret += "&per_page=" + strconv.Itoa(perPage)
+	}
+	return ret
+}
+
+func setSearchTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/pagination.html", "static/templates/search.html"))
+}
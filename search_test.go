@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	cases := []struct {
+		query string
+		want  SearchQuery
+	}{
+		{"", SearchQuery{}},
+		{"go templates", SearchQuery{Terms: []string{`"go"`, `"templates"`}}},
+		{`"html templates" go`, SearchQuery{Terms: []string{`"html templates"`, `"go"`}}},
+		{"templ*", SearchQuery{Terms: []string{`"templ"*`}}},
+		{`"html templ"*`, SearchQuery{Terms: []string{`"html templ"*`}}},
+		{"go category:programming", SearchQuery{Terms: []string{`"go"`}, Category: "programming"}},
+		{`Category:"Web Dev" css`, SearchQuery{Terms: []string{`"css"`}, Category: "web-dev"}},
+		{`unclosed "quote`, SearchQuery{Terms: []string{`"unclosed"`, `"quote"`}}},
+		{`AND OR NOT NEAR( col:x`, SearchQuery{Terms: []string{`"AND"`, `"OR"`, `"NOT"`, `"NEAR("`, `"col:x"`}}},
+		{`say"hi"`, SearchQuery{Terms: []string{`"say""hi"""`}}},
+		{"* ** \"\"", SearchQuery{}},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			got := parseSearchQuery(c.query)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+// Articles with distinct words to search for. The first mentions gophers in its title, the rest only in their bodies.
+func makeSearchArticles() []Article {
+	now := time.Now().UTC()
+	a := MakeArticleOfCategory(1, now, progCat)
+	a.Title = "All About Gophers"
+	a.Body = "<p>Gophers dig tunnels.</p>"
+
+	b := MakeArticleOfCategory(2, now, progCat)
+	b.Body = "<p>Templates &amp; gophers, <em>html</em>templates.</p>"
+
+	c := MakeArticleOfCategory(3, now, otherCat)
+	c.Body = "<p>A gopher in the garden, near the html templates.</p>"
+
+	draft := MakeArticleOfCategory(4, now, progCat)
+	draft.Body = "<p>Secret gophers.</p>"
+	draft.Status = statusDraft
+	return []Article{a, b, c, draft}
+}
+
+func TestFileSystemStoreSearch(t *testing.T) {
+	articles := makeSearchArticles()
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+	defer closeDB()
+
+	search := func(t *testing.T, q string) []string {
+		t.Helper()
+		results, total, err := store.Search(parseSearchQuery(q), 1, defaultPerPage)
+		assertNoError(t, err)
+		assertInt(t, total, len(results))
+		var slugs []string
+		for _, r := range results {
+			slugs = append(slugs, r.Slug)
+		}
+		return slugs
+	}
+	assertSlugs := func(t *testing.T, got []string, want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	t.Run("title matches rank first, drafts are left out", func(t *testing.T) {
+		got := search(t, "gophers")
+		assertInt(t, len(got), 3)
+		if got[0] != articles[0].Slug {
+			t.Errorf("got %s first, want %s", got[0], articles[0].Slug)
+		}
+	})
+
+	t.Run("word endings don't matter", func(t *testing.T) {
+		assertSlugs(t, search(t, "tunnel"), articles[0].Slug)
+	})
+
+	t.Run("phrases", func(t *testing.T) {
+		assertSlugs(t, search(t, `"html templates"`), articles[1].Slug, articles[2].Slug)
+		assertSlugs(t, search(t, `"templates html"`))
+	})
+
+	t.Run("prefixes", func(t *testing.T) {
+		assertSlugs(t, search(t, "tunn*"), articles[0].Slug)
+	})
+
+	t.Run("category filter", func(t *testing.T) {
+		assertSlugs(t, search(t, "gopher category:other"), articles[2].Slug)
+		assertSlugs(t, search(t, "gopher category:missing"))
+	})
+
+	t.Run("words either side of a tag aren't joined", func(t *testing.T) {
+		assertSlugs(t, search(t, "htmltemplates"))
+	})
+
+	t.Run("matches are highlighted and escaped", func(t *testing.T) {
+		results, _, err := store.Search(parseSearchQuery("templates"), 1, defaultPerPage)
+		assertNoError(t, err)
+		assertContains(t, string(results[0].Snippet), "<mark>Templates</mark> &amp; gophers")
+
+		results, _, err = store.Search(parseSearchQuery("about"), 1, defaultPerPage)
+		assertNoError(t, err)
+		if results[0].TitleHTML != "All <mark>About</mark> Gophers" {
+			t.Errorf("got title %q", results[0].TitleHTML)
+		}
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		results, total, err := store.Search(parseSearchQuery("gopher"), 2, 2)
+		assertNoError(t, err)
+		assertInt(t, total, 3)
+		assertInt(t, len(results), 1)
+	})
+
+	t.Run("edits are reindexed", func(t *testing.T) {
+		id, a, err := store.getArticle(articles[0].Slug)
+		assertNoError(t, err)
+		a.Title = "All About Moles"
+		assertNoError(t, store.editArticle(id, a))
+		assertSlugs(t, search(t, "moles"), a.Slug)
+		assertSlugs(t, search(t, "about"), a.Slug)
+	})
+
+	t.Run("trashed and deleted articles aren't found", func(t *testing.T) {
+		id, _, err := store.getArticle(articles[2].Slug)
+		assertNoError(t, err)
+		assertNoError(t, store.trashArticle(id, myTimeToString(time.Now().UTC())))
+		assertSlugs(t, search(t, "garden"))
+
+		assertNoError(t, store.deleteArticle(id))
+		var n int
+		assertNoError(t, store.db.QueryRow("SELECT COUNT(*) FROM ArticleSearch WHERE rowid = ?", id).Scan(&n))
+		assertInt(t, n, 0)
+	})
+
+	t.Run("migrated articles are indexed", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		legacy := MakeArticlesOfCategory(1, time.Now().UTC(), progCat)
+		legacy[0].Body = "<p>Legacy badgers.</p>"
+		makeV0Database(t, tmpFile.Name(), legacy)
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		defer closeDB()
+
+		results, _, err := store.Search(parseSearchQuery("badgers"), 1, defaultPerPage)
+		assertNoError(t, err)
+		assertInt(t, len(results), 1)
+	})
+}
+
+func TestSearchRoutes(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, makeSearchArticles(), []User{})
+	defer closeDB()
+	server := NewServer(store, &StubSessionStore{})
+
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, path))
+		return resp
+	}
+
+	t.Run("results", func(t *testing.T) {
+		resp := get(t, "/search?q=gopher")
+		assertStatus(t, resp.Code, 200)
+		body := resp.Body.String()
+		assertContains(t, body, "3 results")
+		assertContains(t, body, "<mark>Gophers</mark>")
+		assertContains(t, body, `value="gopher"`)
+	})
+
+	t.Run("pagination keeps the query", func(t *testing.T) {
+		resp := get(t, "/search?q=gopher+category%3Aprogramming&per_page=1")
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Body.String(), `href="/search?q=gopher&#43;category%3Aprogramming&amp;page=2&amp;per_page=1"`)
+
+		assertStatus(t, get(t, "/search?q=gopher&page=2&per_page=1").Code, 200)
+		assertStatus(t, get(t, "/search?q=gopher&page=9").Code, 404)
+		assertStatus(t, get(t, "/search?q=gopher&page=two").Code, 404)
+	})
+
+	t.Run("no query", func(t *testing.T) {
+		resp := get(t, "/search")
+		assertStatus(t, resp.Code, 200)
+		if strings.Contains(resp.Body.String(), "results") {
+			t.Error("got results without a query")
+		}
+	})
+}
+
+func TestSearchSlugReserved(t *testing.T) {
+	assertArticleSlugReserved(t, "search")
+	assertArticleSlugReserved(t, "Search")
+
+	t.Run("slugs that only contain a reserved word are fine", func(t *testing.T) {
+		server := NewServer(&StubStore{}, &StubSessionStore{})
+		a := validArticleBase
+		a.Slug = "searching-for-bugs"
+		errs, err := server.ValidateArticle(a, false)
+		assertNoError(t, err)
+		if len(errs) != 0 {
+			t.Errorf("got %v, want no errors", errs)
+		}
+	})
+}
@@ -2,25 +2,62 @@ package main
 
 import (
 	"encoding/gob"
-	"fmt"
+	"errors"
 	"html/template"
+	"log"
 	"net/http"
 	"path"
-	"time"
+	"regexp"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 )
 
 type Store interface {
-	getAll() []Article
-	getPage(int, string) ([]Article, int, int)
-	getArticle(slug string) (int, Article)
-	newArticle(Article)
-	editArticle(int, Article)
-	deleteArticle(id int)
-	doesSlugExist(string) bool
+	getAll() ([]Article, error)
+	getPage(category string, page, perPage int) (articles []Article, total int, err error)
+	getLatest(category string, n int) ([]Article, error)
+	getArticle(slug string) (int, Article, error)
+	newArticle(Article) error
+	editArticle(int, Article) error
+	trashArticle(id int, now string) error
+	restoreArticle(id int) error
+	getTrash() ([]Article, error)
+	purgeTrash(before string) (int, error)
+	deleteArticle(id int) error
+	doesSlugExist(string) (bool, error)
 	getUser(username string) (User, error)
+	getUsers() ([]User, error)
+	newUser(User) error
+	setPassword(username, hash string) error
+	setRole(username, role string) error
+	setProfile(username string, u User) error
+	deleteUser(username string) error
+	setTOTP(username, secret string, recoveryHashes []string) error
+	setRecoveryCodes(userID int, hashes []string) error
+	useTOTPStep(userID int, step int64) (bool, error)
+	useRecoveryCode(userID int, hash string) (bool, error)
+	countRecoveryCodes(userID int) (int, error)
+	getTag(slug string) (Tag, error)
+	getTagPage(slug string, page, perPage int) (articles []Article, total int, err error)
+	getLatestTagged(slug string, n int) ([]Article, error)
+	getTagCounts() ([]Tag, error)
+	getAuthorPage(authorID, page, perPage int) (articles []Article, total int, err error)
+	getLatestByAuthor(authorID, n int) ([]Article, error)
+	getCategories() ([]Category, error)
+	getCategory(slug string) (Category, error)
+	newCategory(Category) error
+	editCategory(id int, c Category) error
+	deleteCategory(id int, moveTo string) error
+	getUnpublished() ([]Article, error)
+	publishScheduled(now string) (int, error)
+	getRevisions(articleID int) ([]Revision, error)
+	getRevision(articleID, id int) (Revision, error)
+	getRedirect(slug string) (string, error)
+	getSlugHistory(articleID int) ([]string, error)
+	deleteSlugHistory(articleID int, slug string) error
+	Search(q SearchQuery, page, perPage int) (results []SearchResult, total int, err error)
 }
 
 type SessionStore interface {
@@ -36,64 +73,152 @@ type Server struct {
 	store Store
 	http.Handler
 	sessionStore SessionStore
+	highlighter  *Highlighter
+	clock        Clock
+	limiter      Limiter
+	notifier     Notifier
+}
+
+// First path segments the routes below already use, which article and category slugs can't take.
+// Add to it along with any new top level route.
+var reservedPaths = map[string]bool{
+	"all": true, "new": true, "admin": true, "page": true, "static": true, "search": true,
+	feedRSS: true, feedAtom: true, feedJSON: true,
+	"robots.txt": true, "sitemap.xml": true,
+	"tag": true, "author": true,
+}
+
+// The sitemap parts, /sitemap-1.xml and so on.
+var sitemapPartPath = regexp.MustCompile(`^sitemap-[0-9]+\.xml$`)
+
+func isReservedPath(slug string) bool {
+	slug = strings.ToLower(slug)
+	return reservedPaths[slug] || sitemapPartPath.MatchString(slug)
 }
 
 func NewServer(store Store, sessStore SessionStore) *Server {
 	s := new(Server)
 	s.store = store
 	s.sessionStore = sessStore
+	s.highlighter = NewHighlighter(codeTheme)
+	s.clock = realClock{}
+	s.limiter = NewMemoryLimiter()
+	s.notifier = discardNotifier{}
 	gob.Register(Sesh{})
 
+	templateFuncs = template.FuncMap{"categories": s.navCategories}
 	indexTemplate = setIndexTemplate()
 	viewTemplate = setViewTemplate()
 	formTemplate = setFormTemplate()
 	loginTemplate = setLoginTemplate()
 	adminPanelTemplate = setAdminPanelTemplate()
+	categoriesTemplate = setCategoriesTemplate()
+	revisionsTemplate = setRevisionsTemplate()
+	trashTemplate = setTrashTemplate()
+	searchTemplate = setSearchTemplate()
+	passwordTemplate = setPasswordTemplate()
+	usersTemplate = setUsersTemplate()
+	authorTemplate = setAuthorTemplate()
+	profileTemplate = setProfileTemplate()
+	deleteArticleTemplate = setDeleteArticleTemplate()
+	loginCodeTemplate = setLoginCodeTemplate()
+	twoFactorTemplate = setTwoFactorTemplate()
+	lockoutsTemplate = setLockoutsTemplate()
+	sessionsTemplate = setSessionsTemplate()
 
 	r := mux.NewRouter()
+	r.HandleFunc(highlightCSSPath, s.HighlightCSS).Methods("GET")
 	r.PathPrefix("/static/css/").Handler(http.StripPrefix("/static/css/", http.FileServer(http.Dir(path.Join(base, "/static/css")))))
 	r.PathPrefix("/static/images/").Handler(http.StripPrefix("/static/images/", http.FileServer(http.Dir(path.Join(base, "/static/images")))))
 
-	r.HandleFunc("/", s.MainIndexPage).Methods("GET")
-	r.HandleFunc("/new", s.NewArticleForm).Methods("GET")
-	r.HandleFunc("/new", s.NewArticle).Methods("POST")
-	r.HandleFunc("/page/{page}", s.MainIndexPage).Methods("GET")
-	r.HandleFunc("/other", s.OtherIndexPage).Methods("GET")
-	r.HandleFunc("/other/page/{page}", s.OtherIndexPage).Methods("GET")
+	r.HandleFunc("/", s.HomePage).Methods("GET")
+	r.HandleFunc("/new", s.require(permWrite, s.NewArticleForm)).Methods("GET")
+	r.HandleFunc("/new", s.require(permWrite, s.NewArticle)).Methods("POST")
+	r.HandleFunc("/page/{page}", s.HomePage).Methods("GET")
 	r.HandleFunc("/all", s.All).Methods("GET")
+	r.HandleFunc("/search", s.Search).Methods("GET")
+	r.HandleFunc("/tag/{tag}", s.TagIndexPage).Methods("GET")
+	r.HandleFunc("/tag/{tag}/page/{page}", s.TagIndexPage).Methods("GET")
+	r.HandleFunc("/author/{username}", s.AuthorPage).Methods("GET")
+	r.HandleFunc("/author/{username}/page/{page}", s.AuthorPage).Methods("GET")
+
+	r.HandleFunc("/robots.txt", s.Robots).Methods("GET")
+	r.HandleFunc("/sitemap.xml", s.Sitemap).Methods("GET")
+	r.HandleFunc("/sitemap-{part:[0-9]+}.xml", s.SitemapPart).Methods("GET")
+
+	for _, feed := range []string{feedRSS, feedAtom, feedJSON} {
+		r.HandleFunc("/"+feed, s.Feed).Methods("GET")
+		r.HandleFunc("/{category}/"+feed, s.Feed).Methods("GET")
+		r.HandleFunc("/tag/{tag}/"+feed, s.Feed).Methods("GET")
+		r.HandleFunc("/author/{username}/"+feed, s.Feed).Methods("GET")
+	}
 
-	r.HandleFunc("/admin", s.AdminPanel).Methods("GET")
+	r.HandleFunc("/admin", s.requirePage(permWrite, s.AdminPanel)).Methods("GET")
 	r.HandleFunc("/admin/login", s.LoginPage).Methods("GET")
 	r.HandleFunc("/admin/login", s.AdminLogin).Methods("POST")
+	r.HandleFunc("/admin/login/code", s.LoginCodePage).Methods("GET")
+	r.HandleFunc("/admin/login/code", s.CheckLoginCode).Methods("POST")
 	r.HandleFunc("/admin/logout", s.AdminLogout).Methods("POST")
+	r.HandleFunc("/admin/2fa", s.requirePage(permWrite, s.TwoFactorPage)).Methods("GET")
+	r.HandleFunc("/admin/2fa", s.require(permWrite, s.EnableTwoFactor)).Methods("POST")
+	r.HandleFunc("/admin/2fa/qr.png", s.require(permWrite, s.TwoFactorQR)).Methods("GET")
+	r.HandleFunc("/admin/2fa/disable", s.require(permWrite, s.DisableTwoFactor)).Methods("POST")
+	r.HandleFunc("/admin/2fa/recovery-codes", s.require(permWrite, s.ResetRecoveryCodes)).Methods("POST")
+	r.HandleFunc("/admin/password", s.requirePage(permWrite, s.PasswordForm)).Methods("GET")
+	r.HandleFunc("/admin/password", s.require(permWrite, s.ChangePassword)).Methods("POST")
+	r.HandleFunc("/admin/profile", s.requirePage(permWrite, s.ProfileForm)).Methods("GET")
+	r.HandleFunc("/admin/profile", s.require(permWrite, s.SaveProfile)).Methods("POST")
+	r.HandleFunc("/admin/categories", s.requirePage(permManage, s.AdminCategories)).Methods("GET")
+	r.HandleFunc("/admin/categories", s.require(permManage, s.NewCategory)).Methods("POST")
+	r.HandleFunc("/admin/users", s.requirePage(permManage, s.AdminUsers)).Methods("GET")
+	r.HandleFunc("/admin/users", s.require(permManage, s.NewUser)).Methods("POST")
+	r.HandleFunc("/admin/users/{username}/edit", s.require(permManage, s.EditUser)).Methods("POST")
+	r.HandleFunc("/admin/users/{username}/delete", s.require(permManage, s.DeleteUser)).Methods("POST")
+	r.HandleFunc("/admin/lockouts", s.requirePage(permManage, s.AdminLockouts)).Methods("GET")
+	r.HandleFunc("/admin/lockouts/unlock", s.require(permManage, s.UnlockLogin)).Methods("POST")
+	r.HandleFunc("/admin/sessions", s.requirePage(permManage, s.AdminSessions)).Methods("GET")
+	r.HandleFunc("/admin/sessions/revoke", s.require(permManage, s.RevokeSession)).Methods("POST")
+	r.HandleFunc("/admin/sessions/revoke-user", s.require(permManage, s.RevokeUserSessions)).Methods("POST")
+	r.HandleFunc("/admin/trash", s.requirePage(permEditAny, s.AdminTrash)).Methods("GET")
+	r.HandleFunc("/admin/trash/{slug}/restore", s.require(permEditAny, s.RestoreArticle)).Methods("POST")
+	r.HandleFunc("/admin/trash/{slug}/purge", s.require(permEditAny, s.PurgeArticle)).Methods("POST")
+	r.HandleFunc("/admin/categories/{category}/edit", s.require(permManage, s.EditCategory)).Methods("POST")
+	r.HandleFunc("/admin/categories/{category}/delete", s.require(permManage, s.DeleteCategory)).Methods("POST")
+
+	r.HandleFunc("/{category}", s.CategoryIndexPage).Methods("GET").MatcherFunc(s.isCategoryPath)
+	r.HandleFunc("/{category}/page/{page}", s.CategoryIndexPage).Methods("GET")
 
 	r.HandleFunc("/{slug}", s.ArticleView).Methods("GET")
-	r.HandleFunc("/{slug}/delete", s.DeleteArticle).Methods("GET") // Can't send delete from standard html anchor.
-	r.HandleFunc("/{slug}/edit", s.EditArticleForm).Methods("GET")
-	r.HandleFunc("/{slug}/edit", s.EditArticle).Methods("POST")
-
+	r.HandleFunc("/{slug}/delete", s.requirePage(permEditAny, s.DeleteArticleForm)).Methods("GET")
+	r.HandleFunc("/{slug}/delete", s.require(permEditAny, s.DeleteArticle)).Methods("POST")
+	r.HandleFunc("/{slug}/edit", s.require(permWrite, s.EditArticleForm)).Methods("GET")
+	r.HandleFunc("/{slug}/edit", s.require(permWrite, s.EditArticle)).Methods("POST")
+	r.HandleFunc("/{slug}/previous-slugs/delete", s.require(permWrite, s.DeletePreviousSlug)).Methods("POST")
+	r.HandleFunc("/{slug}/revisions", s.require(permWrite, s.ArticleRevisions)).Methods("GET")
+	r.HandleFunc("/{slug}/revisions/{revision:[0-9]+}/restore", s.require(permWrite, s.RestoreRevision)).Methods("POST")
+
+	r.Use(s.checkCSRF)
 	s.Handler = r
 
 	return s
 }
 
-func (s *Server) MainIndexPage(w http.ResponseWriter, r *http.Request) {
-	articles, page, maxPage := s.store.getPage(getPageNumber(r), progCat)
-
-	indexPage(w, articles, progCat, page, maxPage, s.isAuth(r))
-}
-
-func (s *Server) OtherIndexPage(w http.ResponseWriter, r *http.Request) {
-	articles, page, maxPage := s.store.getPage(getPageNumber(r), otherCat)
-
-	indexPage(w, articles, otherCat, page, maxPage, s.isAuth(r))
-}
-
 func (s *Server) All(w http.ResponseWriter, r *http.Request) {
+	all, err := s.store.getAll()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	tags, err := s.store.getTagCounts()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	v := s.viewer(w, r)
 	w.WriteHeader(200)
 
 	// Get articles, then split them into columns.
-	articles := articlesWithoutTimes(s.store.getAll())
+	articles := articlesWithoutTimes(all)
 
 	// Reload HTML without rebuilding project.
 	if DEV {
@@ -101,155 +226,266 @@ func (s *Server) All(w http.ResponseWriter, r *http.Request) {
 	}
 	tmpl := indexTemplate
 	tmpl.Execute(w, struct {
-		Column1     []Article
-		Column2     []Article
-		Category    string
-		LoggedIn    bool
+		Column1  []Article
+		Column2  []Article
+		TagCloud []TagCloudEntry
+		Category string
+		Heading  string
+		Intro    string
+		Viewer
 		Dev         bool
 		Description string
-	}{articles[:len(articles)/2], articles[len(articles)/2:], "", s.isAuth(r), DEV, defaultDescription})
+	}{articles[:len(articles)/2], articles[len(articles)/2:], tagCloud(tags), "", "All Articles", "", v, DEV, defaultDescription})
 }
 
 func (s *Server) ArticleView(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	slug := vars["slug"]
-	id, article := s.store.getArticle(slug)
-	if id > 0 {
-		articleView(w, article, s.isAuth(r))
-	} else {
-		w.WriteHeader(404)
-		fmt.Fprint(w, "404 not found")
+	id, article, err := s.store.getArticle(slug)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if id == 0 {
+		s.redirectOldSlug(w, r, slug)
+		return
+	}
+	if !article.IsPublic() && !s.isAuth(r) {
+		notFound(w)
+		return
+	}
+	if article.DeletedAt != "" {
+		gone(w)
+		return
+	}
+	if article.Status != statusPublished {
+		w.Header().Set("X-Robots-Tag", "noindex")
 	}
+	articleView(w, article, s.highlighter.renderBody(article.Body), s.viewer(w, r))
 }
 
-func (s *Server) NewArticleForm(w http.ResponseWriter, r *http.Request) {
-	if s.isAuth(r) {
-		executeArticleForm(w, Article{}, template.HTMLAttr(""), "/new", s.isAuth(r))
-		return
-	} else {
-		w.WriteHeader(401)
+func (s *Server) HighlightCSS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	if err := s.highlighter.WriteCSS(w); err != nil {
+		log.Print(err)
 	}
 }
 
+func (s *Server) NewArticleForm(w http.ResponseWriter, r *http.Request) {
+	executeArticleForm(w, Article{}, template.HTMLAttr(""), "/new", s.viewer(w, r))
+}
+
 func (s *Server) NewArticle(w http.ResponseWriter, r *http.Request) {
-	if s.isAuth(r) {
-		a := getArticleFromForm(r)
-		a.Published = myTimeToString(time.Now().UTC())
-		a.Edited = a.Published
-
-		errors := s.ValidateArticle(a, true)
-		if len(errors) != 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			executeArticleForm(w, a, template.HTMLAttr("value=\""+a.Slug+"\""), "/new", s.isAuth(r), errors)
-			return
-		}
-		s.store.newArticle(a)
-		http.Redirect(w, r, "/all", http.StatusSeeOther)
-	} else {
-		w.WriteHeader(401)
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	a, err := getArticleFromForm(r)
+	if err != nil {
+		badRequest(w)
+		return
+	}
+	a.AuthorID = user.Id
+	a.EditedByID, a.EditedBy = user.Id, user.Username
+	setArticleTimes(&a, Article{}, s.clock.Now())
+
+	errors, err := s.ValidateArticle(a, true)
+	if err != nil {
+		s.articleWriteFailed(w, r, err, a, "/new")
+		return
+	}
+	if len(errors) != 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		executeArticleForm(w, a, template.HTMLAttr("value=\""+a.Slug+"\""), "/new", s.viewer(w, r), errors)
+		return
+	}
+	a, err = renderArticle(a)
+	if err != nil {
+		s.articleWriteFailed(w, r, err, a, "/new")
+		return
+	}
+	if err := s.store.newArticle(a); err != nil {
+		s.articleWriteFailed(w, r, err, a, "/new")
+		return
+	}
+	if a.Status == statusPublished {
+		s.notify(s.publishedEvent(a))
 	}
+	http.Redirect(w, r, "/all", http.StatusSeeOther)
 }
 
 func (s *Server) EditArticleForm(w http.ResponseWriter, r *http.Request) {
-	if s.isAuth(r) {
-		vars := mux.Vars(r)
-		slug := vars["slug"]
-		id, a := s.store.getArticle(slug)
-		if id > 0 {
-			executeArticleForm(w, a, template.HTMLAttr("value=\""+slug+"\""), "/"+slug+"/edit", s.isAuth(r))
-		} else {
-			w.WriteHeader(404)
-		}
+	vars := mux.Vars(r)
+	slug := vars["slug"]
+	id, a, err := s.store.getArticle(slug)
+	if err != nil {
+		serverError(w, err)
 		return
-	} else {
-		w.WriteHeader(401)
 	}
+	if id == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	if !s.checkCanEdit(w, r, a) {
+		return
+	}
+	a.PreviousSlugs, err = s.store.getSlugHistory(id)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	executeArticleForm(w, a, template.HTMLAttr("value=\""+slug+"\""), "/"+slug+"/edit", s.viewer(w, r))
 }
 
 func (s *Server) EditArticle(w http.ResponseWriter, r *http.Request) {
-	if s.isAuth(r) {
-		vars := mux.Vars(r)
-		slug := vars["slug"]
-		id, article := s.store.getArticle(slug)
-		if id == 0 {
-			w.WriteHeader(404)
-		} else {
-			edit := getArticleFromForm(r)
-			edit.Published = article.Published
-			edit.Edited = myTimeToString(time.Now().UTC())
-
-			errors := s.ValidateArticle(edit, false)
-			if len(errors) != 0 {
-				w.WriteHeader(http.StatusBadRequest)
-				executeArticleForm(w, edit, template.HTMLAttr("value=\""+edit.Slug+"\""), "/"+article.Slug+"/edit", s.isAuth(r), errors)
-				return
-			}
-			s.store.editArticle(id, edit)
-			http.Redirect(w, r, "/"+edit.Slug, http.StatusSeeOther)
-		}
-	} else {
-		w.WriteHeader(401)
+	vars := mux.Vars(r)
+	slug := vars["slug"]
+	id, article, err := s.store.getArticle(slug)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if id == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	if !s.checkCanEdit(w, r, article) {
+		return
+	}
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	edit, err := getArticleFromForm(r)
+	if err != nil {
+		badRequest(w)
+		return
+	}
+	edit.AuthorID = article.AuthorID
+	edit.EditedByID, edit.EditedBy = user.Id, user.Username
+	setArticleTimes(&edit, article, s.clock.Now())
+
+	errors, err := s.ValidateArticle(edit, false)
+	if err != nil {
+		s.articleWriteFailed(w, r, err, edit, "/"+article.Slug+"/edit")
+		return
+	}
+	if len(errors) != 0 {
+		v := s.viewer(w, r)
+		w.WriteHeader(http.StatusBadRequest)
+		executeArticleForm(w, edit, template.HTMLAttr("value=\""+edit.Slug+"\""), "/"+article.Slug+"/edit", v, errors)
+		return
 	}
+	edit, err = renderArticle(edit)
+	if err != nil {
+		s.articleWriteFailed(w, r, err, edit, "/"+article.Slug+"/edit")
+		return
+	}
+	if err := s.store.editArticle(id, edit); err != nil {
+		s.articleWriteFailed(w, r, err, edit, "/"+article.Slug+"/edit")
+		return
+	}
+	if edit.Status == statusPublished && article.Status != statusPublished {
+		s.notify(s.publishedEvent(edit))
+	}
+	http.Redirect(w, r, "/"+edit.Slug, http.StatusSeeOther)
 }
 
 func (s *Server) DeleteArticle(w http.ResponseWriter, r *http.Request) {
-	if s.isAuth(r) {
-		vars := mux.Vars(r)
-		slug := vars["slug"]
-		id, _ := s.store.getArticle(slug)
-		if id > 0 {
-			s.store.deleteArticle(id)
-		} else {
-			w.WriteHeader(404)
-			return
-		}
-		http.Redirect(w, r, "/admin", http.StatusSeeOther)
-	} else {
-		w.WriteHeader(401)
+	vars := mux.Vars(r)
+	slug := vars["slug"]
+	id, _, err := s.store.getArticle(slug)
+	if err != nil {
+		serverError(w, err)
+		return
 	}
+	if id == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	if err := s.store.trashArticle(id, myTimeToString(s.clock.Now().UTC())); err != nil {
+		serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
 func (s *Server) LoginPage(w http.ResponseWriter, r *http.Request) {
 	if s.isAuth(r) {
 		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
 	}
-	loginForm(w, nil, s.isAuth(r))
+	s.loginForm(w, r, http.StatusOK, nil)
 }
 
 func (s *Server) AdminLogin(w http.ResponseWriter, r *http.Request) {
 	session, _ := s.sessionStore.Get(r, "user")
 
-	err := r.ParseForm()
-	checkErr(err)
+	if err := r.ParseForm(); err != nil {
+		badRequest(w)
+		return
+	}
 
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	// Checked before the password, so a locked out guesser can't tell when they've got it right.
+	keys := loginKeys(r, username)
+	wait, err := s.loginWait(keys)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if wait > 0 {
+		s.loginForm(w, r, http.StatusTooManyRequests, []string{tooManyAttempts(w, wait)})
+		return
+	}
+
 	if errors := validateUserLogin(username, password); len(errors) != 0 {
-		go sendEmailToAdmin(r, false)
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		loginForm(w, errors, s.isAuth(r))
+		s.loginForm(w, r, http.StatusUnprocessableEntity, errors)
 		return
 	}
-	// Used db, now using env var.
-	// user, err := s.store.getUser(username)
-	// if err != nil {
-	if username != admin_username {
-		go sendEmailToAdmin(r, false)
-		w.WriteHeader(http.StatusUnauthorized)
-		loginForm(w, []string{loginFailed}, s.isAuth(r))
+	user, err := s.store.getUser(username)
+	if err != nil {
+		serverError(w, err)
 		return
 	}
-	user := User{}
+	// Unknown usernames go through the same check, so they fail the same way in the same time.
 	if !user.checkPassword(password) {
-		go sendEmailToAdmin(r, false)
-		w.WriteHeader(http.StatusUnauthorized)
-		loginForm(w, []string{loginFailed}, s.isAuth(r))
+		if err := s.loginFailed(keys); err != nil {
+			serverError(w, err)
+			return
+		}
+		s.notify(s.loginEvent(eventLoginFailed, r, username))
+		s.loginForm(w, r, http.StatusUnauthorized, []string{loginFailed})
 		return
 	}
 
-	go sendEmailToAdmin(r, true)
+	if user.TOTPSecret != "" {
+		s.startSession(w, r, session, Sesh{Name: user.Username, AwaitingCode: true}, "/admin/login/code")
+		return
+	}
+
+	if err := s.limiter.reset("user:" + user.Username); err != nil {
+		serverError(w, err)
+		return
+	}
+	s.notify(s.loginEvent(eventLoginSucceeded, r, user.Username))
+	s.startSession(w, r, session, Sesh{Name: user.Username, Authenticated: true}, "/admin")
+}
 
-	newSesh := Sesh{name: user.Username, Authenticated: true}
+// Saves newSesh to the session with a new CSRF token, so one seen before logging in is no use after.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, session *sessions.Session, newSesh Sesh, next string) {
+	token, err := newCSRFToken()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	newSesh.CSRFToken = token
+	// And a new ID where the store keeps one, for the same reason.
+	s.sessionStore.SetOption(session, "NewID", nil)
 	s.sessionStore.Set(session, newSesh)
 
 	err = s.sessionStore.SaveSession(r, w, session)
@@ -258,7 +494,7 @@ func (s *Server) AdminLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	http.Redirect(w, r, next, http.StatusSeeOther)
 }
 
 func (s *Server) AdminLogout(w http.ResponseWriter, r *http.Request) {
@@ -275,10 +511,40 @@ func (s *Server) AdminLogout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// Lists the articles the user can edit.
 func (s *Server) AdminPanel(w http.ResponseWriter, r *http.Request) {
-	if s.isAuth(r) {
-		adminPanel(w, articlesWithoutTimes(s.store.getAll()), true)
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	unpublished, err := s.store.getUnpublished()
+	if err != nil {
+		serverError(w, err)
 		return
 	}
-	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+	published, err := s.store.getAll()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	var articles []Article
+	for _, a := range append(unpublished, published...) {
+		if user.canEdit(a) {
+			articles = append(articles, a)
+		}
+	}
+	adminPanel(w, articlesWithoutTimes(articles), user, s.viewer(w, r))
+}
+
+// Shows the form again with whatever was submitted, so a failed write doesn't lose the user's work.
+func (s *Server) articleWriteFailed(w http.ResponseWriter, r *http.Request, err error, a Article, formAction string) {
+	status, message := http.StatusInternalServerError, errSaveFailed
+	if errors.Is(err, errSlugTaken) {
+		status, message = http.StatusConflict, errSlugAlreadyExists
+	} else {
+		log.Print(err)
+	}
+	w.WriteHeader(status)
+	executeArticleForm(w, a, template.HTMLAttr("value=\""+a.Slug+"\""), formAction, s.viewer(w, r), []string{message})
 }
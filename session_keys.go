@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Session cookies are signed with an auth key and encrypted with an encryption key.
+// Both are kept as base64 pairs, one pair per line, "<auth> <encryption>". The first pair
+// makes new cookies, the rest only read old ones, so keys can be rotated without logging
+// everyone out: put a new pair first and drop the oldest once its cookies have expired.
+// Blank lines and lines starting with # are skipped.
+const (
+	sessionAuthKeyLen       = 64
+	sessionEncryptionKeyLen = 32
+)
+
+// The key pairs from the blog_session_keys variable, pairs separated by commas, or else
+// from the file at blog_session_keys_file. Nil if neither is set.
+func sessionKeysFromEnv() ([][]byte, error) {
+	if keys := os.Getenv("blog_session_keys"); keys != "" {
+		pairs, err := parseSessionKeys(strings.ReplaceAll(keys, ",", "\n"))
+		if err != nil {
+			return nil, fmt.Errorf("blog_session_keys: %w", err)
+		}
+		return pairs, nil
+	}
+	if path := os.Getenv("blog_session_keys_file"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pairs, err := parseSessionKeys(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return pairs, nil
+	}
+	return nil, nil
+}
+
+// Returns the keys flattened, ready for sessions.NewCookieStore.
+func parseSessionKeys(text string) ([][]byte, error) {
+	var pairs [][]byte
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want an auth key and an encryption key", i+1)
+		}
+		auth, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil || (len(auth) != 32 && len(auth) != 64) {
+			return nil, fmt.Errorf("line %d: auth key must be 32 or 64 bytes of base64", i+1)
+		}
+		enc, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || (len(enc) != 16 && len(enc) != 24 && len(enc) != 32) {
+			return nil, fmt.Errorf("line %d: encryption key must be 16, 24 or 32 bytes of base64", i+1)
+		}
+		pairs = append(pairs, auth, enc)
+	}
+	if len(pairs) == 0 {
+		return nil, errors.New("no keys")
+	}
+	return pairs, nil
+}
+
+// A new random pair as a line of a keys file.
+func newSessionKeyLine() string {
+	return base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(sessionAuthKeyLen)) + " " +
+		base64.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(sessionEncryptionKeyLen))
+}
+
+// The keygen command. With no file, prints a new pair. With a file, puts a new pair
+// first in it, keeping the old ones after it so existing sessions still work.
+//
+//	blog keygen [file]
+func keygen(args []string, out io.Writer) error {
+	if len(args) > 1 {
+		return errors.New("usage: keygen [file]")
+	}
+	line := newSessionKeyLine()
+	if len(args) == 0 {
+		_, err := fmt.Fprintln(out, line)
+		return err
+	}
+
+	path := args[0]
+	old, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(old) != 0 {
+		if _, err := parseSessionKeys(string(old)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := os.WriteFile(path, append([]byte(line+"\n"), old...), 0600); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "Added a new session key pair to %s\n", path)
+	return err
+}
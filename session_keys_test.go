@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustParseSessionKeys(t *testing.T, text string) [][]byte {
+	t.Helper()
+	pairs, err := parseSessionKeys(text)
+	assertNoError(t, err)
+	return pairs
+}
+
+func TestParseSessionKeys(t *testing.T) {
+	first, second := newSessionKeyLine(), newSessionKeyLine()
+	pairs := mustParseSessionKeys(t, "# keys\n"+first+"\n\n  "+second+"  \n")
+	assertInt(t, len(pairs), 4)
+	assertInt(t, len(pairs[0]), sessionAuthKeyLen)
+	assertInt(t, len(pairs[1]), sessionEncryptionKeyLen)
+
+	auth, enc, _ := strings.Cut(first, " ")
+	for _, bad := range []string{
+		"",
+		"# only a comment",
+		auth,
+		auth + " " + enc + " extra",
+		"not-base64! " + enc,
+		auth + " " + auth,
+		enc + " " + auth,
+	} {
+		if _, err := parseSessionKeys(bad); err == nil {
+			t.Errorf("got no error for %q", bad)
+		}
+	}
+}
+
+// Saves a session with store and returns the cookie header for it.
+func sessionCookie(t *testing.T, store *MemorySessionStore, sesh Sesh) string {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/", nil)
+	session, _ := store.Get(req, "admin-session")
+	store.Set(session, sesh)
+	resp := httptest.NewRecorder()
+	assertNoError(t, store.SaveSession(req, resp, session))
+	return resp.Header().Get("Set-Cookie")
+}
+
+func readSessionCookie(store *MemorySessionStore, cookie string) (Sesh, error) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Cookie", cookie)
+	session, err := store.Get(req, "admin-session")
+	return store.getSesh(session), err
+}
+
+func TestSessionKeyRotation(t *testing.T) {
+	oldLine, newLine := newSessionKeyLine(), newSessionKeyLine()
+	sesh := Sesh{Name: "admin", Authenticated: true}
+
+	oldStore := NewMemorySessionStore(mustParseSessionKeys(t, oldLine)...)
+	cookie := sessionCookie(t, oldStore, sesh)
+
+	t.Run("the same keys read cookies after a restart", func(t *testing.T) {
+		got, err := readSessionCookie(NewMemorySessionStore(mustParseSessionKeys(t, oldLine)...), cookie)
+		assertNoError(t, err)
+		if got.Name != sesh.Name || !got.Authenticated {
+			t.Errorf("got %+v, want %+v", got, sesh)
+		}
+	})
+
+	t.Run("old keys still read cookies after rotating", func(t *testing.T) {
+		rotated := NewMemorySessionStore(mustParseSessionKeys(t, newLine+"\n"+oldLine)...)
+		got, err := readSessionCookie(rotated, cookie)
+		assertNoError(t, err)
+		assertLoggedIn(t, got)
+
+		// New cookies are made with the new keys.
+		fresh := sessionCookie(t, rotated, sesh)
+		got, err = readSessionCookie(NewMemorySessionStore(mustParseSessionKeys(t, newLine)...), fresh)
+		assertNoError(t, err)
+		assertLoggedIn(t, got)
+	})
+
+	t.Run("dropped keys do not", func(t *testing.T) {
+		got, err := readSessionCookie(NewMemorySessionStore(mustParseSessionKeys(t, newLine)...), cookie)
+		if err == nil {
+			t.Error("want an error decoding with the wrong keys")
+		}
+		if got.Authenticated {
+			t.Error("want a logged out session")
+		}
+	})
+
+	t.Run("random keys when none are given", func(t *testing.T) {
+		got, _ := readSessionCookie(NewMemorySessionStore(), cookie)
+		if got.Authenticated {
+			t.Error("want a logged out session")
+		}
+	})
+}
+
+func assertLoggedIn(t *testing.T, sesh Sesh) {
+	t.Helper()
+	if !sesh.Authenticated {
+		t.Errorf("got %+v, want logged in", sesh)
+	}
+}
+
+func TestKeygen(t *testing.T) {
+	t.Run("prints a pair", func(t *testing.T) {
+		var out bytes.Buffer
+		assertNoError(t, keygen(nil, &out))
+		assertInt(t, len(mustParseSessionKeys(t, out.String())), 2)
+	})
+
+	t.Run("rotates a file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "session.keys")
+		var out bytes.Buffer
+		assertNoError(t, keygen([]string{path}, &out))
+		assertContains(t, out.String(), path)
+		first, err := os.ReadFile(path)
+		assertNoError(t, err)
+
+		assertNoError(t, keygen([]string{path}, &out))
+		data, err := os.ReadFile(path)
+		assertNoError(t, err)
+		pairs := mustParseSessionKeys(t, string(data))
+		assertInt(t, len(pairs), 4)
+		if !strings.HasSuffix(string(data), string(first)) {
+			t.Error("want the old pair kept after the new one")
+		}
+
+		info, err := os.Stat(path)
+		assertNoError(t, err)
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("got mode %v, want 0600", info.Mode().Perm())
+		}
+	})
+
+	t.Run("leaves a bad file alone", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "session.keys")
+		assertNoError(t, os.WriteFile(path, []byte("nonsense\n"), 0600))
+		if err := keygen([]string{path}, &bytes.Buffer{}); err == nil {
+			t.Error("want an error")
+		}
+		data, _ := os.ReadFile(path)
+		if string(data) != "nonsense\n" {
+			t.Errorf("got %q, want the file unchanged", data)
+		}
+	})
+}
+
+func TestSessionKeysFromEnv(t *testing.T) {
+	first, second := newSessionKeyLine(), newSessionKeyLine()
+
+	t.Setenv("blog_session_keys", "")
+	t.Setenv("blog_session_keys_file", "")
+	pairs, err := sessionKeysFromEnv()
+	assertNoError(t, err)
+	if pairs != nil {
+		t.Errorf("got %d keys, want none", len(pairs))
+	}
+
+	path := filepath.Join(t.TempDir(), "session.keys")
+	assertNoError(t, os.WriteFile(path, []byte(first+"\n"), 0600))
+	t.Setenv("blog_session_keys_file", path)
+	pairs, err = sessionKeysFromEnv()
+	assertNoError(t, err)
+	assertInt(t, len(pairs), 2)
+
+	t.Setenv("blog_session_keys", first+","+second)
+	pairs, err = sessionKeysFromEnv()
+	assertNoError(t, err)
+	assertInt(t, len(pairs), 4)
+
+	t.Setenv("blog_session_keys", "nonsense")
+	if _, err := sessionKeysFromEnv(); err == nil {
+		t.Error("want an error for bad keys")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Session stores that keep sessions on the server, so they can be listed and revoked.
+// MemorySessionStore keeps them in the cookie, so it can't.
+type serverSessions interface {
+	listSessions(now time.Time) ([]sessionRecord, error)
+	// key is a sessionRecord's Key.
+	revokeSession(key string) error
+	// Leaves the session with key keep logged in, if it's one of theirs. keep can be empty.
+	revokeUserSessions(username, keep string) (int, error)
+	deleteExpired(now time.Time) (int, error)
+}
+
+type sessionRecord struct {
+	// Identifies the session without being usable as its cookie.
+	Key           string
+	Username      string
+	Authenticated bool
+	IP            string
+	UserAgent     string
+	Created       time.Time
+	LastSeen      time.Time
+	Expires       time.Time
+}
+
+// A row of the sessions page.
+type sessionRow struct {
+	sessionRecord
+	// The session the page was asked for with.
+	Current bool
+}
+
+// Lists logged in sessions, so they can be revoked.
+func (s *Server) AdminSessions(w http.ResponseWriter, r *http.Request) {
+	var rows []sessionRow
+	ss, kept := s.sessionStore.(serverSessions)
+	if kept {
+		records, err := ss.listSessions(s.clock.Now())
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		session, _ := s.sessionStore.Get(r, "user")
+		for _, rec := range records {
+			rows = append(rows, sessionRow{rec, session != nil && session.ID != "" && rec.Key == sessionKey(session.ID)})
+		}
+	}
+
+	v := s.viewer(w, r)
+	if DEV {
+		sessionsTemplate = setSessionsTemplate()
+	}
+	tmpl := sessionsTemplate
+	tmpl.Execute(w, struct {
+		Sessions []sessionRow
+		// False when sessions are only in cookies.
+		Kept     bool
+		Username string
+		Viewer
+		Dev         bool
+		Description string
+	}{rows, kept, s.username(r), v, DEV, defaultDescription})
+}
+
+// Logs out the session with the key field.
+func (s *Server) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if ss, ok := s.sessionStore.(serverSessions); ok {
+		if err := ss.revokeSession(r.FormValue("key")); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
+
+// Logs out every session of the username field, wherever they are.
+func (s *Server) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	if err := s.logOutEverywhere(r, r.FormValue("username"), false); err != nil {
+		serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
+
+// Logs out username everywhere, except for the session r was made with if keepCurrent.
+// Does nothing when sessions are only in cookies.
+func (s *Server) logOutEverywhere(r *http.Request, username string, keepCurrent bool) error {
+	ss, ok := s.sessionStore.(serverSessions)
+	if !ok {
+		return nil
+	}
+	keep := ""
+	if keepCurrent {
+		if session, _ := s.sessionStore.Get(r, "user"); session != nil && session.ID != "" {
+			keep = sessionKey(session.ID)
+		}
+	}
+	n, err := ss.revokeUserSessions(username, keep)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Logged out %d session(s) of %s", n, username)
+	}
+	return nil
+}
+
+func setSessionsTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/sessions.html"))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The sitemap protocol allows at most 50,000 urls per file. Past that, /sitemap.xml becomes an index of smaller sitemaps.
+var sitemapLimit = 50000
+
+// Paths crawlers should stay out of.
+var robotsDisallow = []string{
+	"/admin",
+	"/admin/*",
+	"/*/edit",
+	"/*/delete",
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+func (s *Server) Robots(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, path := range robotsDisallow {
+		b.WriteString("Disallow: " + path + "\n")
+	}
+	b.WriteString("\nSitemap: " + siteURL + "/sitemap.xml\n")
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
+
+// Serves every url in one sitemap, or an index of the numbered parts when there are too many.
+func (s *Server) Sitemap(w http.ResponseWriter, r *http.Request) {
+	urls, err := s.sitemapURLs()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	if len(urls) <= sitemapLimit {
+		writeXML(w, urlSet{URLs: urls})
+		return
+	}
+
+	index := sitemapIndex{}
+	for part := 1; (part-1)*sitemapLimit < len(urls); part++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: siteURL + "/sitemap-" + strconv.Itoa(part) + ".xml"})
+	}
+	writeXML(w, index)
+}
+
+func (s *Server) SitemapPart(w http.ResponseWriter, r *http.Request) {
+	part, err := strconv.Atoi(mux.Vars(r)["part"])
+	if err != nil {
+		notFound(w)
+		return
+	}
+
+	urls, err := s.sitemapURLs()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	start := (part - 1) * sitemapLimit
+	if part < 1 || start >= len(urls) || len(urls) <= sitemapLimit {
+		notFound(w)
+		return
+	}
+	end := start + sitemapLimit
+	if end > len(urls) {
+		end = len(urls)
+	}
+	writeXML(w, urlSet{URLs: urls[start:end]})
+}
+
+// Index pages, category pages and their later pages, then every article.
+func (s *Server) sitemapURLs() ([]sitemapURL, error) {
+	articles, err := s.store.getAll()
+	if err != nil {
+		return nil, err
+	}
+	categories, err := s.store.getCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	urls := []sitemapURL{{Loc: siteURL + "/all"}}
+
+	for _, c := range categories {
+		count := 0
+		var lastMod time.Time
+		for _, a := range articles {
+			if a.Category == c.Name {
+				count++
+				edited, err := myStringToTime(a.Edited)
+				if err != nil {
+					return nil, err
+				}
+				if edited.After(lastMod) {
+					lastMod = edited
+				}
+			}
+		}
+
+		basePath := c.Path()
+		first := sitemapURL{Loc: siteURL + basePath}
+		if count > 0 {
+			first.LastMod = lastMod.Format(time.RFC3339)
+		}
+		urls = append(urls, first)
+
+		p := Pagination{Page: 1, PerPage: defaultPerPage, Total: count}
+		for page := 2; page <= p.MaxPage(); page++ {
+			urls = append(urls, sitemapURL{Loc: siteURL + pageURL(basePath, page, defaultPerPage)})
+		}
+	}
+
+	for _, a := range articles {
+		edited, err := myStringToTime(a.Edited)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, sitemapURL{Loc: siteURL + "/" + a.Slug, LastMod: edited.Format(time.RFC3339)})
+	}
+	return urls, nil
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	w.Write(out)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSitemap(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+
+	progArticles := MakeArticlesOfCategory(25, time.Now().UTC(), progCat)
+	otherArticles := MakeArticlesOfCategory(3, time.Now().UTC(), otherCat)
+	edited := progArticles[0]
+	edited.Edited = myTimeToString(time.Now().UTC().Add(time.Hour))
+	progArticles[0] = edited
+
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, append(progArticles, otherArticles...), []User{})
+	defer closeDB()
+	server := NewServer(store, &StubSessionStore{})
+
+	getURLs := func(t *testing.T, path string) map[string]string {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, path))
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Header().Get("Content-Type"), "application/xml")
+
+		var set urlSet
+		assertNoError(t, xml.Unmarshal(resp.Body.Bytes(), &set))
+		ret := map[string]string{}
+		for _, u := range set.URLs {
+			ret[u.Loc] = u.LastMod
+		}
+		return ret
+	}
+
+	t.Run("robots.txt", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/robots.txt"))
+
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Header().Get("Content-Type"), "text/plain")
+		assertContains(t, resp.Body.String(), "User-agent: *\nDisallow: /admin\n")
+		assertContains(t, resp.Body.String(), "Disallow: /*/edit\n")
+		assertContains(t, resp.Body.String(), "Sitemap: "+siteURL+"/sitemap.xml\n")
+	})
+
+	t.Run("lists index, category, paginated and article pages", func(t *testing.T) {
+		urls := getURLs(t, "/sitemap.xml")
+
+		for _, path := range []string{"/", "/all", "/other", "/page/2", "/page/3"} {
+			if _, ok := urls[siteURL+path]; !ok {
+				t.Errorf("sitemap is missing %s", path)
+			}
+		}
+		for _, path := range []string{"/page/4", "/other/page/2"} {
+			if _, ok := urls[siteURL+path]; ok {
+				t.Errorf("sitemap has a page that doesn't exist, %s", path)
+			}
+		}
+
+		for _, a := range append(progArticles, otherArticles...) {
+			lastMod, ok := urls[siteURL+"/"+a.Slug]
+			if !ok {
+				t.Errorf("sitemap is missing article %s", a.Slug)
+				continue
+			}
+			if lastMod != mustStringToTime(t, a.Edited).Format(time.RFC3339) {
+				t.Errorf("article %s, got lastmod %s, want %s", a.Slug, lastMod, a.Edited)
+			}
+		}
+
+		// Category pages change when their newest edit does.
+		if urls[siteURL+"/"] != mustStringToTime(t, edited.Edited).Format(time.RFC3339) {
+			t.Errorf("got lastmod %s for /, want %s", urls[siteURL+"/"], edited.Edited)
+		}
+	})
+
+	t.Run("splits into an index past the limit", func(t *testing.T) {
+		sitemapLimit = 10
+		defer func() { sitemapLimit = 50000 }()
+
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/sitemap.xml"))
+		assertStatus(t, resp.Code, 200)
+
+		var index sitemapIndex
+		assertNoError(t, xml.Unmarshal(resp.Body.Bytes(), &index))
+		// 3 index pages, 2 more pages of Programming and 28 articles.
+		assertInt(t, len(index.Sitemaps), 4)
+
+		seen := map[string]bool{}
+		for _, part := range index.Sitemaps {
+			urls := getURLs(t, part.Loc[len(siteURL):])
+			if len(urls) > sitemapLimit {
+				t.Errorf("%s has %d urls, limit is %d", part.Loc, len(urls), sitemapLimit)
+			}
+			for loc := range urls {
+				seen[loc] = true
+			}
+		}
+		assertInt(t, len(seen), 33)
+
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/sitemap-5.xml"))
+		assertStatus(t, resp.Code, 404)
+	})
+
+	t.Run("no parts while under the limit", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/sitemap-1.xml"))
+		assertStatus(t, resp.Code, 404)
+	})
+}
+
+func TestSitemapSlugsReserved(t *testing.T) {
+	for _, slug := range []string{"robots.txt", "sitemap.xml", "sitemap-2.xml"} {
+		assertArticleSlugReserved(t, slug)
+	}
+}
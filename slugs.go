@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Sends requests for a slug an article used to have on to its current slug.
+// Only when the viewer could see the article, so its new slug isn't given away before it's published.
+func (s *Server) redirectOldSlug(w http.ResponseWriter, r *http.Request, slug string) {
+	current, err := s.store.getRedirect(slug)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if current == "" {
+		notFound(w)
+		return
+	}
+	_, article, err := s.store.getArticle(current)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if (!article.IsPublic() || article.DeletedAt != "") && !s.isAuth(r) {
+		notFound(w)
+		return
+	}
+	http.Redirect(w, r, "/"+current, http.StatusMovedPermanently)
+}
+
+// Stops one of an article's old slugs redirecting to it, freeing it up for other articles.
+func (s *Server) DeletePreviousSlug(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+	id, article, err := s.store.getArticle(slug)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if id == 0 {
+		notFound(w)
+		return
+	}
+	if !s.checkCanEdit(w, r, article) {
+		return
+	}
+	if err := s.store.deleteSlugHistory(id, r.FormValue("previous")); err != nil {
+		serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/"+slug+"/edit", http.StatusSeeOther)
+}
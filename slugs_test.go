@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileSystemStoreSlugHistory(t *testing.T) {
+	articles := MakeArticlesOfCategory(1, time.Now().UTC(), progCat)
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+	defer closeDB()
+
+	id, article, err := store.getArticle(articles[0].Slug)
+	assertNoError(t, err)
+
+	rename := func(t *testing.T, slug string) {
+		t.Helper()
+		article.Slug = slug
+		assertNoError(t, store.editArticle(id, article))
+	}
+	assertRedirect := func(t *testing.T, from, want string) {
+		t.Helper()
+		got, err := store.getRedirect(from)
+		assertNoError(t, err)
+		if got != want {
+			t.Errorf("got %q redirecting to %q, want %q", from, got, want)
+		}
+	}
+	assertHistory := func(t *testing.T, want []string) {
+		t.Helper()
+		got, err := store.getSlugHistory(id)
+		assertNoError(t, err)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got history %v, want %v", got, want)
+		}
+	}
+
+	first := article.Slug
+
+	t.Run("editing without renaming keeps no history", func(t *testing.T) {
+		rename(t, first)
+		assertHistory(t, nil)
+	})
+
+	t.Run("chains of renames all go to the current slug", func(t *testing.T) {
+		rename(t, "second")
+		rename(t, "third")
+		assertRedirect(t, first, "third")
+		assertRedirect(t, "second", "third")
+		assertRedirect(t, "third", "")
+		assertHistory(t, []string{"second", first})
+	})
+
+	t.Run("renaming back to an old slug doesn't loop", func(t *testing.T) {
+		rename(t, first)
+		assertRedirect(t, first, "")
+		assertRedirect(t, "third", first)
+		assertHistory(t, []string{"third", "second"})
+	})
+
+	t.Run("new articles take over old slugs", func(t *testing.T) {
+		a := validArticleBase
+		a.Slug = "second"
+		a.Category = progCat
+		assertNoError(t, store.newArticle(a))
+		assertRedirect(t, "second", "")
+		assertHistory(t, []string{"third"})
+	})
+
+	t.Run("removing a previous slug", func(t *testing.T) {
+		assertNoError(t, store.deleteSlugHistory(id, "third"))
+		assertRedirect(t, "third", "")
+		assertHistory(t, nil)
+	})
+}
+
+func TestSlugRedirectRoutes(t *testing.T) {
+	articles := MakeArticlesOfCategory(2, time.Now().UTC(), progCat)
+	article := articles[0]
+	oldSlug := article.Slug
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{admin})
+	defer closeDB()
+	sessStore := StubSessionStore{}
+	server := NewServer(store, &sessStore)
+
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, path))
+		return resp
+	}
+	post := func(t *testing.T, path string, data url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, path, data))
+		return resp
+	}
+
+	sessStore.sesh = Sesh{Name: admin.Username, Authenticated: true}
+	article.Slug = "renamed"
+	assertStatus(t, post(t, "/"+oldSlug+"/edit", setDataValues(article)).Code, 303)
+
+	t.Run("old slug redirects permanently", func(t *testing.T) {
+		resp := get(t, "/"+oldSlug)
+		assertStatus(t, resp.Code, 301)
+		if got := resp.Header().Get("Location"); got != "/renamed" {
+			t.Errorf("got Location %q, want /renamed", got)
+		}
+	})
+
+	t.Run("edit form lists previous URLs", func(t *testing.T) {
+		resp := get(t, "/renamed/edit")
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Body.String(), `name="previous" value="`+oldSlug+`"`)
+	})
+
+	t.Run("removing a previous URL needs a login", func(t *testing.T) {
+		sessStore.sesh.Authenticated = false
+		defer func() { sessStore.sesh.Authenticated = true }()
+		assertStatus(t, post(t, "/renamed/previous-slugs/delete", url.Values{"previous": {oldSlug}}).Code, 401)
+	})
+
+	t.Run("only redirects visitors to articles they can see", func(t *testing.T) {
+		draft := articles[1]
+		draft.Status = statusDraft
+		draft.Slug = "secret-new-slug"
+		assertStatus(t, post(t, "/"+articles[1].Slug+"/edit", setDataValues(draft)).Code, 303)
+		assertStatus(t, get(t, "/"+articles[1].Slug).Code, 301)
+
+		sessStore.sesh.Authenticated = false
+		defer func() { sessStore.sesh.Authenticated = true }()
+		resp := get(t, "/"+articles[1].Slug)
+		assertStatus(t, resp.Code, 404)
+		assertNotContain(t, resp.Header().Get("Location"), draft.Slug)
+		assertNotContain(t, resp.Body.String(), draft.Slug)
+	})
+
+	t.Run("removed previous URLs 404", func(t *testing.T) {
+		assertStatus(t, post(t, "/renamed/previous-slugs/delete", url.Values{"previous": {oldSlug}}).Code, 303)
+		assertStatus(t, get(t, "/"+oldSlug).Code, 404)
+	})
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Last-seen times are only written when they're at least this old, so every request isn't a write.
+const sessionTouchInterval = time.Minute
+
+var errSessionRevoked = errors.New("session was revoked")
+
+// Keeps sessions in the Sessions table, with only a random ID in the cookie, so they can be
+// listed and revoked before they expire. Used when blog_session_store is sqlite.
+// Until login succeeds the cookie holds the Sesh itself, so loading the login page
+// doesn't add a row.
+type SQLiteSessionStore struct {
+	db      *sql.DB
+	codecs  []securecookie.Codec
+	options *sessions.Options
+	clock   Clock
+}
+
+// keyPairs sign and encrypt the ID cookie, the same as for NewMemorySessionStore.
+func NewSQLiteSessionStore(db *sql.DB, keyPairs ...[]byte) *SQLiteSessionStore {
+	if len(keyPairs) == 0 {
+		keyPairs = [][]byte{
+			securecookie.GenerateRandomKey(sessionAuthKeyLen),
+			securecookie.GenerateRandomKey(sessionEncryptionKeyLen),
+		}
+	}
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, c := range codecs {
+		if sc, ok := c.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(sessionMaxAge)
+		}
+	}
+	return &SQLiteSessionStore{db: db, codecs: codecs, options: newSessionOptions(), clock: realClock{}}
+}
+
+// The ID column for a session ID.
+func sessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (st *SQLiteSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(st, name)
+}
+
+// For sessions.Store. Revoked and expired sessions come back new and empty, like a missing cookie.
+func (st *SQLiteSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(st, name)
+	opts := *st.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, c.Value, &id, st.codecs...); err != nil {
+		var sesh Sesh
+		if securecookie.DecodeMulti(name, c.Value, &sesh, st.codecs...) != nil {
+			return session, err
+		}
+		session.Values["user"] = sesh
+		return session, nil
+	}
+
+	now := st.clock.Now().UTC()
+	var data []byte
+	var lastSeen string
+	err = st.db.QueryRow("SELECT Data, LastSeen FROM Sessions WHERE ID = ? AND Expires > ?", sessionKey(id), myTimeToString(now)).Scan(&data, &lastSeen)
+	if err == sql.ErrNoRows {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	var sesh Sesh
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&sesh); err != nil {
+		return session, err
+	}
+	session.ID = id
+	session.Values["user"] = sesh
+	session.IsNew = false
+
+	seen, err := myStringToTime(lastSeen)
+	if err != nil {
+		return session, err
+	}
+	if now.Sub(seen) >= sessionTouchInterval {
+		_, err := st.db.Exec("UPDATE Sessions SET LastSeen = ?, IP = ?, UserAgent = ? WHERE ID = ?",
+			myTimeToString(now), clientIP(r), r.UserAgent(), sessionKey(id))
+		if err != nil {
+			log.Print(err)
+		}
+	}
+	return session, nil
+}
+
+// For sessions.Store. A negative MaxAge deletes the session.
+func (st *SQLiteSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := st.revokeSession(sessionKey(session.ID)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	sesh := st.getSesh(session)
+	if !sesh.Authenticated {
+		if session.ID != "" {
+			if err := st.revokeSession(sessionKey(session.ID)); err != nil {
+				return err
+			}
+			session.ID = ""
+		}
+		session.IsNew = true
+		encoded, err := securecookie.EncodeMulti(session.Name(), sesh, st.codecs...)
+		if err != nil {
+			return err
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+		return nil
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(sesh); err != nil {
+		return err
+	}
+	now := st.clock.Now().UTC()
+	expires := now.Add(time.Duration(session.Options.MaxAge) * time.Second)
+
+	if session.IsNew || session.ID == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		_, err = st.db.Exec(`INSERT INTO Sessions (ID, Username, Authenticated, IP, UserAgent, Data, Created, LastSeen, Expires)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			sessionKey(id), sesh.Name, sesh.Authenticated, clientIP(r), r.UserAgent(), data.Bytes(),
+			myTimeToString(now), myTimeToString(now), myTimeToString(expires))
+		if err != nil {
+			return err
+		}
+		session.ID = id
+		session.IsNew = false
+	} else {
+		// Only updated, so a request that was running when its session was revoked can't bring it back.
+		res, err := st.db.Exec(`UPDATE Sessions SET Username = ?, Authenticated = ?, IP = ?, UserAgent = ?, Data = ?, LastSeen = ?, Expires = ?
+			WHERE ID = ?`,
+			sesh.Name, sesh.Authenticated, clientIP(r), r.UserAgent(), data.Bytes(),
+			myTimeToString(now), myTimeToString(expires), sessionKey(session.ID))
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return errSessionRevoked
+		}
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, st.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (st *SQLiteSessionStore) Set(session *sessions.Session, newSesh Sesh) {
+	session.Values["user"] = newSesh
+}
+
+func (st *SQLiteSessionStore) SaveSession(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	return s.Save(r, w)
+}
+
+func (st *SQLiteSessionStore) getSesh(s *sessions.Session) Sesh {
+	sesh, ok := s.Values["user"].(Sesh)
+	if !ok {
+		return Sesh{Authenticated: false}
+	}
+	return sesh
+}
+
+// As well as MaxAge, takes "NewID", which deletes the session's record so the next save
+// makes a new one with a new ID.
+func (st *SQLiteSessionStore) SetOption(s *sessions.Session, o string, v interface{}) {
+	switch o {
+	case "MaxAge":
+		s.Options.MaxAge = v.(int)
+	case "NewID":
+		if s.ID != "" {
+			if err := st.revokeSession(sessionKey(s.ID)); err != nil {
+				log.Print(err)
+			}
+		}
+		s.ID = ""
+		s.IsNew = true
+	}
+}
+
+// Sessions of users who have logged in, most recently seen first.
+func (st *SQLiteSessionStore) listSessions(now time.Time) ([]sessionRecord, error) {
+	rows, err := st.db.Query(`SELECT ID, Username, Authenticated, IP, UserAgent, Created, LastSeen, Expires FROM Sessions
+		WHERE Authenticated = 1 AND Expires > ? ORDER BY LastSeen DESC, Created DESC`, myTimeToString(now.UTC()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []sessionRecord
+	for rows.Next() {
+		var rec sessionRecord
+		var created, lastSeen, expires string
+		if err := rows.Scan(&rec.Key, &rec.Username, &rec.Authenticated, &rec.IP, &rec.UserAgent, &created, &lastSeen, &expires); err != nil {
+			return nil, err
+		}
+		var err error
+		if rec.Created, err = myStringToTime(created); err != nil {
+			return nil, err
+		}
+		if rec.LastSeen, err = myStringToTime(lastSeen); err != nil {
+			return nil, err
+		}
+		if rec.Expires, err = myStringToTime(expires); err != nil {
+			return nil, err
+		}
+		ret = append(ret, rec)
+	}
+	return ret, rows.Err()
+}
+
+func (st *SQLiteSessionStore) revokeSession(key string) error {
+	_, err := st.db.Exec("DELETE FROM Sessions WHERE ID = ?", key)
+	return err
+}
+
+func (st *SQLiteSessionStore) revokeUserSessions(username, keep string) (int, error) {
+	res, err := st.db.Exec("DELETE FROM Sessions WHERE Username = ? AND ID != ?", username, keep)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (st *SQLiteSessionStore) deleteExpired(now time.Time) (int, error) {
+	res, err := st.db.Exec("DELETE FROM Sessions WHERE Expires <= ?", myTimeToString(now.UTC()))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
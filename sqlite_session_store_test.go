@@ -0,0 +1,320 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSQLiteSessionTestStore(t *testing.T, users []User) (*FileSystemStore, func()) {
+	t.Helper()
+	tmpFile, cleanTempFile := makeTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, users)
+	return store, func() {
+		closeDB()
+		cleanTempFile()
+	}
+}
+
+// A request from a browser with the cookie header, if there is one.
+func sessionRequest(cookie string) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", "TestBrowser/1.0")
+	if cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+	return req
+}
+
+func TestSQLiteSessionStore(t *testing.T) {
+	store, cleanup := newSQLiteSessionTestStore(t, nil)
+	defer cleanup()
+	clock := newFakeClock()
+	keys := mustParseSessionKeys(t, newSessionKeyLine())
+	sessStore := NewSQLiteSessionStore(store.db, keys...)
+	sessStore.clock = clock
+
+	save := func(t *testing.T, cookie string, sesh Sesh) string {
+		t.Helper()
+		req := sessionRequest(cookie)
+		session, _ := sessStore.Get(req, "user")
+		sessStore.Set(session, sesh)
+		resp := httptest.NewRecorder()
+		assertNoError(t, sessStore.SaveSession(req, resp, session))
+		if set := resp.Header().Get("Set-Cookie"); set != "" {
+			return set
+		}
+		return cookie
+	}
+	load := func(cookie string) Sesh {
+		session, _ := sessStore.Get(sessionRequest(cookie), "user")
+		return sessStore.getSesh(session)
+	}
+	list := func(t *testing.T) []sessionRecord {
+		t.Helper()
+		records, err := sessStore.listSessions(clock.Now())
+		assertNoError(t, err)
+		return records
+	}
+
+	cookie := save(t, "", Sesh{Name: "admin", Authenticated: true, CSRFToken: "secret-token"})
+
+	t.Run("only the ID is in the cookie", func(t *testing.T) {
+		got := load(cookie)
+		if got.Name != "admin" || !got.Authenticated || got.CSRFToken != "secret-token" {
+			t.Errorf("got %+v", got)
+		}
+		assertNotContain(t, cookie, "secret-token")
+
+		records := list(t)
+		assertInt(t, len(records), 1)
+		rec := records[0]
+		if rec.Username != "admin" || !rec.Authenticated || rec.IP != "192.0.2.1" || rec.UserAgent != "TestBrowser/1.0" {
+			t.Errorf("got %+v", rec)
+		}
+		if !rec.Created.Equal(clock.Now()) || !rec.Expires.Equal(clock.Now().Add(sessionMaxAge*time.Second)) {
+			t.Errorf("got created %v expires %v", rec.Created, rec.Expires)
+		}
+	})
+
+	t.Run("last seen is kept up to date", func(t *testing.T) {
+		clock.Advance(sessionTouchInterval / 2)
+		load(cookie)
+		assertTime(t, list(t)[0].LastSeen, clock.Now().Add(-sessionTouchInterval/2))
+		clock.Advance(sessionTouchInterval)
+		load(cookie)
+		assertTime(t, list(t)[0].LastSeen, clock.Now())
+	})
+
+	t.Run("sessions that haven't logged in are only in the cookie", func(t *testing.T) {
+		for _, sesh := range []Sesh{{CSRFToken: "login-page-token"}, {Name: "admin", AwaitingCode: true, CSRFToken: "code-page-token"}} {
+			c := save(t, "", sesh)
+			assertNotContain(t, c, sesh.CSRFToken)
+			if got := load(c); got != sesh {
+				t.Errorf("got %+v, want %+v", got, sesh)
+			}
+		}
+		var rows int
+		assertNoError(t, store.db.QueryRow("SELECT COUNT(*) FROM Sessions").Scan(&rows))
+		assertInt(t, rows, 1)
+	})
+
+	t.Run("survives a restart with the same keys", func(t *testing.T) {
+		restarted := NewSQLiteSessionStore(store.db, keys...)
+		restarted.clock = clock
+		session, err := restarted.Get(sessionRequest(cookie), "user")
+		assertNoError(t, err)
+		assertLoggedIn(t, restarted.getSesh(session))
+	})
+
+	t.Run("a new ID replaces the old record", func(t *testing.T) {
+		req := sessionRequest(cookie)
+		session, _ := sessStore.Get(req, "user")
+		oldKey := sessionKey(session.ID)
+		sessStore.SetOption(session, "NewID", nil)
+		resp := httptest.NewRecorder()
+		assertNoError(t, sessStore.SaveSession(req, resp, session))
+		newCookie := resp.Header().Get("Set-Cookie")
+
+		assertInt(t, len(list(t)), 1)
+		if list(t)[0].Key == oldKey {
+			t.Error("want a new key")
+		}
+		if load(cookie).Authenticated {
+			t.Error("the old cookie should no longer work")
+		}
+		assertLoggedIn(t, load(newCookie))
+		cookie = newCookie
+	})
+
+	t.Run("revoked sessions are logged out", func(t *testing.T) {
+		other := save(t, "", Sesh{Name: "admin", Authenticated: true})
+		// A request that was running when the session was revoked.
+		req := sessionRequest(cookie)
+		session, _ := sessStore.Get(req, "user")
+
+		assertNoError(t, sessStore.revokeSession(sessionKey(session.ID)))
+		if load(cookie).Authenticated {
+			t.Error("want the revoked session logged out")
+		}
+		assertLoggedIn(t, load(other))
+
+		if err := sessStore.SaveSession(req, httptest.NewRecorder(), session); err != errSessionRevoked {
+			t.Errorf("got %v, want %v", err, errSessionRevoked)
+		}
+		assertInt(t, len(list(t)), 1)
+
+		n, err := sessStore.revokeUserSessions("admin", "")
+		assertNoError(t, err)
+		assertInt(t, n, 1)
+		if load(other).Authenticated {
+			t.Error("want every admin session logged out")
+		}
+	})
+
+	t.Run("expired sessions are logged out and cleaned up", func(t *testing.T) {
+		cookie := save(t, "", Sesh{Name: "admin", Authenticated: true})
+		scheduler := NewScheduler(store, clock, time.Hour, trashRetention)
+		scheduler.sessions = sessStore
+
+		clock.Advance(sessionMaxAge*time.Second - time.Second)
+		assertLoggedIn(t, load(cookie))
+		assertInt(t, scheduler.purgeSessions(), 0)
+
+		clock.Advance(time.Second)
+		if load(cookie).Authenticated {
+			t.Error("want the expired session logged out")
+		}
+		assertInt(t, scheduler.purgeSessions(), 1)
+		assertInt(t, scheduler.purgeSessions(), 0)
+	})
+
+	t.Run("logging out deletes the record", func(t *testing.T) {
+		cookie := save(t, "", Sesh{Name: "admin", Authenticated: true})
+		req := sessionRequest(cookie)
+		session, _ := sessStore.Get(req, "user")
+		sessStore.SetOption(session, "MaxAge", -1)
+		assertNoError(t, sessStore.SaveSession(req, httptest.NewRecorder(), session))
+		assertInt(t, len(list(t)), 0)
+	})
+}
+
+func assertTime(t *testing.T, got, want time.Time) {
+	t.Helper()
+	if !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestActiveSessionsPage(t *testing.T) {
+	store, cleanup := newSQLiteSessionTestStore(t, []User{admin})
+	defer cleanup()
+	sessStore := NewSQLiteSessionStore(store.db)
+	server := NewServer(store, sessStore)
+
+	login := func(t *testing.T) *testBrowser {
+		t.Helper()
+		b := &testBrowser{server: server}
+		assertStatus(t, b.submit(t, "/admin/login", "/admin/login", userData("admin", "password")).Code, http.StatusSeeOther)
+		assertStatus(t, b.get(t, "/admin").Code, http.StatusOK)
+		return b
+	}
+	assertLoggedOut := func(t *testing.T, b *testBrowser) {
+		t.Helper()
+		resp := b.get(t, "/admin")
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+		assertContains(t, resp.Header().Get("Location"), "/admin/login")
+	}
+
+	countRows := func(t *testing.T) int {
+		t.Helper()
+		var n int
+		assertNoError(t, store.db.QueryRow("SELECT COUNT(*) FROM Sessions").Scan(&n))
+		return n
+	}
+
+	t.Run("the login page adds nothing to the table", func(t *testing.T) {
+		b := &testBrowser{server: server}
+		b.token(t, "/admin/login")
+		resp := b.submit(t, "/admin/login", "/admin/login", userData("admin", "wrong-password"))
+		assertStatus(t, resp.Code, http.StatusUnauthorized)
+		assertInt(t, countRows(t), 0)
+	})
+
+	first, second := login(t), login(t)
+	assertInt(t, countRows(t), 2)
+
+	t.Run("lists everywhere the admin is logged in", func(t *testing.T) {
+		body := first.get(t, "/admin/sessions").Body.String()
+		assertInt(t, strings.Count(body, `name="key"`), 2)
+		assertInt(t, strings.Count(body, "(this session)"), 1)
+	})
+
+	t.Run("revoking one session", func(t *testing.T) {
+		records, err := sessStore.listSessions(time.Now())
+		assertNoError(t, err)
+		session, _ := sessStore.Get(requestWithCookies(second.cookies), "user")
+		key := sessionKey(session.ID)
+		if records[0].Key != key && records[1].Key != key {
+			t.Fatal("the second session is not listed")
+		}
+
+		resp := first.submit(t, "/admin/sessions", "/admin/sessions/revoke", url.Values{"key": {key}})
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+		assertLoggedOut(t, second)
+		assertStatus(t, first.get(t, "/admin").Code, http.StatusOK)
+	})
+
+	t.Run("log out everywhere", func(t *testing.T) {
+		second = login(t)
+		resp := second.submit(t, "/admin/sessions", "/admin/sessions/revoke-user", url.Values{"username": {"admin"}})
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+		assertLoggedOut(t, first)
+		assertLoggedOut(t, second)
+	})
+
+	t.Run("cookie sessions can not be listed", func(t *testing.T) {
+		b := &testBrowser{server: NewServer(store, NewMemorySessionStore())}
+		assertStatus(t, b.submit(t, "/admin/login", "/admin/login", userData("admin", "password")).Code, http.StatusSeeOther)
+		resp := b.get(t, "/admin/sessions")
+		assertStatus(t, resp.Code, http.StatusOK)
+		assertContains(t, resp.Body.String(), "Set blog_session_store to sqlite")
+	})
+}
+
+func TestUserChangesLogOutSessions(t *testing.T) {
+	writer := User{Username: "writer", Email: "writer@example.com", Password_Hash: pass_hash, Role: roleAuthor}
+	store, cleanup := newSQLiteSessionTestStore(t, []User{admin, writer})
+	defer cleanup()
+	server := NewServer(store, NewSQLiteSessionStore(store.db))
+
+	login := func(t *testing.T, username string) *testBrowser {
+		t.Helper()
+		b := &testBrowser{server: server}
+		assertStatus(t, b.submit(t, "/admin/login", "/admin/login", userData(username, "password")).Code, http.StatusSeeOther)
+		assertStatus(t, b.get(t, "/admin").Code, http.StatusOK)
+		return b
+	}
+	assertLoggedOut := func(t *testing.T, b *testBrowser) {
+		t.Helper()
+		resp := b.get(t, "/admin")
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+		assertContains(t, resp.Header().Get("Location"), "/admin/login")
+	}
+
+	t.Run("deleting a user logs them out everywhere", func(t *testing.T) {
+		writerBrowser, adminBrowser := login(t, "writer"), login(t, "admin")
+		resp := adminBrowser.submit(t, "/admin/users", "/admin/users/writer/delete", url.Values{})
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+		assertLoggedOut(t, writerBrowser)
+
+		// Not even as someone new given the same username.
+		hash, _ := HashPasswordFast("another-password")
+		assertNoError(t, store.newUser(User{Username: "writer", Email: "new@example.com", Password_Hash: hash, Role: roleAdmin}))
+		assertLoggedOut(t, writerBrowser)
+	})
+
+	t.Run("changing password logs out every other session", func(t *testing.T) {
+		current, other := login(t, "admin"), login(t, "admin")
+		resp := current.submit(t, "/admin/password", "/admin/password", url.Values{
+			"current_password": {"password"},
+			"new_password":     {"a-much-longer-password"},
+			"confirm_password": {"a-much-longer-password"},
+		})
+		assertStatus(t, resp.Code, http.StatusOK)
+		assertContains(t, resp.Body.String(), passwordChanged)
+		assertLoggedOut(t, other)
+		assertStatus(t, current.get(t, "/admin").Code, http.StatusOK)
+	})
+}
+
+func requestWithCookies(cookies []*http.Cookie) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return req
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	statusDraft     = "draft"
+	statusScheduled = "scheduled"
+	statusPublished = "published"
+	// Shown to anyone with the link, but left out of listings, feeds and the sitemap.
+	statusUnlisted = "unlisted"
+)
+
+var statuses = []string{statusDraft, statusScheduled, statusPublished, statusUnlisted}
+
+// Layout of the article form's datetime-local input.
+const publishAtLayout = "2006-01-02T15:04"
+
+func isValidStatus(status string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Drafts and scheduled articles can only be viewed by logged in users.
+func (a Article) IsPublic() bool {
+	return a.Status == statusPublished || a.Status == statusUnlisted
+}
+
+// The scheduled publish time for the article form, empty unless the article is scheduled
+// with a time that can be read, so one has to be picked again.
+func (a Article) PublishAt() string {
+	if a.Status != statusScheduled {
+		return ""
+	}
+	t, err := myStringToTime(a.Published)
+	if err != nil {
+		return ""
+	}
+	return t.Format(publishAtLayout)
+}
+
+// Reads the article form's publish time, which is in UTC. Returns an empty string if it's missing or malformed.
+func parsePublishAt(value string) string {
+	t, err := time.Parse(publishAtLayout, value)
+	if err != nil {
+		return ""
+	}
+	return myTimeToString(t)
+}
+
+// Sets Published and Edited on a new or edited article. old is the saved article, or an empty one for a new article.
+// Published is the time an article was first published, or for a scheduled article the time it will be.
+func setArticleTimes(a *Article, old Article, now time.Time) {
+	a.Edited = myTimeToString(now.UTC())
+	switch {
+	case a.Status == statusScheduled:
+		// Set from the form.
+	case old.Published == "" || !old.IsPublic():
+		a.Published = a.Edited
+	default:
+		a.Published = old.Published
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// A draft, a scheduled, an unlisted and a published article, all tagged go.
+// The scheduled article is due an hour after clock's time.
+func makeArticlesOfEachStatus(clock Clock) []Article {
+	articles := MakeArticlesOfCategory(4, clock.Now(), progCat)
+	for i := range articles {
+		articles[i].Tags = []string{"go"}
+	}
+	articles[0].Status = statusDraft
+	articles[1].Status = statusScheduled
+	articles[1].Published = myTimeToString(clock.Now().Add(time.Hour))
+	articles[2].Status = statusUnlisted
+	return articles
+}
+
+func TestUnreadableTimes(t *testing.T) {
+	if _, err := myStringToTime("not a time"); err == nil {
+		t.Error("want an error, not the zero time")
+	}
+
+	a := validArticleBase
+	a.Status = statusScheduled
+	a.Published = "not a time"
+	if got := a.PublishAt(); got != "" {
+		t.Errorf("got publish at %q, want none", got)
+	}
+
+	server := NewServer(&StubStore{}, &StubSessionStore{})
+	errs, err := server.ValidateArticle(a, false)
+	assertNoError(t, err)
+	assertContains(t, strings.Join(errs, "\n"), errPublishAtInvalid)
+}
+
+func TestFileSystemStoreStatuses(t *testing.T) {
+	clock := newFakeClock()
+	articles := makeArticlesOfEachStatus(clock)
+	published := articles[3]
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+	defer closeDB()
+
+	t.Run("listings only have published articles", func(t *testing.T) {
+		all, err := store.getAll()
+		assertNoError(t, err)
+		assertArticles(t, all, withoutBodies([]Article{{Title: published.Title, Preview: published.Preview, Slug: published.Slug,
+			Published: published.Published, Edited: published.Edited, Category: published.Category, Format: published.Format, Status: statusPublished}}))
+
+		_, total, err := store.getPage(progCat, 1, defaultPerPage)
+		assertNoError(t, err)
+		assertInt(t, total, 1)
+
+		latest, err := store.getLatest("", 10)
+		assertNoError(t, err)
+		assertInt(t, len(latest), 1)
+
+		_, total, err = store.getTagPage("go", 1, defaultPerPage)
+		assertNoError(t, err)
+		assertInt(t, total, 1)
+
+		tagged, err := store.getLatestTagged("go", 10)
+		assertNoError(t, err)
+		assertInt(t, len(tagged), 1)
+
+		counts, err := store.getTagCounts()
+		assertNoError(t, err)
+		assertInt(t, counts[0].Count, 1)
+	})
+
+	t.Run("unpublished articles", func(t *testing.T) {
+		got, err := store.getUnpublished()
+		assertNoError(t, err)
+		assertInt(t, len(got), 3)
+		for _, a := range got {
+			if a.Status == statusPublished {
+				t.Errorf("got published article %s", a.Slug)
+			}
+		}
+	})
+
+	t.Run("every status can be fetched by slug", func(t *testing.T) {
+		for _, want := range articles {
+			_, got, err := store.getArticle(want.Slug)
+			assertNoError(t, err)
+			assertArticle(t, got, want)
+		}
+	})
+
+	t.Run("scheduled articles are published once due", func(t *testing.T) {
+		n, err := store.publishScheduled(myTimeToString(clock.Now().Add(time.Minute)))
+		assertNoError(t, err)
+		assertInt(t, n, 0)
+
+		n, err = store.publishScheduled(myTimeToString(clock.Now().Add(time.Hour)))
+		assertNoError(t, err)
+		assertInt(t, n, 1)
+
+		_, got, err := store.getArticle(articles[1].Slug)
+		assertNoError(t, err)
+		if got.Status != statusPublished {
+			t.Errorf("got status %s, want %s", got.Status, statusPublished)
+		}
+		if got.Published != articles[1].Published {
+			t.Errorf("publish time changed from %s to %s", articles[1].Published, got.Published)
+		}
+	})
+
+	t.Run("migrated articles are published", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		legacy := MakeArticlesOfCategory(1, time.Now().UTC(), progCat)
+		makeV0Database(t, tmpFile.Name(), legacy)
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{})
+		defer closeDB()
+
+		_, got, err := store.getArticle(legacy[0].Slug)
+		assertNoError(t, err)
+		if got.Status != statusPublished {
+			t.Errorf("got status %q, want %s", got.Status, statusPublished)
+		}
+	})
+}
+
+func TestScheduler(t *testing.T) {
+	newStore := func(t *testing.T, clock Clock) (*FileSystemStore, Article, func()) {
+		t.Helper()
+		tmpFile, cleanTempFile := makeTempFile()
+		articles := makeArticlesOfEachStatus(clock)
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+		return store, articles[1], func() {
+			closeDB()
+			cleanTempFile()
+		}
+	}
+
+	t.Run("publishes articles when the clock reaches their time", func(t *testing.T) {
+		clock := newFakeClock()
+		store, scheduled, cleanup := newStore(t, clock)
+		defer cleanup()
+		scheduler := NewScheduler(store, clock, time.Hour, trashRetention)
+		notifier := &recordingNotifier{}
+		scheduler.notifier = notifier
+
+		assertInt(t, scheduler.publishDue(), 0)
+		clock.Advance(59 * time.Minute)
+		assertInt(t, scheduler.publishDue(), 0)
+		assertInt(t, len(notifier.got()), 0)
+		clock.Advance(time.Minute)
+		assertInt(t, scheduler.publishDue(), 1)
+		if events := notifier.got(); len(events) != 1 || events[0].Kind != eventArticlePublished {
+			t.Errorf("got events %v, want one %s", events, eventArticlePublished)
+		}
+
+		_, got, err := store.getArticle(scheduled.Slug)
+		assertNoError(t, err)
+		if got.Status != statusPublished {
+			t.Errorf("got status %s, want %s", got.Status, statusPublished)
+		}
+	})
+
+	t.Run("runs in the background", func(t *testing.T) {
+		clock := newFakeClock()
+		store, scheduled, cleanup := newStore(t, clock)
+		defer cleanup()
+		stop := NewScheduler(store, clock, time.Millisecond, trashRetention).Start()
+		defer stop()
+
+		clock.Advance(2 * time.Hour)
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			_, got, err := store.getArticle(scheduled.Slug)
+			assertNoError(t, err)
+			if got.Status == statusPublished {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("scheduled article was never published")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+}
+
+func TestStatusRoutes(t *testing.T) {
+	clock := newFakeClock()
+	articles := makeArticlesOfEachStatus(clock)
+	draft, scheduled, unlisted := articles[0], articles[1], articles[2]
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{admin})
+	defer closeDB()
+	sessStore := StubSessionStore{}
+	server := NewServer(store, &sessStore)
+	server.clock = clock
+
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, path))
+		return resp
+	}
+
+	t.Run("drafts and scheduled articles are hidden from visitors", func(t *testing.T) {
+		assertStatus(t, get(t, "/"+draft.Slug).Code, 404)
+		assertStatus(t, get(t, "/"+scheduled.Slug).Code, 404)
+	})
+
+	t.Run("unlisted articles can be read but aren't listed", func(t *testing.T) {
+		resp := get(t, "/"+unlisted.Slug)
+		assertStatus(t, resp.Code, 200)
+		if got := resp.Header().Get("X-Robots-Tag"); got != "noindex" {
+			t.Errorf("got X-Robots-Tag %q, want noindex", got)
+		}
+
+		for _, path := range []string{"/", "/all", "/feed.xml", "/sitemap.xml", "/tag/go"} {
+			body := get(t, path).Body.String()
+			for _, a := range []Article{draft, scheduled, unlisted} {
+				if strings.Contains(body, a.Slug) {
+					t.Errorf("%s lists %s article %s", path, a.Status, a.Slug)
+				}
+			}
+		}
+	})
+
+	sessStore.sesh = Sesh{Name: admin.Username, Authenticated: true}
+
+	t.Run("logged in users can see drafts", func(t *testing.T) {
+		resp := get(t, "/"+draft.Slug)
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Body.String(), "Draft, only visible to you")
+
+		resp = get(t, "/admin")
+		assertContains(t, resp.Body.String(), draft.Title+" (draft)")
+	})
+
+	t.Run("new articles", func(t *testing.T) {
+		cases := []struct {
+			name      string
+			status    string
+			publishAt string
+			code      int
+			published string
+		}{
+			{"draft", statusDraft, "", 303, myTimeToString(clock.Now())},
+			{"scheduled", statusScheduled, "2026-03-02T09:30", 303, "2026-03-02 09:30:00"},
+			{"scheduled in the past", statusScheduled, "2026-03-01T11:00", 400, ""},
+			{"scheduled without a time", statusScheduled, "", 400, ""},
+			{"unknown status", "secret", "", 400, ""},
+		}
+		for i, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				a := validArticleBase
+				a.Slug = "status-" + string(rune('a'+i))
+				data := setDataValues(a)
+				data.Set("status", c.status)
+				data.Set("publish_at", c.publishAt)
+
+				resp := httptest.NewRecorder()
+				server.ServeHTTP(resp, newPostRequest(t, "/new", data))
+				assertStatus(t, resp.Code, c.code)
+				if c.code != 303 {
+					return
+				}
+
+				_, got, err := store.getArticle(a.Slug)
+				assertNoError(t, err)
+				if got.Status != c.status || got.Published != c.published {
+					t.Errorf("got %s published %s, want %s published %s", got.Status, got.Published, c.status, c.published)
+				}
+			})
+		}
+	})
+
+	t.Run("publishing a draft sets its publish time", func(t *testing.T) {
+		clock.Advance(24 * time.Hour)
+		edit := draft
+		edit.Status = statusPublished
+
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/"+draft.Slug+"/edit", setDataValues(edit)))
+		assertStatus(t, resp.Code, 303)
+
+		_, got, err := store.getArticle(draft.Slug)
+		assertNoError(t, err)
+		if got.Published != myTimeToString(clock.Now()) {
+			t.Errorf("got published %s, want %s", got.Published, myTimeToString(clock.Now()))
+		}
+	})
+
+	t.Run("editing a published article keeps its publish time", func(t *testing.T) {
+		clock.Advance(time.Hour)
+		_, before, err := store.getArticle(draft.Slug)
+		assertNoError(t, err)
+
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/"+draft.Slug+"/edit", setDataValues(before)))
+		assertStatus(t, resp.Code, 303)
+
+		_, got, err := store.getArticle(draft.Slug)
+		assertNoError(t, err)
+		if got.Published != before.Published {
+			t.Errorf("got published %s, want %s", got.Published, before.Published)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const maxTagLength = 32
+
+type Tag struct {
+	Name string
+	Slug string
+	// Number of articles with the tag. Only filled in by getTagCounts.
+	Count int
+}
+
+// Bulma tag sizes, smallest first.
+var tagCloudSizes = []string{"is-normal", "is-medium", "is-large"}
+
+var tagSlugIllegal = regexp.MustCompile(`[^a-z0-9+.-]+`)
+
+// "Go Templates" becomes "go-templates". Returns an empty string if nothing usable is left.
+// A # would start the fragment of a tag link, so "C#" becomes "csharp".
+func tagSlug(name string) string {
+	slug := strings.ToLower(strings.TrimSpace(name))
+	slug = strings.ReplaceAll(slug, "#", "sharp")
+	slug = tagSlugIllegal.ReplaceAllString(slug, "-")
+	return strings.Trim(slug, "-")
+}
+
+// Splits the comma separated tags field of the article form. Duplicates are dropped.
+func parseTags(field string) []string {
+	var ret []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(field, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[tagSlug(name)] {
+			continue
+		}
+		seen[tagSlug(name)] = true
+		ret = append(ret, name)
+	}
+	return ret
+}
+
+// The tags as typed into the article form.
+func (a Article) TagList() string {
+	return strings.Join(a.Tags, ", ")
+}
+
+func (a Article) TagLinks() []Tag {
+	var ret []Tag
+	for _, name := range a.Tags {
+		ret = append(ret, Tag{Name: name, Slug: tagSlug(name)})
+	}
+	return ret
+}
+
+func tagPath(slug string) string {
+	return "/tag/" + slug
+}
+
+type TagCloudEntry struct {
+	Tag
+	Size string
+}
+
+// Picks a size for each tag in the cloud by how many articles it has compared to the most used tag.
+func tagCloud(tags []Tag) []TagCloudEntry {
+	most := 0
+	for _, t := range tags {
+		if t.Count > most {
+			most = t.Count
+		}
+	}
+
+	ret := make([]TagCloudEntry, len(tags))
+	for i, t := range tags {
+		size := 0
+		if t.Count > 0 {
+			size = (t.Count*len(tagCloudSizes) - 1) / most
+		}
+		ret[i] = TagCloudEntry{t, tagCloudSizes[size]}
+	}
+	return ret
+}
+
+func (s *Server) TagIndexPage(w http.ResponseWriter, r *http.Request) {
+	tag, err := s.store.getTag(mux.Vars(r)["tag"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if tag.Slug == "" {
+		notFound(w)
+		return
+	}
+
+	page, ok := getPageNumber(r)
+	if !ok || page < 1 {
+		notFound(w)
+		return
+	}
+	perPage := getPerPage(r)
+
+	articles, total, err := s.store.getTagPage(tag.Slug, page, perPage)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	p := Pagination{Page: page, PerPage: perPage, Total: total}
+	if !p.InRange() {
+		notFound(w)
+		return
+	}
+
+	setPaginationLinks(w, p, tagPath(tag.Slug))
+	indexPage(w, articles, tag.Name, "Tagged: "+tag.Name, "", tagPath(tag.Slug), p, s.viewer(w, r))
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/xml"
+	"html"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTagSlug(t *testing.T) {
+	cases := map[string]string{
+		"Go":            "go",
+		"Go Templates":  "go-templates",
+		"  C++ ":        "c++",
+		"C#":            "csharp",
+		"F# and C#":     "fsharp-and-csharp",
+		"node.js":       "node.js",
+		"rock & roll!":  "rock-roll",
+		"---":           "",
+		"日本語":           "",
+		"Already-slug":  "already-slug",
+		"two  spaces":   "two-spaces",
+		"trailing-dot.": "trailing-dot.",
+	}
+	for name, want := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := tagSlug(name); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	t.Run("splits on commas and trims", func(t *testing.T) {
+		got := parseTags(" go, templates ,,sql ")
+		want := []string{"go", "templates", "sql"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("drops tags with the same slug", func(t *testing.T) {
+		got := parseTags("Go, go, GO")
+		want := []string{"Go"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("empty field has no tags", func(t *testing.T) {
+		if got := parseTags(""); got != nil {
+			t.Errorf("got %v, want no tags", got)
+		}
+	})
+}
+
+func TestTagCloud(t *testing.T) {
+	got := tagCloud([]Tag{{Name: "a", Count: 1}, {Name: "b", Count: 2}, {Name: "c", Count: 6}, {Name: "d", Count: 0}})
+	want := []string{"is-normal", "is-normal", "is-large", "is-normal"}
+	for i, e := range got {
+		if e.Size != want[i] {
+			t.Errorf("tag %s got size %s, want %s", e.Name, e.Size, want[i])
+		}
+	}
+}
+
+func TestTagValidation(t *testing.T) {
+	store := StubStore{}
+	server := NewServer(&store, &StubSessionStore{})
+
+	cases := []struct {
+		name string
+		tags []string
+		want string
+	}{
+		{"too long", []string{strings.Repeat("a", maxTagLength+1)}, errTagLong},
+		{"no letters or numbers", []string{"!!!"}, errTagBad},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := validArticleBase
+			a.Tags = c.tags
+			errs, err := server.ValidateArticle(a, false)
+			assertNoError(t, err)
+			if !reflect.DeepEqual(errs, []string{c.want}) {
+				t.Errorf("got %v, want %v", errs, []string{c.want})
+			}
+		})
+	}
+
+	t.Run("valid tags", func(t *testing.T) {
+		a := validArticleBase
+		a.Tags = []string{"go", strings.Repeat("a", maxTagLength)}
+		errs, err := server.ValidateArticle(a, false)
+		assertNoError(t, err)
+		if len(errs) != 0 {
+			t.Errorf("got %v, want no errors", errs)
+		}
+	})
+}
+
+func TestFileSystemStoreTags(t *testing.T) {
+	articles := MakeArticlesOfCategory(3, time.Now().UTC(), progCat)
+	articles[0].Tags = []string{"Go", "SQL"}
+	articles[1].Tags = []string{"Go"}
+	articles[2].Tags = []string{"Templates"}
+
+	newStore := func(t *testing.T) (*FileSystemStore, func()) {
+		t.Helper()
+		tmpFile, cleanTempFile := makeTempFile()
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+		return store, func() {
+			closeDB()
+			cleanTempFile()
+		}
+	}
+
+	t.Run("getArticle loads tags", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		_, got, err := store.getArticle(articles[0].Slug)
+		assertNoError(t, err)
+		assertArticle(t, got, articles[0])
+	})
+
+	t.Run("get tag", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		got, err := store.getTag("sql")
+		assertNoError(t, err)
+		if got != (Tag{Name: "SQL", Slug: "sql"}) {
+			t.Errorf("got %v", got)
+		}
+
+		got, err = store.getTag("missing")
+		assertNoError(t, err)
+		if got != (Tag{}) {
+			t.Errorf("got %v, want no tag", got)
+		}
+	})
+
+	t.Run("tag page is newest first without bodies", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		got, total, err := store.getTagPage("go", 1, 1)
+		assertNoError(t, err)
+		assertInt(t, total, 2)
+		want := withoutBodies([]Article{articles[1]})
+		want[0].Tags = nil
+		assertArticles(t, got, want)
+
+		got, _, err = store.getTagPage("go", 2, 1)
+		assertNoError(t, err)
+		assertInt(t, len(got), 1)
+		if got[0].Slug != articles[0].Slug {
+			t.Errorf("got %s on page 2, want %s", got[0].Slug, articles[0].Slug)
+		}
+	})
+
+	t.Run("latest tagged includes bodies", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		got, err := store.getLatestTagged("go", 10)
+		assertNoError(t, err)
+		assertInt(t, len(got), 2)
+		if got[0].Body != articles[1].Body {
+			t.Errorf("got body %q, want %q", got[0].Body, articles[1].Body)
+		}
+	})
+
+	t.Run("tag counts", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		got, err := store.getTagCounts()
+		assertNoError(t, err)
+		want := []Tag{{"Go", "go", 2}, {"SQL", "sql", 1}, {"Templates", "templates", 1}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("editing replaces tags", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		id, edit, err := store.getArticle(articles[0].Slug)
+		assertNoError(t, err)
+		edit.Tags = []string{"Templates"}
+		assertNoError(t, store.editArticle(id, edit))
+
+		_, got, err := store.getArticle(articles[0].Slug)
+		assertNoError(t, err)
+		assertArticle(t, got, edit)
+
+		counts, err := store.getTagCounts()
+		assertNoError(t, err)
+		want := []Tag{{"Go", "go", 1}, {"Templates", "templates", 2}}
+		if !reflect.DeepEqual(counts, want) {
+			t.Errorf("got %v, want %v", counts, want)
+		}
+	})
+
+	t.Run("deleting an article removes it from its tags", func(t *testing.T) {
+		store, cleanup := newStore(t)
+		defer cleanup()
+
+		id, _, err := store.getArticle(articles[2].Slug)
+		assertNoError(t, err)
+		assertNoError(t, store.deleteArticle(id))
+
+		_, total, err := store.getTagPage("templates", 1, defaultPerPage)
+		assertNoError(t, err)
+		assertInt(t, total, 0)
+	})
+}
+
+func TestTagRoutes(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+
+	articles := MakeArticlesOfCategory(defaultPerPage+2, time.Now().UTC(), progCat)
+	for i := range articles {
+		articles[i].Tags = []string{"Go"}
+	}
+	articles[0].Tags = []string{"Go", "Templates"}
+	articles[1].Tags = []string{"Go", "C#", "C++"}
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+	defer closeDB()
+	server := NewServer(store, &StubSessionStore{})
+
+	t.Run("tag listing", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/tag/go"))
+
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Body.String(), "Tagged: Go")
+		assertContains(t, resp.Body.String(), articles[len(articles)-1].Title)
+		assertContains(t, resp.Body.String(), `href="/tag/go/page/2"`)
+		assertContains(t, resp.Header().Get("Link"), `</tag/go/page/2>; rel="next"`)
+	})
+
+	t.Run("tag listing pages", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/tag/go/page/2"))
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Body.String(), articles[0].Title)
+
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/tag/go/page/3"))
+		assertStatus(t, resp.Code, 404)
+	})
+
+	t.Run("unknown tag is 404", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/tag/rust"))
+		assertStatus(t, resp.Code, 404)
+
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/tag/rust/feed.xml"))
+		assertStatus(t, resp.Code, 404)
+	})
+
+	t.Run("article shows its tags", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/"+articles[0].Slug))
+		assertContains(t, resp.Body.String(), `<a href="/tag/templates" class="tag is-light">Templates</a>`)
+	})
+
+	t.Run("tag links with symbols lead to the tag", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/"+articles[1].Slug))
+		links := map[string]string{}
+		for _, m := range regexp.MustCompile(`<a href="([^"]*)" class="tag is-light">([^<]*)</a>`).FindAllStringSubmatch(resp.Body.String(), -1) {
+			links[html.UnescapeString(m[2])] = html.UnescapeString(m[1])
+		}
+		for _, name := range []string{"C#", "C++"} {
+			if links[name] == "" {
+				t.Fatalf("no link to tag %s", name)
+			}
+			// Follow it the way a browser would, which drops anything after a #.
+			u, err := url.Parse(links[name])
+			assertNoError(t, err)
+
+			tagResp := httptest.NewRecorder()
+			server.ServeHTTP(tagResp, newGetRequest(t, u.EscapedPath()))
+			assertStatus(t, tagResp.Code, 200)
+			assertContains(t, html.UnescapeString(tagResp.Body.String()), "Tagged: "+name)
+		}
+	})
+
+	t.Run("tag cloud on all", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/all"))
+		assertContains(t, resp.Body.String(), `href="/tag/go" class="tag is-light is-large"`)
+		assertContains(t, resp.Body.String(), `href="/tag/templates" class="tag is-light is-normal"`)
+	})
+
+	t.Run("tag feed", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/tag/templates/feed.xml"))
+		assertStatus(t, resp.Code, 200)
+
+		var rss struct {
+			Channel struct {
+				Title string `xml:"title"`
+				Items []struct {
+					Title string `xml:"title"`
+				} `xml:"item"`
+			} `xml:"channel"`
+		}
+		assertNoError(t, xml.Unmarshal(resp.Body.Bytes(), &rss))
+		assertContains(t, rss.Channel.Title, "Tagged: Templates")
+		assertInt(t, len(rss.Channel.Items), 1)
+		assertContains(t, rss.Channel.Items[0].Title, articles[0].Title)
+	})
+}
+
+func TestTagsFromForm(t *testing.T) {
+	store := StubStore{calls: []string{}}
+	sessStore := StubSessionStore{Sesh{Authenticated: true}}
+	server := NewServer(&store, &sessStore)
+
+	a := validArticleBase
+	data := setDataValues(a)
+	data.Set("tags", "Go, templates, go")
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, newPostRequest(t, "/new", data))
+
+	assertStatus(t, resp.Code, 303)
+	if len(store.articles) != 1 {
+		t.Fatalf("got %d articles saved, want 1", len(store.articles))
+	}
+	want := []string{"Go", "templates"}
+	if !reflect.DeepEqual(store.articles[0].Tags, want) {
+		t.Errorf("got tags %v, want %v", store.articles[0].Tags, want)
+	}
+
+	t.Run("form keeps tags when invalid", func(t *testing.T) {
+		data := url.Values{}
+		data.Set("category", progCat)
+		data.Set("tags", "Go, templates")
+
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/new", data))
+		assertStatus(t, resp.Code, 400)
+		assertContains(t, resp.Body.String(), `name="tags" value="Go, templates"`)
+	})
+}
+
+func TestTagSlugReserved(t *testing.T) {
+	assertArticleSlugReserved(t, "tag")
+	assertArticleSlugReserved(t, "Tag")
+}
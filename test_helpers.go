@@ -5,8 +5,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,6 +23,8 @@ var validArticleBase = Article{
 	Body:     "<p>This is a valid body of an article.</p>",
 	Slug:     "this-is_a.v4l1d~slug",
 	Category: "Other",
+	Format:   formatHTML,
+	Status:   statusPublished,
 }
 
 func newValidArticleWithTime() Article {
@@ -35,55 +40,338 @@ var editedBase = Article{
 	Body:     "<p>Edited Body.</p>",
 	Slug:     "edited-article",
 	Category: "Programming",
+	Format:   formatHTML,
+	Status:   statusPublished,
+}
+
+// A Clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
 }
 
 type StubStore struct {
 	articles []Article
-	calls    []string
+	// Defaults to Programming and Other when nil.
+	categories []Category
+	calls      []string
+	// If set, writes fail with this error instead of saving.
+	writeErr error
+	// When nil, every username belongs to an admin, so tests can log in as anyone.
+	users []User
 }
 
-func (s *StubStore) getAll() []Article {
+func (s *StubStore) getAll() ([]Article, error) {
 	s.calls = append(s.calls, "getAll")
-	return s.articles
+	return s.articles, nil
 }
 
-func (s *StubStore) getPage(page int, category string) ([]Article, int, int) {
+func (s *StubStore) getPage(category string, page, perPage int) ([]Article, int, error) {
 	s.calls = append(s.calls, "getPage")
 
-	return s.articles, 0, 0
+	return s.articles, len(s.articles), nil
 }
 
-func (s *StubStore) getArticle(slug string) (int, Article) {
+func (s *StubStore) getLatest(category string, n int) ([]Article, error) {
+	s.calls = append(s.calls, "getLatest")
+	return s.articles, nil
+}
+
+func (s *StubStore) getArticle(slug string) (int, Article, error) {
 	s.calls = append(s.calls, "getArticle")
 	for _, a := range s.articles {
 		if a.Slug == slug {
-			return 1, a
+			return 1, a, nil
 		}
 	}
-	return 0, Article{}
+	return 0, Article{}, nil
 }
 
-func (s *StubStore) newArticle(a Article) {
+func (s *StubStore) newArticle(a Article) error {
 	s.calls = append(s.calls, "new")
+	if s.writeErr != nil {
+		return s.writeErr
+	}
 	s.articles = append(s.articles, a)
+	return nil
 }
 
-func (s *StubStore) editArticle(id int, edited Article) {
+func (s *StubStore) editArticle(id int, edited Article) error {
 	s.calls = append(s.calls, "edit")
+	return s.writeErr
+}
+
+func (s *StubStore) trashArticle(id int, now string) error {
+	s.calls = append(s.calls, "trash")
+	return s.writeErr
+}
+
+func (s *StubStore) restoreArticle(id int) error {
+	s.calls = append(s.calls, "restore")
+	return s.writeErr
+}
+
+func (s *StubStore) getTrash() ([]Article, error) {
+	s.calls = append(s.calls, "getTrash")
+	return nil, nil
 }
 
-func (s *StubStore) deleteArticle(id int) {
+func (s *StubStore) purgeTrash(before string) (int, error) {
+	return 0, s.writeErr
+}
+
+func (s *StubStore) deleteArticle(id int) error {
 	s.calls = append(s.calls, "delete")
+	return s.writeErr
 }
 
-func (s *StubStore) doesSlugExist(slug string) bool {
-	return false
+func (s *StubStore) doesSlugExist(slug string) (bool, error) {
+	return false, nil
 }
 
 func (s *StubStore) getUser(username string) (User, error) {
+	if s.users == nil {
+		return User{Id: 1, Username: username, Role: roleAdmin}, nil
+	}
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
 	return User{}, nil
 }
 
+func (s *StubStore) getUsers() ([]User, error) {
+	s.calls = append(s.calls, "getUsers")
+	return s.users, nil
+}
+
+func (s *StubStore) newUser(u User) error {
+	s.calls = append(s.calls, "newUser")
+	return s.writeErr
+}
+
+func (s *StubStore) setRole(username, role string) error {
+	s.calls = append(s.calls, "setRole")
+	return s.writeErr
+}
+
+func (s *StubStore) setProfile(username string, u User) error {
+	s.calls = append(s.calls, "setProfile")
+	return s.writeErr
+}
+
+func (s *StubStore) deleteUser(username string) error {
+	s.calls = append(s.calls, "deleteUser")
+	return s.writeErr
+}
+
+func (s *StubStore) setTOTP(username, secret string, recoveryHashes []string) error {
+	s.calls = append(s.calls, "setTOTP")
+	return s.writeErr
+}
+
+func (s *StubStore) setRecoveryCodes(userID int, hashes []string) error {
+	s.calls = append(s.calls, "setRecoveryCodes")
+	return s.writeErr
+}
+
+func (s *StubStore) useTOTPStep(userID int, step int64) (bool, error) {
+	s.calls = append(s.calls, "useTOTPStep")
+	return true, s.writeErr
+}
+
+func (s *StubStore) useRecoveryCode(userID int, hash string) (bool, error) {
+	s.calls = append(s.calls, "useRecoveryCode")
+	return false, s.writeErr
+}
+
+func (s *StubStore) countRecoveryCodes(userID int) (int, error) {
+	return 0, nil
+}
+
+func (s *StubStore) setPassword(username, hash string) error {
+	s.calls = append(s.calls, "setPassword")
+	return s.writeErr
+}
+
+func (s *StubStore) getTag(slug string) (Tag, error) {
+	s.calls = append(s.calls, "getTag")
+	for _, a := range s.articles {
+		for _, t := range a.TagLinks() {
+			if t.Slug == slug {
+				return t, nil
+			}
+		}
+	}
+	return Tag{}, nil
+}
+
+func (s *StubStore) getTagPage(slug string, page, perPage int) ([]Article, int, error) {
+	s.calls = append(s.calls, "getTagPage")
+	tagged := s.tagged(slug)
+	return tagged, len(tagged), nil
+}
+
+func (s *StubStore) getAuthorPage(authorID, page, perPage int) ([]Article, int, error) {
+	s.calls = append(s.calls, "getAuthorPage")
+	return s.byAuthor(authorID), len(s.byAuthor(authorID)), nil
+}
+
+func (s *StubStore) getLatestByAuthor(authorID, n int) ([]Article, error) {
+	s.calls = append(s.calls, "getLatestByAuthor")
+	return s.byAuthor(authorID), nil
+}
+
+func (s *StubStore) byAuthor(authorID int) []Article {
+	var ret []Article
+	for _, a := range s.articles {
+		if a.AuthorID == authorID {
+			ret = append(ret, a)
+		}
+	}
+	return ret
+}
+
+func (s *StubStore) getLatestTagged(slug string, n int) ([]Article, error) {
+	s.calls = append(s.calls, "getLatestTagged")
+	return s.tagged(slug), nil
+}
+
+func (s *StubStore) getTagCounts() ([]Tag, error) {
+	s.calls = append(s.calls, "getTagCounts")
+	var ret []Tag
+	index := map[string]int{}
+	for _, a := range s.articles {
+		for _, t := range a.TagLinks() {
+			if i, ok := index[t.Slug]; ok {
+				ret[i].Count++
+				continue
+			}
+			index[t.Slug] = len(ret)
+			t.Count = 1
+			ret = append(ret, t)
+		}
+	}
+	return ret, nil
+}
+
+// Not recorded in calls, since every page lists the categories.
+func (s *StubStore) getCategories() ([]Category, error) {
+	if s.categories == nil {
+		return []Category{
+			{Id: 1, Name: progCat, Slug: "programming", Home: true},
+			{Id: 2, Name: otherCat, Slug: "other", SortOrder: 1},
+		}, nil
+	}
+	return s.categories, nil
+}
+
+func (s *StubStore) getCategory(slug string) (Category, error) {
+	categories, _ := s.getCategories()
+	for _, c := range categories {
+		if c.Slug == slug {
+			return c, nil
+		}
+	}
+	return Category{}, nil
+}
+
+func (s *StubStore) newCategory(c Category) error {
+	s.calls = append(s.calls, "newCategory")
+	return s.writeErr
+}
+
+func (s *StubStore) editCategory(id int, c Category) error {
+	s.calls = append(s.calls, "editCategory")
+	return s.writeErr
+}
+
+func (s *StubStore) deleteCategory(id int, moveTo string) error {
+	s.calls = append(s.calls, "deleteCategory")
+	return s.writeErr
+}
+
+func (s *StubStore) getUnpublished() ([]Article, error) {
+	s.calls = append(s.calls, "getUnpublished")
+	var ret []Article
+	for _, a := range s.articles {
+		if a.Status != statusPublished {
+			ret = append(ret, a)
+		}
+	}
+	return ret, nil
+}
+
+func (s *StubStore) publishScheduled(now string) (int, error) {
+	s.calls = append(s.calls, "publishScheduled")
+	return 0, s.writeErr
+}
+
+func (s *StubStore) getRevisions(articleID int) ([]Revision, error) {
+	s.calls = append(s.calls, "getRevisions")
+	return nil, nil
+}
+
+func (s *StubStore) getRevision(articleID, id int) (Revision, error) {
+	s.calls = append(s.calls, "getRevision")
+	return Revision{}, nil
+}
+
+func (s *StubStore) getRedirect(slug string) (string, error) {
+	s.calls = append(s.calls, "getRedirect")
+	return "", nil
+}
+
+func (s *StubStore) getSlugHistory(articleID int) ([]string, error) {
+	s.calls = append(s.calls, "getSlugHistory")
+	return nil, nil
+}
+
+func (s *StubStore) deleteSlugHistory(articleID int, slug string) error {
+	s.calls = append(s.calls, "deleteSlugHistory")
+	return s.writeErr
+}
+
+func (s *StubStore) Search(q SearchQuery, page, perPage int) ([]SearchResult, int, error) {
+	s.calls = append(s.calls, "search")
+	return nil, 0, nil
+}
+
+func (s *StubStore) tagged(slug string) []Article {
+	var ret []Article
+	for _, a := range s.articles {
+		for _, t := range a.TagLinks() {
+			if t.Slug == slug {
+				ret = append(ret, a)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+func mustNewFileSystemStore(t *testing.T, dbFile *os.File, articles []Article, users []User) (*FileSystemStore, func()) {
+	t.Helper()
+	store, closeDB, err := NewFileSystemStore(dbFile, articles, users)
+	if err != nil {
+		t.Fatalf("could not create store, %v", err)
+	}
+	return store, closeDB
+}
+
 func newGetRequest(t *testing.T, path string) *http.Request {
 	t.Helper()
 	req, err := http.NewRequest(http.MethodGet, path, nil)
@@ -97,13 +385,13 @@ func newPostRequest(t *testing.T, path string, data url.Values) *http.Request {
 	t.Helper()
 	req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(data.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(csrfHeader, testCSRFToken)
 	return req
 }
 
 func newDeleteRequest(t *testing.T, slug string) *http.Request {
 	t.Helper()
-	req, _ := http.NewRequest(http.MethodGet, "/"+slug+"/delete", nil)
-	return req
+	return newPostRequest(t, "/"+slug+"/delete", url.Values{})
 }
 
 func setDataValues(a Article) url.Values {
@@ -113,6 +401,13 @@ func setDataValues(a Article) url.Values {
 	data.Set("body", a.Body)
 	data.Set("slug", a.Slug)
 	data.Set("category", a.Category)
+	data.Set("tags", a.TagList())
+	data.Set("status", a.Status)
+	data.Set("publish_at", a.PublishAt())
+	data.Set("format", a.Format)
+	if a.Format == formatMarkdown {
+		data.Set("body", a.Source)
+	}
 	return data
 }
 
@@ -126,15 +421,71 @@ func MakeSeparatedArticles(n int) (progWant []Article, otherWant []Article) {
 	return
 }
 
+func countArticles(t *testing.T, store Store) int {
+	t.Helper()
+	articles, err := store.getAll()
+	assertNoError(t, err)
+	return len(articles)
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error, %v", err)
+	}
+}
+
+func reverseArticles(in []Article) []Article {
+	ret := make([]Article, len(in))
+
+	for i, a := range in {
+		ret[len(ret)-i-1] = a
+	}
+
+	return ret
+}
+
+// Listings don't load article bodies.
+func withoutBodies(in []Article) []Article {
+	ret := make([]Article, len(in))
+	for i, a := range in {
+		a.Body = ""
+		ret[i] = a
+	}
+	return ret
+}
+
 func assertInt(t *testing.T, got, want int) {
 	if got != want {
 		t.Errorf("got %d, want %d", got, want)
 	}
 }
 
+// Checks that an article can't take slug, because a route uses it.
+func assertArticleSlugReserved(t *testing.T, slug string) {
+	t.Helper()
+	server := NewServer(&StubStore{}, &StubSessionStore{})
+	a := validArticleBase
+	a.Slug = slug
+	errs, err := server.ValidateArticle(a, false)
+	assertNoError(t, err)
+	if !reflect.DeepEqual(errs, []string{errSlugReserved}) {
+		t.Errorf("slug %q got %v, want %v", slug, errs, []string{errSlugReserved})
+	}
+}
+
+func mustStringToTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	parsed, err := myStringToTime(s)
+	if err != nil {
+		t.Fatalf("could not parse time %q, %v", s, err)
+	}
+	return parsed
+}
+
 func assertArticle(t *testing.T, got, want Article) {
 	t.Helper()
-	if got != want {
+	if !reflect.DeepEqual(got, want) {
 		t.Errorf("articles don't match, got %v, want %v", got, want)
 	}
 }
@@ -147,6 +498,10 @@ func assertArticleWithoutTime(t *testing.T, got, want Article) {
 		Body:     got.Body,
 		Slug:     got.Slug,
 		Category: got.Category,
+		Format:   got.Format,
+		Source:   got.Source,
+		Tags:     got.Tags,
+		Status:   got.Status,
 	}
 	timelessWant := Article{
 		Title:    want.Title,
@@ -154,15 +509,19 @@ func assertArticleWithoutTime(t *testing.T, got, want Article) {
 		Body:     want.Body,
 		Slug:     want.Slug,
 		Category: want.Category,
+		Format:   want.Format,
+		Source:   want.Source,
+		Tags:     want.Tags,
+		Status:   want.Status,
 	}
-	if timelessGot != timelessWant {
+	if !reflect.DeepEqual(timelessGot, timelessWant) {
 		t.Errorf("articles don't match, got %v, want %v", timelessGot, timelessWant)
 	}
 }
 
 func assertNotArticle(t *testing.T, got, notwant Article) {
 	t.Helper()
-	if got == notwant {
+	if reflect.DeepEqual(got, notwant) {
 		t.Errorf("articles shouldn't match, got %v, don't want %v", got, notwant)
 	}
 }
@@ -219,6 +578,7 @@ var admin = User{
 	Username:      "admin",
 	Email:         "admin@example.com",
 	Password_Hash: pass_hash,
+	Role:          roleAdmin,
 }
 
 func testLogin(t *testing.T, server *Server) {
@@ -233,6 +593,9 @@ func testLogout(t *testing.T, server *Server) {
 }
 
 // Would break if I allowed more than one user, don't copy paste this to other projects.
+// The token every StubSessionStore session has, unless a test gives it another.
+const testCSRFToken = "test-csrf-token"
+
 type StubSessionStore struct {
 	// session *sessions.Session
 	sesh Sesh
@@ -254,7 +617,11 @@ func (sss *StubSessionStore) SaveSession(r *http.Request, w http.ResponseWriter,
 }
 
 func (sss *StubSessionStore) getSesh(s *sessions.Session) Sesh {
-	return sss.sesh
+	sesh := sss.sesh
+	if sesh.CSRFToken == "" {
+		sesh.CSRFToken = testCSRFToken
+	}
+	return sesh
 }
 
 func (sss *StubSessionStore) SetOption(s *sessions.Session, o string, v interface{}) {
@@ -271,3 +638,75 @@ func assertLoggedInStatus(t *testing.T, s StubSessionStore, v bool) {
 		t.Error("should be logged in but not")
 	}
 }
+
+// Sends requests to a server with a real session store, keeping its cookies like a browser does.
+type testBrowser struct {
+	server  http.Handler
+	cookies []*http.Cookie
+}
+
+func (b *testBrowser) send(t *testing.T, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	for _, c := range b.cookies {
+		req.AddCookie(c)
+	}
+	resp := httptest.NewRecorder()
+	b.server.ServeHTTP(resp, req)
+	if set := resp.Result().Cookies(); len(set) != 0 {
+		b.cookies = set
+	}
+	return resp
+}
+
+func (b *testBrowser) get(t *testing.T, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	return b.send(t, newGetRequest(t, path))
+}
+
+// Posts data as it is, without adding a token.
+func (b *testBrowser) post(t *testing.T, path string, data url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(data.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	return b.send(t, req)
+}
+
+var csrfFieldPattern = regexp.MustCompile(`name="csrf_token" value="([^"]*)"`)
+
+// The csrf_token of the page at path.
+func (b *testBrowser) token(t *testing.T, path string) string {
+	t.Helper()
+	resp := b.get(t, path)
+	assertStatus(t, resp.Code, http.StatusOK)
+	m := csrfFieldPattern.FindStringSubmatch(resp.Body.String())
+	if m == nil || m[1] == "" {
+		t.Fatalf("no csrf_token field on %s", path)
+	}
+	return m[1]
+}
+
+// Posts data with the token from the page at from.
+func (b *testBrowser) submit(t *testing.T, from, path string, data url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	data.Set(csrfField, b.token(t, from))
+	return b.post(t, path, data)
+}
+
+// Keeps every event it's told about.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (n *recordingNotifier) Notify(events []Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, events...)
+	return nil
+}
+
+func (n *recordingNotifier) got() []Event {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Event(nil), n.events...)
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// RFC 6238 time-based one-time passwords, the codes authenticator apps show.
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// Codes from this many periods either side of now are accepted, for phones whose clocks have drifted.
+	totpSkew          = 1
+	totpIssuer        = "Gorocode"
+	recoveryCodeCount = 10
+)
+
+const (
+	errTOTPCode        = "That code is wrong or has expired, try the one your app shows now"
+	errLoginCode       = "Wrong code"
+	twoFactorEnabled   = "Two-factor authentication is on. Save these recovery codes somewhere safe, each one can be used once instead of a code and they will not be shown again."
+	twoFactorDisabled  = "Two-factor authentication is off"
+	recoveryCodesReset = "New recovery codes made, the old ones no longer work. Save these somewhere safe."
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// The HOTP code (RFC 4226) for step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000), nil
+}
+
+// Returns the step code is for, so it can be recorded as used.
+func checkTOTP(secret, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	step := totpStep(now)
+	for s := step - totpSkew; s <= step+totpSkew; s++ {
+		want, err := totpCode(secret, s)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(want)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// What the QR code holds, see https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func totpURI(secret, username string) string {
+	v := url.Values{"secret": {secret}, "issuer": {totpIssuer}}
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+username) + "?" + v.Encode()
+}
+
+// Makes recoveryCodeCount codes like "abcde-fghij", and the hashes to store instead of them.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		c := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		c = c[:5] + "-" + c[5:]
+		codes = append(codes, c)
+		hashes = append(hashes, hashRecoveryCode(c))
+	}
+	return codes, hashes, nil
+}
+
+// The codes are random enough that a fast hash will do. Case, spaces and dashes don't matter.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Checks code as a TOTP code, or failing that as one of the user's recovery codes.
+// Either way it can't be used again.
+func (s *Server) checkSecondFactor(user User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+	if step, ok := checkTOTP(user.TOTPSecret, code, s.clock.Now()); ok {
+		return s.store.useTOTPStep(user.Id, step)
+	}
+	return s.store.useRecoveryCode(user.Id, hashRecoveryCode(code))
+}
+
+// The second step of logging in, for users with two-factor authentication.
+func (s *Server) LoginCodePage(w http.ResponseWriter, r *http.Request) {
+	session, _ := s.sessionStore.Get(r, "user")
+	if !s.sessionStore.getSesh(session).AwaitingCode {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+	s.loginCodeForm(w, r, http.StatusOK, nil)
+}
+
+func (s *Server) CheckLoginCode(w http.ResponseWriter, r *http.Request) {
+	session, _ := s.sessionStore.Get(r, "user")
+	sesh := s.sessionStore.getSesh(session)
+	if !sesh.AwaitingCode {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+	user, err := s.store.getUser(sesh.Name)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	// Deleted, or had two-factor turned off, since entering their password.
+	if user.TOTPSecret == "" {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	keys := loginKeys(r, user.Username)
+	wait, err := s.loginWait(keys)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if wait > 0 {
+		s.loginCodeForm(w, r, http.StatusTooManyRequests, []string{tooManyAttempts(w, wait)})
+		return
+	}
+
+	ok, err := s.checkSecondFactor(user, r.FormValue("code"))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if !ok {
+		if err := s.loginFailed(keys); err != nil {
+			serverError(w, err)
+			return
+		}
+		s.notify(s.loginEvent(eventLoginFailed, r, user.Username))
+		s.loginCodeForm(w, r, http.StatusUnauthorized, []string{errLoginCode})
+		return
+	}
+
+	if err := s.limiter.reset("user:" + user.Username); err != nil {
+		serverError(w, err)
+		return
+	}
+	s.notify(s.loginEvent(eventLoginSucceeded, r, user.Username))
+	s.startSession(w, r, session, Sesh{Name: user.Username, Authenticated: true}, "/admin")
+}
+
+func (s *Server) loginCodeForm(w http.ResponseWriter, r *http.Request, status int, errors []string) {
+	token, err := s.csrfToken(w, r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if DEV {
+		loginCodeTemplate = setLoginCodeTemplate()
+	}
+	w.WriteHeader(status)
+	tmpl := loginCodeTemplate
+	tmpl.Execute(w, struct {
+		Errors []string
+		Viewer
+		Dev         bool
+		Description string
+	}{errors, Viewer{CSRFToken: token}, DEV, defaultDescription})
+}
+
+// What the two-factor page shows besides the user's status.
+type twoFactorState struct {
+	// The secret being set up, for typing in when the QR code can't be scanned.
+	Secret string
+	// Only shown right after they're made.
+	RecoveryCodes []string
+	Errors        []string
+	Message       string
+}
+
+// Turns two-factor authentication on and off, and remakes recovery codes.
+func (s *Server) TwoFactorPage(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	s.twoFactorForm(w, r, http.StatusOK, user, twoFactorState{})
+}
+
+// Serves the QR code of the secret being set up.
+func (s *Server) TwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	session, _ := s.sessionStore.Get(r, "user")
+	sesh := s.sessionStore.getSesh(session)
+	if sesh.EnrollSecret == "" {
+		notFound(w)
+		return
+	}
+	png, err := qrcode.Encode(totpURI(sesh.EnrollSecret, sesh.Name), qrcode.Medium, 256)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+// Turns on two-factor authentication once the code field shows the app has the secret.
+func (s *Server) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	session, _ := s.sessionStore.Get(r, "user")
+	sesh := s.sessionStore.getSesh(session)
+	if user.TOTPSecret != "" || sesh.EnrollSecret == "" {
+		http.Redirect(w, r, "/admin/2fa", http.StatusSeeOther)
+		return
+	}
+
+	step, ok := checkTOTP(sesh.EnrollSecret, strings.TrimSpace(r.FormValue("code")), s.clock.Now())
+	if !ok {
+		s.twoFactorForm(w, r, http.StatusBadRequest, user, twoFactorState{Errors: []string{errTOTPCode}})
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := s.store.setTOTP(user.Username, sesh.EnrollSecret, hashes); err != nil {
+		serverError(w, err)
+		return
+	}
+	// So the code just typed in can't be used to log in.
+	if _, err := s.store.useTOTPStep(user.Id, step); err != nil {
+		serverError(w, err)
+		return
+	}
+	user.TOTPSecret = sesh.EnrollSecret
+
+	sesh.EnrollSecret = ""
+	s.sessionStore.Set(session, sesh)
+	if err := s.sessionStore.SaveSession(r, w, session); err != nil {
+		serverError(w, err)
+		return
+	}
+	s.twoFactorForm(w, r, http.StatusOK, user, twoFactorState{RecoveryCodes: codes, Message: twoFactorEnabled})
+}
+
+// Needs the password, so someone using a session left logged in can't turn it off.
+func (s *Server) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.checkTwoFactorPassword(w, r)
+	if !ok {
+		return
+	}
+	if err := s.store.setTOTP(user.Username, "", nil); err != nil {
+		serverError(w, err)
+		return
+	}
+	user.TOTPSecret = ""
+	s.twoFactorForm(w, r, http.StatusOK, user, twoFactorState{Message: twoFactorDisabled})
+}
+
+// Replaces all of the user's recovery codes with new ones.
+func (s *Server) ResetRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.checkTwoFactorPassword(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPSecret == "" {
+		http.Redirect(w, r, "/admin/2fa", http.StatusSeeOther)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := s.store.setRecoveryCodes(user.Id, hashes); err != nil {
+		serverError(w, err)
+		return
+	}
+	s.twoFactorForm(w, r, http.StatusOK, user, twoFactorState{RecoveryCodes: codes, Message: recoveryCodesReset})
+}
+
+// The logged in user, if the password field is theirs. Otherwise responds with the page and an error.
+func (s *Server) checkTwoFactorPassword(w http.ResponseWriter, r *http.Request) (User, bool) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return User{}, false
+	}
+	if !user.checkPassword(r.FormValue("password")) {
+		s.twoFactorForm(w, r, http.StatusBadRequest, user, twoFactorState{Errors: []string{errPasswordCurrent}})
+		return User{}, false
+	}
+	return user, true
+}
+
+// Users without two-factor authentication get a secret to set up, kept in the session until it's checked.
+func (s *Server) twoFactorForm(w http.ResponseWriter, r *http.Request, status int, user User, state twoFactorState) {
+	recoveryLeft := 0
+	if user.TOTPSecret != "" {
+		var err error
+		recoveryLeft, err = s.store.countRecoveryCodes(user.Id)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+	} else {
+		session, _ := s.sessionStore.Get(r, "user")
+		sesh := s.sessionStore.getSesh(session)
+		if sesh.EnrollSecret == "" {
+			secret, err := newTOTPSecret()
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+			sesh.EnrollSecret = secret
+			s.sessionStore.Set(session, sesh)
+			if err := s.sessionStore.SaveSession(r, w, session); err != nil {
+				serverError(w, err)
+				return
+			}
+		}
+		state.Secret = sesh.EnrollSecret
+	}
+
+	v := s.viewer(w, r)
+	if DEV {
+		twoFactorTemplate = setTwoFactorTemplate()
+	}
+	w.WriteHeader(status)
+	tmpl := twoFactorTemplate
+	tmpl.Execute(w, struct {
+		Enabled      bool
+		RecoveryLeft int
+		twoFactorState
+		Viewer
+		Dev         bool
+		Description string
+	}{user.TOTPSecret != "", recoveryLeft, state, v, DEV, defaultDescription})
+}
+
+func setLoginCodeTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/loginCode.html"))
+}
+
+func setTwoFactorTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/twoFactor.html"))
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test secret from RFC 6238, "12345678901234567890".
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+var twoFactorRoleRoutes = []roleRoute{
+	{"GET", "/admin/2fa", allowAnyone, true},
+	{"POST", "/admin/2fa", allowAnyone, false},
+	{"GET", "/admin/2fa/qr.png", allowAnyone, false},
+	{"POST", "/admin/2fa/disable", allowAnyone, false},
+	{"POST", "/admin/2fa/recovery-codes", allowAnyone, false},
+}
+
+func TestTOTPCode(t *testing.T) {
+	// The last six digits of the RFC's eight digit codes.
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		got, err := totpCode(rfcTOTPSecret, totpStep(time.Unix(c.unix, 0)))
+		assertNoError(t, err)
+		if got != c.want {
+			t.Errorf("at %d got %s, want %s", c.unix, got, c.want)
+		}
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := totpStep(now)
+	codeAt := func(s int64) string {
+		code, err := totpCode(rfcTOTPSecret, s)
+		assertNoError(t, err)
+		return code
+	}
+
+	for _, drift := range []int64{-1, 0, 1} {
+		got, ok := checkTOTP(rfcTOTPSecret, codeAt(step+drift), now)
+		if !ok || got != step+drift {
+			t.Errorf("code %d steps away got step %d and %v, want it accepted", drift, got, ok)
+		}
+	}
+	for _, drift := range []int64{-2, 2} {
+		if _, ok := checkTOTP(rfcTOTPSecret, codeAt(step+drift), now); ok {
+			t.Errorf("code %d steps away was accepted", drift)
+		}
+	}
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := checkTOTP(rfcTOTPSecret, code, now); ok {
+			t.Errorf("%q was accepted", code)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	assertNoError(t, err)
+	assertInt(t, len(codes), recoveryCodeCount)
+	assertInt(t, len(hashes), recoveryCodeCount)
+
+	seen := map[string]bool{}
+	for i, c := range codes {
+		if !regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`).MatchString(c) {
+			t.Errorf("badly formed code %q", c)
+		}
+		if seen[c] {
+			t.Errorf("code %q made twice", c)
+		}
+		seen[c] = true
+		if strings.Contains(hashes[i], c) {
+			t.Error("hash contains the code")
+		}
+	}
+
+	if hashRecoveryCode(" ABCDE fghij ") != hashRecoveryCode("abcde-fghij") {
+		t.Error("case, spaces and dashes should not matter")
+	}
+}
+
+func TestFileSystemStoreTwoFactor(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, makeRoleUsers())
+	defer closeDB()
+
+	codes, hashes, err := newRecoveryCodes()
+	assertNoError(t, err)
+	assertNoError(t, store.setTOTP("author", rfcTOTPSecret, hashes))
+	u, err := store.getUser("author")
+	assertNoError(t, err)
+	if u.TOTPSecret != rfcTOTPSecret {
+		t.Fatalf("got secret %q", u.TOTPSecret)
+	}
+
+	t.Run("steps are used once, in order", func(t *testing.T) {
+		for _, c := range []struct {
+			step int64
+			want bool
+		}{{100, true}, {100, false}, {99, false}, {101, true}} {
+			got, err := store.useTOTPStep(u.Id, c.step)
+			assertNoError(t, err)
+			if got != c.want {
+				t.Errorf("step %d got %v, want %v", c.step, got, c.want)
+			}
+		}
+	})
+
+	t.Run("recovery codes are used once", func(t *testing.T) {
+		ok, err := store.useRecoveryCode(u.Id, hashRecoveryCode(codes[0]))
+		assertNoError(t, err)
+		if !ok {
+			t.Error("recovery code not accepted")
+		}
+		ok, err = store.useRecoveryCode(u.Id, hashRecoveryCode(codes[0]))
+		assertNoError(t, err)
+		if ok {
+			t.Error("recovery code accepted twice")
+		}
+		n, err := store.countRecoveryCodes(u.Id)
+		assertNoError(t, err)
+		assertInt(t, n, recoveryCodeCount-1)
+	})
+
+	t.Run("turning it off removes the recovery codes", func(t *testing.T) {
+		assertNoError(t, store.setTOTP("author", "", nil))
+		u, err := store.getUser("author")
+		assertNoError(t, err)
+		if u.TOTPSecret != "" {
+			t.Errorf("got secret %q, want none", u.TOTPSecret)
+		}
+		n, err := store.countRecoveryCodes(u.Id)
+		assertNoError(t, err)
+		assertInt(t, n, 0)
+	})
+
+	t.Run("deleting the user removes their recovery codes", func(t *testing.T) {
+		_, hashes, err := newRecoveryCodes()
+		assertNoError(t, err)
+		assertNoError(t, store.setTOTP("author", rfcTOTPSecret, hashes))
+		assertNoError(t, store.deleteUser("author"))
+		n, err := store.countRecoveryCodes(u.Id)
+		assertNoError(t, err)
+		assertInt(t, n, 0)
+	})
+}
+
+func TestTwoFactorRoutes(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{admin})
+	defer closeDB()
+	clock := newFakeClock()
+	server := NewServer(store, NewMemorySessionStore())
+	server.clock = clock
+	b := &testBrowser{server: server}
+
+	logIn := func(t *testing.T) *http.Response {
+		t.Helper()
+		return b.submit(t, "/admin/login", "/admin/login", userData("admin", "password")).Result()
+	}
+	logOut := func(t *testing.T) {
+		t.Helper()
+		b.submit(t, "/admin", "/admin/logout", url.Values{})
+		b.cookies = nil
+	}
+	codeNow := func(t *testing.T, secret string) string {
+		t.Helper()
+		code, err := totpCode(secret, totpStep(clock.Now()))
+		assertNoError(t, err)
+		return code
+	}
+
+	logIn(t)
+	var secret string
+	var recovery []string
+
+	t.Run("set up", func(t *testing.T) {
+		resp := b.get(t, "/admin/2fa")
+		assertStatus(t, resp.Code, http.StatusOK)
+		m := regexp.MustCompile(`<code id="totp-secret">([A-Z2-7]+)</code>`).FindStringSubmatch(resp.Body.String())
+		if m == nil {
+			t.Fatal("no secret on the page")
+		}
+		secret = m[1]
+
+		qr := b.get(t, "/admin/2fa/qr.png")
+		assertStatus(t, qr.Code, http.StatusOK)
+		assertContains(t, qr.Header().Get("Content-Type"), "image/png")
+		if !bytes.HasPrefix(qr.Body.Bytes(), []byte("\x89PNG")) {
+			t.Error("QR code is not a PNG")
+		}
+
+		resp = b.submit(t, "/admin/2fa", "/admin/2fa", url.Values{"code": {"000000"}})
+		assertStatus(t, resp.Code, http.StatusBadRequest)
+		assertContains(t, resp.Body.String(), errTOTPCode)
+
+		resp = b.submit(t, "/admin/2fa", "/admin/2fa", url.Values{"code": {codeNow(t, secret)}})
+		assertStatus(t, resp.Code, http.StatusOK)
+		assertContains(t, resp.Body.String(), "Two-factor authentication is on")
+		m = regexp.MustCompile(`(?s)<pre id="recovery-codes">(.*?)</pre>`).FindStringSubmatch(resp.Body.String())
+		if m == nil {
+			t.Fatal("no recovery codes shown")
+		}
+		recovery = strings.Fields(m[1])
+		assertInt(t, len(recovery), recoveryCodeCount)
+
+		assertStatus(t, b.get(t, "/admin/2fa/qr.png").Code, http.StatusNotFound)
+	})
+
+	logOut(t)
+
+	t.Run("the password alone does not log in", func(t *testing.T) {
+		resp := logIn(t)
+		assertStatus(t, resp.StatusCode, http.StatusSeeOther)
+		assertContains(t, resp.Header.Get("Location"), "/admin/login/code")
+		assertStatus(t, b.get(t, "/admin").Code, http.StatusSeeOther)
+		assertStatus(t, b.get(t, "/admin/login/code").Code, http.StatusOK)
+	})
+
+	t.Run("the code used to set up can't log in", func(t *testing.T) {
+		resp := b.submit(t, "/admin/login/code", "/admin/login/code", url.Values{"code": {codeNow(t, secret)}})
+		assertStatus(t, resp.Code, http.StatusUnauthorized)
+		assertContains(t, resp.Body.String(), errLoginCode)
+	})
+
+	t.Run("a code from a drifted clock logs in", func(t *testing.T) {
+		clock.Advance(totpPeriod)
+		code, err := totpCode(secret, totpStep(clock.Now())+1)
+		assertNoError(t, err)
+		resp := b.submit(t, "/admin/login/code", "/admin/login/code", url.Values{"code": {code}})
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+		assertContains(t, resp.Header().Get("Location"), "/admin")
+		assertStatus(t, b.get(t, "/admin").Code, http.StatusOK)
+	})
+
+	logOut(t)
+
+	t.Run("recovery codes log in once", func(t *testing.T) {
+		logIn(t)
+		resp := b.submit(t, "/admin/login/code", "/admin/login/code", url.Values{"code": {strings.ToUpper(recovery[0])}})
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+		assertContains(t, b.get(t, "/admin/2fa").Body.String(), "9 recovery codes left")
+		logOut(t)
+
+		logIn(t)
+		resp = b.submit(t, "/admin/login/code", "/admin/login/code", url.Values{"code": {recovery[0]}})
+		assertStatus(t, resp.Code, http.StatusUnauthorized)
+		assertStatus(t, b.get(t, "/admin").Code, http.StatusSeeOther)
+
+		resp = b.submit(t, "/admin/login/code", "/admin/login/code", url.Values{"code": {recovery[1]}})
+		assertStatus(t, resp.Code, http.StatusSeeOther)
+	})
+
+	t.Run("new recovery codes replace the old ones", func(t *testing.T) {
+		resp := b.submit(t, "/admin/2fa", "/admin/2fa/recovery-codes", url.Values{"password": {"wrong"}})
+		assertStatus(t, resp.Code, http.StatusBadRequest)
+
+		resp = b.submit(t, "/admin/2fa", "/admin/2fa/recovery-codes", url.Values{"password": {"password"}})
+		assertStatus(t, resp.Code, http.StatusOK)
+		assertContains(t, resp.Body.String(), recoveryCodesReset)
+		assertContains(t, resp.Body.String(), "10 recovery codes left")
+		assertNotContain(t, resp.Body.String(), recovery[2])
+	})
+
+	t.Run("turning it off needs the password", func(t *testing.T) {
+		resp := b.submit(t, "/admin/2fa", "/admin/2fa/disable", url.Values{"password": {"wrong"}})
+		assertStatus(t, resp.Code, http.StatusBadRequest)
+		assertContains(t, resp.Body.String(), errPasswordCurrent)
+
+		resp = b.submit(t, "/admin/2fa", "/admin/2fa/disable", url.Values{"password": {"password"}})
+		assertStatus(t, resp.Code, http.StatusOK)
+		assertContains(t, resp.Body.String(), twoFactorDisabled)
+
+		logOut(t)
+		resp2 := logIn(t)
+		assertContains(t, resp2.Header.Get("Location"), "/admin")
+		assertNotContain(t, resp2.Header.Get("Location"), "code")
+	})
+
+	t.Run("the code page needs a password first", func(t *testing.T) {
+		b.cookies = nil
+		assertStatus(t, b.get(t, "/admin/login/code").Code, http.StatusSeeOther)
+	})
+}
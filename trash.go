@@ -0,0 +1,93 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// How long articles stay in the trash before they're purged for good.
+var trashRetention = 30 * 24 * time.Hour
+
+func (s *Server) AdminTrash(w http.ResponseWriter, r *http.Request) {
+	trash, err := s.store.getTrash()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	v := s.viewer(w, r)
+	if DEV {
+		trashTemplate = setTrashTemplate()
+	}
+	tmpl := trashTemplate
+	tmpl.Execute(w, struct {
+		Articles   []Article
+		RetainDays int
+		Viewer
+		Dev         bool
+		Description string
+	}{trash, int(trashRetention.Hours() / 24), v, DEV, defaultDescription})
+}
+
+// Asks before moving an article to the trash. The delete itself has to be a POST, so a link can't do it.
+func (s *Server) DeleteArticleForm(w http.ResponseWriter, r *http.Request) {
+	id, article, err := s.store.getArticle(mux.Vars(r)["slug"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if id == 0 || article.DeletedAt != "" {
+		notFound(w)
+		return
+	}
+
+	v := s.viewer(w, r)
+	if DEV {
+		deleteArticleTemplate = setDeleteArticleTemplate()
+	}
+	tmpl := deleteArticleTemplate
+	tmpl.Execute(w, struct {
+		Article    Article
+		RetainDays int
+		Viewer
+		Dev         bool
+		Description string
+	}{article, int(trashRetention.Hours() / 24), v, DEV, defaultDescription})
+}
+
+func (s *Server) RestoreArticle(w http.ResponseWriter, r *http.Request) {
+	s.trashAction(w, r, s.store.restoreArticle)
+}
+
+func (s *Server) PurgeArticle(w http.ResponseWriter, r *http.Request) {
+	s.trashAction(w, r, s.store.deleteArticle)
+}
+
+// Runs action on a trashed article, then goes back to the trash.
+func (s *Server) trashAction(w http.ResponseWriter, r *http.Request, action func(id int) error) {
+	id, article, err := s.store.getArticle(mux.Vars(r)["slug"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if id == 0 || article.DeletedAt == "" {
+		notFound(w)
+		return
+	}
+	if err := action(id); err != nil {
+		serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+func setTrashTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/trash.html"))
+}
+
+func setDeleteArticleTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/deleteArticle.html"))
+}
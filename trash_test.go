@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFileSystemStoreTrash(t *testing.T) {
+	clock := newFakeClock()
+	articles := MakeArticlesOfCategory(3, clock.Now(), progCat)
+	for i := range articles {
+		articles[i].Tags = []string{"go"}
+	}
+	trashed := articles[0]
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+	defer closeDB()
+
+	id, _, err := store.getArticle(trashed.Slug)
+	assertNoError(t, err)
+	assertNoError(t, store.trashArticle(id, myTimeToString(clock.Now())))
+
+	t.Run("trashed articles aren't listed", func(t *testing.T) {
+		assertInt(t, countArticles(t, store), len(articles)-1)
+
+		_, total, err := store.getPage(progCat, 1, defaultPerPage)
+		assertNoError(t, err)
+		assertInt(t, total, len(articles)-1)
+
+		_, total, err = store.getTagPage("go", 1, defaultPerPage)
+		assertNoError(t, err)
+		assertInt(t, total, len(articles)-1)
+	})
+
+	t.Run("trashed articles keep their slug", func(t *testing.T) {
+		_, got, err := store.getArticle(trashed.Slug)
+		assertNoError(t, err)
+		if got.DeletedAt != myTimeToString(clock.Now()) {
+			t.Errorf("got deleted at %q, want %q", got.DeletedAt, myTimeToString(clock.Now()))
+		}
+
+		err = store.newArticle(trashed)
+		if !errors.Is(err, errSlugTaken) {
+			t.Errorf("got %v, want %v", err, errSlugTaken)
+		}
+	})
+
+	t.Run("trash", func(t *testing.T) {
+		got, err := store.getTrash()
+		assertNoError(t, err)
+		assertInt(t, len(got), 1)
+		if got[0].Slug != trashed.Slug {
+			t.Errorf("got %s in the trash, want %s", got[0].Slug, trashed.Slug)
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		assertNoError(t, store.restoreArticle(id))
+		assertInt(t, countArticles(t, store), len(articles))
+
+		got, err := store.getTrash()
+		assertNoError(t, err)
+		assertInt(t, len(got), 0)
+	})
+
+	t.Run("purging only deletes articles trashed long enough ago", func(t *testing.T) {
+		assertNoError(t, store.trashArticle(id, myTimeToString(clock.Now())))
+
+		n, err := store.purgeTrash(myTimeToString(clock.Now().Add(-time.Second)))
+		assertNoError(t, err)
+		assertInt(t, n, 0)
+
+		n, err = store.purgeTrash(myTimeToString(clock.Now()))
+		assertNoError(t, err)
+		assertInt(t, n, 1)
+
+		gotID, _, err := store.getArticle(trashed.Slug)
+		assertNoError(t, err)
+		assertInt(t, gotID, 0)
+		assertInt(t, countArticles(t, store), len(articles)-1)
+	})
+}
+
+func TestSchedulerPurgesTrash(t *testing.T) {
+	clock := newFakeClock()
+	articles := MakeArticlesOfCategory(1, clock.Now(), progCat)
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{})
+	defer closeDB()
+
+	id, _, err := store.getArticle(articles[0].Slug)
+	assertNoError(t, err)
+	assertNoError(t, store.trashArticle(id, myTimeToString(clock.Now())))
+
+	scheduler := NewScheduler(store, clock, time.Hour, 7*24*time.Hour)
+	clock.Advance(7*24*time.Hour - time.Minute)
+	assertInt(t, scheduler.purgeDue(), 0)
+	clock.Advance(time.Minute)
+	assertInt(t, scheduler.purgeDue(), 1)
+}
+
+func TestTrashRoutes(t *testing.T) {
+	articles := MakeArticlesOfCategory(2, time.Now().UTC(), progCat)
+	article := articles[0]
+
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, articles, []User{admin})
+	defer closeDB()
+	sessStore := StubSessionStore{}
+	server := NewServer(store, &sessStore)
+
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, path))
+		return resp
+	}
+	post := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, path, url.Values{}))
+		return resp
+	}
+
+	t.Run("trash needs a login", func(t *testing.T) {
+		assertStatus(t, get(t, "/admin/trash").Code, 303)
+		assertStatus(t, post(t, "/admin/trash/"+article.Slug+"/restore").Code, 401)
+		assertStatus(t, post(t, "/admin/trash/"+article.Slug+"/purge").Code, 401)
+	})
+
+	sessStore.sesh = Sesh{Name: admin.Username, Authenticated: true}
+
+	t.Run("deleting moves an article to the trash", func(t *testing.T) {
+		server.ServeHTTP(httptest.NewRecorder(), newDeleteRequest(t, article.Slug))
+
+		resp := get(t, "/admin/trash")
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Body.String(), `action="/admin/trash/`+article.Slug+`/restore"`)
+	})
+
+	t.Run("trashed articles are gone", func(t *testing.T) {
+		sessStore.sesh.Authenticated = false
+		defer func() { sessStore.sesh.Authenticated = true }()
+
+		assertStatus(t, get(t, "/"+article.Slug).Code, 410)
+		assertStatus(t, get(t, "/does-not-exist").Code, 404)
+	})
+
+	t.Run("only trashed articles can be restored or purged", func(t *testing.T) {
+		assertStatus(t, post(t, "/admin/trash/"+articles[1].Slug+"/purge").Code, 404)
+		assertStatus(t, post(t, "/admin/trash/does-not-exist/restore").Code, 404)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		assertStatus(t, post(t, "/admin/trash/"+article.Slug+"/restore").Code, 303)
+		assertStatus(t, get(t, "/"+article.Slug).Code, 200)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		server.ServeHTTP(httptest.NewRecorder(), newDeleteRequest(t, article.Slug))
+		assertStatus(t, post(t, "/admin/trash/"+article.Slug+"/purge").Code, 303)
+		assertStatus(t, get(t, "/"+article.Slug).Code, 404)
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const minPasswordLength = 10
+
+const (
+	errPasswordCurrent  = "Current password is incorrect"
+	errPasswordShort    = "New password must be at least 10 characters"
+	errPasswordMismatch = "New passwords do not match"
+	passwordChanged     = "Password changed"
+
+	errUserNoUsername = "Username is required"
+	errUserBadRole    = "Role must be admin, editor or author"
+	errUserTaken      = "Username is already in use"
+	errUserLastAdmin  = "The last admin can not be removed or demoted"
+	errUserSaveFailed = "Could not save the user, please try again"
+)
+
+var (
+	// Returned by Store writes when another user has the username.
+	errUsernameTaken = errors.New("username is already in use")
+	// Returned by Store writes that would leave nobody able to manage users.
+	errLastAdmin = errors.New("can't remove the last admin")
+)
+
+// Creates the admin from the environment the first time the blog runs.
+// Once there's a user the environment is ignored, change the password from the admin panel instead.
+func bootstrapAdmin(store *FileSystemStore, username, email, password string) error {
+	n, err := store.countUsers()
+	if err != nil || n > 0 {
+		return err
+	}
+	if username == "" || password == "" {
+		return errors.New("no users yet, set blog_username and blog_password to create the admin")
+	}
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("Environment variable invalid: blog_password, must be at least %d characters", minPasswordLength)
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	created, err := store.bootstrapUser(User{Username: username, Email: email, Password_Hash: hash})
+	if err != nil {
+		return err
+	}
+	if created {
+		log.Printf("Created admin user %s", username)
+	}
+	return nil
+}
+
+func (s *Server) PasswordForm(w http.ResponseWriter, r *http.Request) {
+	passwordForm(w, http.StatusOK, nil, "", s.viewer(w, r))
+}
+
+func (s *Server) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	newPassword := r.FormValue("new_password")
+	errors := validateNewPassword(newPassword, r.FormValue("confirm_password"))
+	if !user.checkPassword(r.FormValue("current_password")) {
+		errors = append([]string{errPasswordCurrent}, errors...)
+	}
+	if len(errors) != 0 {
+		passwordForm(w, http.StatusBadRequest, errors, "", s.viewer(w, r))
+		return
+	}
+
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := s.store.setPassword(user.Username, hash); err != nil {
+		serverError(w, err)
+		return
+	}
+	// Anyone else who was logged in with the old password has to log in with the new one.
+	if err := s.logOutEverywhere(r, user.Username, true); err != nil {
+		serverError(w, err)
+		return
+	}
+	passwordForm(w, http.StatusOK, nil, passwordChanged, s.viewer(w, r))
+}
+
+func validateNewPassword(password, confirm string) []string {
+	var errors []string
+	if len(password) < minPasswordLength {
+		errors = append(errors, errPasswordShort)
+	}
+	if password != confirm {
+		errors = append(errors, errPasswordMismatch)
+	}
+	return errors
+}
+
+func passwordForm(w http.ResponseWriter, status int, errors []string, message string, v Viewer) {
+	if DEV {
+		passwordTemplate = setPasswordTemplate()
+	}
+	w.WriteHeader(status)
+	tmpl := passwordTemplate
+	tmpl.Execute(w, struct {
+		Errors  []string
+		Message string
+		Viewer
+		Dev         bool
+		Description string
+	}{errors, message, v, DEV, defaultDescription})
+}
+
+func (s *Server) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	s.usersPage(w, r, http.StatusOK, User{}, nil)
+}
+
+// Creates a user from the username, email, password, confirm_password and role fields.
+func (s *Server) NewUser(w http.ResponseWriter, r *http.Request) {
+	u := User{
+		Username: strings.TrimSpace(r.FormValue("username")),
+		Email:    strings.TrimSpace(r.FormValue("email")),
+		Role:     r.FormValue("role"),
+	}
+	password := r.FormValue("password")
+
+	var errors []string
+	if u.Username == "" {
+		errors = append(errors, errUserNoUsername)
+	}
+	if !isValidRole(u.Role) {
+		errors = append(errors, errUserBadRole)
+	}
+	errors = append(errors, validateNewPassword(password, r.FormValue("confirm_password"))...)
+	if len(errors) != 0 {
+		s.usersPage(w, r, http.StatusBadRequest, u, errors)
+		return
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	u.Password_Hash = hash
+	if err := s.store.newUser(u); err != nil {
+		s.userWriteFailed(w, r, err, u)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// Changes the user's role to the role field.
+func (s *Server) EditUser(w http.ResponseWriter, r *http.Request) {
+	u, err := s.store.getUser(mux.Vars(r)["username"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if u.Id == 0 {
+		notFound(w)
+		return
+	}
+	role := r.FormValue("role")
+	if !isValidRole(role) {
+		s.usersPage(w, r, http.StatusBadRequest, User{}, []string{errUserBadRole})
+		return
+	}
+	if err := s.store.setRole(u.Username, role); err != nil {
+		s.userWriteFailed(w, r, err, User{})
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// Deleted users' articles are kept, but nobody but editors and admins can edit them.
+func (s *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	u, err := s.store.getUser(mux.Vars(r)["username"])
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if u.Id == 0 {
+		notFound(w)
+		return
+	}
+	if err := s.store.deleteUser(u.Username); err != nil {
+		s.userWriteFailed(w, r, err, User{})
+		return
+	}
+	// Otherwise their sessions would log in as whoever is given the username next.
+	if err := s.logOutEverywhere(r, u.Username, false); err != nil {
+		serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// Shows the users page again with the reason a write failed, keeping what was typed into the add form.
+func (s *Server) userWriteFailed(w http.ResponseWriter, r *http.Request, err error, form User) {
+	status, message := http.StatusConflict, ""
+	switch {
+	case errors.Is(err, errUsernameTaken):
+		message = errUserTaken
+	case errors.Is(err, errLastAdmin):
+		message = errUserLastAdmin
+	default:
+		log.Print(err)
+		status, message = http.StatusInternalServerError, errUserSaveFailed
+	}
+	s.usersPage(w, r, status, form, []string{message})
+}
+
+func (s *Server) usersPage(w http.ResponseWriter, r *http.Request, status int, form User, errors []string) {
+	users, err := s.store.getUsers()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if form.Role == "" {
+		form.Role = roleAuthor
+	}
+
+	v := s.viewer(w, r)
+	if DEV {
+		usersTemplate = setUsersTemplate()
+	}
+	w.WriteHeader(status)
+	tmpl := usersTemplate
+	tmpl.Execute(w, struct {
+		Users  []User
+		Roles  []string
+		Form   User
+		Errors []string
+		Viewer
+		Dev         bool
+		Description string
+	}{users, roles, form, errors, v, DEV, defaultDescription})
+}
+
+func setUsersTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/adminUsers.html"))
+}
+
+func setPasswordTemplate() *template.Template {
+	return template.Must(newTemplate("static/templates/base.html", "static/templates/nav.html", "static/templates/password.html"))
+}
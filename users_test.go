@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	// Real hashes are slow on purpose, tests don't need them to be.
+	bcryptCost = bcrypt.MinCost
+}
+
+func TestCheckPassword(t *testing.T) {
+	cases := []struct {
+		name     string
+		user     User
+		password string
+		want     bool
+	}{
+		{"right password", admin, "password", true},
+		{"wrong password", admin, "wrongpassword", false},
+		{"no password", admin, "", false},
+		{"no user", User{}, "", false},
+		{"no user, dummy password", User{}, "not a real password", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.user.checkPassword(c.password); got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFileSystemStoreUsers(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{admin})
+	defer closeDB()
+
+	t.Run("missing user", func(t *testing.T) {
+		got, err := store.getUser("nobody")
+		assertNoError(t, err)
+		if got != (User{}) {
+			t.Errorf("got %v, want no user", got)
+		}
+	})
+
+	t.Run("set password", func(t *testing.T) {
+		hash, err := HashPassword("a new password")
+		assertNoError(t, err)
+		assertNoError(t, store.setPassword(admin.Username, hash))
+
+		got, err := store.getUser(admin.Username)
+		assertNoError(t, err)
+		if !got.checkPassword("a new password") {
+			t.Error("new password doesn't work")
+		}
+		if store.setPassword("nobody", hash) == nil {
+			t.Error("set the password of a user that doesn't exist")
+		}
+	})
+
+	t.Run("usernames are unique", func(t *testing.T) {
+		if store.newUser(admin) == nil {
+			t.Error("added a second user with the same username")
+		}
+	})
+
+	t.Run("migrating removes blank users", func(t *testing.T) {
+		tmpFile, cleanTempFile := makeTempFile()
+		defer cleanTempFile()
+
+		makeV0Database(t, tmpFile.Name(), nil)
+		db, err := sql.Open("sqlite3", tmpFile.Name())
+		assertNoError(t, err)
+		_, err = db.Exec("INSERT INTO Users(Username, Email, Password_Hash) values('', '', '')")
+		assertNoError(t, err)
+		db.Close()
+
+		store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, nil)
+		defer closeDB()
+		n, err := store.countUsers()
+		assertNoError(t, err)
+		assertInt(t, n, 0)
+	})
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, nil)
+	defer closeDB()
+
+	t.Run("needs a username and password", func(t *testing.T) {
+		if bootstrapAdmin(store, "", "", "") == nil {
+			t.Error("got no error")
+		}
+		if bootstrapAdmin(store, "boss", "", "short") == nil {
+			t.Error("got no error for a short password")
+		}
+	})
+
+	t.Run("creates the admin on first run", func(t *testing.T) {
+		assertNoError(t, bootstrapAdmin(store, "boss", "boss@example.com", "first password"))
+		got, err := store.getUser("boss")
+		assertNoError(t, err)
+		if !got.checkPassword("first password") {
+			t.Error("admin can't log in")
+		}
+	})
+
+	t.Run("only once", func(t *testing.T) {
+		assertNoError(t, bootstrapAdmin(store, "boss", "boss@example.com", "second password"))
+		assertNoError(t, bootstrapAdmin(store, "other", "", ""))
+
+		got, err := store.getUser("boss")
+		assertNoError(t, err)
+		if !got.checkPassword("first password") {
+			t.Error("admin's password was changed")
+		}
+		n, err := store.countUsers()
+		assertNoError(t, err)
+		assertInt(t, n, 1)
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	tmpFile, cleanTempFile := makeTempFile()
+	defer cleanTempFile()
+	store, closeDB := mustNewFileSystemStore(t, tmpFile, []Article{}, []User{admin})
+	defer closeDB()
+	sessStore := StubSessionStore{}
+	server := NewServer(store, &sessStore)
+
+	change := func(t *testing.T, current, new, confirm string) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		data := url.Values{"current_password": {current}, "new_password": {new}, "confirm_password": {confirm}}
+		server.ServeHTTP(resp, newPostRequest(t, "/admin/password", data))
+		return resp
+	}
+
+	t.Run("needs a login", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, newGetRequest(t, "/admin/password"))
+		assertStatus(t, resp.Code, 303)
+		assertStatus(t, change(t, "password", "a new password", "a new password").Code, 401)
+	})
+
+	testLogin(t, server)
+
+	t.Run("invalid changes", func(t *testing.T) {
+		cases := []struct {
+			name                  string
+			current, new, confirm string
+			want                  string
+		}{
+			{"wrong current password", "wrongpassword", "a new password", "a new password", errPasswordCurrent},
+			{"short", "password", "short", "short", errPasswordShort},
+			{"mismatch", "password", "a new password", "a new passwort", errPasswordMismatch},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				resp := change(t, c.current, c.new, c.confirm)
+				assertStatus(t, resp.Code, 400)
+				assertContains(t, resp.Body.String(), c.want)
+			})
+		}
+	})
+
+	t.Run("change and log in with the new password", func(t *testing.T) {
+		resp := change(t, "password", "a new password", "a new password")
+		assertStatus(t, resp.Code, 200)
+		assertContains(t, resp.Body.String(), passwordChanged)
+
+		testLogout(t, server)
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/admin/login", userData("admin", "password")))
+		assertStatus(t, resp.Code, 401)
+
+		resp = httptest.NewRecorder()
+		server.ServeHTTP(resp, newPostRequest(t, "/admin/login", userData("admin", "a new password")))
+		assertStatus(t, resp.Code, 303)
+	})
+}